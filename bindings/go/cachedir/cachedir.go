@@ -0,0 +1,348 @@
+// Package cachedir manages the llama-embedder cache directory shared by the Go bindings, the command-line tool and the
+// server: downloaded GGUF models under models/ and shared library versions under libs/.
+package cachedir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvCacheDir is the environment variable overriding the cache root
+const EnvCacheDir = "LLAMA_CACHE_DIR"
+
+const (
+	dirName   = "llama_cache"
+	modelsDir = "models"
+	libsDir   = "libs"
+)
+
+// Kind is the kind of a cache entry
+type Kind string
+
+const (
+	KindModel   Kind = "model"
+	KindLibrary Kind = "library"
+)
+
+// DefaultRoot returns the cache root: LLAMA_CACHE_DIR if set, otherwise llama_cache under XDG_CACHE_HOME, falling back
+// to $HOME/.cache/llama_cache
+func DefaultRoot() string {
+	if dir := os.Getenv(EnvCacheDir); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, dirName)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.Getenv("HOME")
+	}
+	return filepath.Join(home, ".cache", dirName)
+}
+
+// Manager lists and prunes the models and shared libraries of a cache root
+type Manager struct {
+	root string
+}
+
+// New returns a Manager for root, DefaultRoot if root is empty
+func New(root string) *Manager {
+	if root == "" {
+		root = DefaultRoot()
+	}
+	return &Manager{root: root}
+}
+
+// Root returns the cache root
+func (m *Manager) Root() string {
+	return m.root
+}
+
+// ModelDir returns the directory downloaded models are stored in
+func (m *Manager) ModelDir() string {
+	return filepath.Join(m.root, modelsDir)
+}
+
+// LibraryDir returns the directory shared library versions are stored in
+func (m *Manager) LibraryDir() string {
+	return filepath.Join(m.root, libsDir)
+}
+
+// Ensure creates the cache root and its model and library directories
+func (m *Manager) Ensure() error {
+	for _, dir := range []string{m.root, m.ModelDir(), m.LibraryDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("could not create cache directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// Entry is a cached model or shared library version with the files that belong to it, such as the calibration of a
+// model or the downloaded archive of a library
+type Entry struct {
+	Kind  Kind     `json:"kind"`
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+	Size  int64    `json:"size"`
+	// ModTime is the most recent modification of the files of the entry
+	ModTime time.Time `json:"mod_time"`
+	// lockPath is the path downloads of the entry lock, see Lock
+	lockPath string
+}
+
+// List returns the cached models sorted by name followed by the cached library versions. Missing directories are
+// treated as empty. Hidden files, such as lock files and partial downloads, are not listed.
+func (m *Manager) List() ([]Entry, error) {
+	models, err := listDir(m.ModelDir(), KindModel, modelKey)
+	if err != nil {
+		return nil, err
+	}
+	libs, err := listDir(m.LibraryDir(), KindLibrary, libraryKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(models, libs...), nil
+}
+
+// modelKey groups a model with its sidecar files, e.g. model.gguf and model.calibration.json
+func modelKey(name string) (string, bool) {
+	if base, ok := strings.CutSuffix(name, ".calibration.json"); ok {
+		return base, false
+	}
+	if ext := filepath.Ext(name); strings.EqualFold(ext, ".gguf") {
+		return strings.TrimSuffix(name, ext), true
+	}
+	return name, true
+}
+
+// libraryKey groups the extracted library directory with its archive and an interrupted extraction
+func libraryKey(name string) (string, bool) {
+	for _, suffix := range []string{".tar.gz", ".zip", ".tmp"} {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			return base, false
+		}
+	}
+	return name, true
+}
+
+// listDir groups the entries of dir by key; the entry is named after the primary file if there is one
+func listDir(dir string, kind Kind, key func(string) (string, bool)) ([]Entry, error) {
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	byKey := map[string]*Entry{}
+	var keys []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		size, modTime, err := usage(path)
+		if err != nil {
+			return nil, err
+		}
+		k, primary := key(f.Name())
+		e, ok := byKey[k]
+		if !ok {
+			e = &Entry{Kind: kind, Name: f.Name()}
+			byKey[k] = e
+			keys = append(keys, k)
+		}
+		if primary {
+			e.Name = f.Name()
+		}
+		// libraries are locked by their key while downloading, models by their file
+		e.lockPath = filepath.Join(dir, k)
+		if primary || kind == KindModel {
+			e.lockPath = filepath.Join(dir, e.Name)
+		}
+		e.Paths = append(e.Paths, path)
+		e.Size += size
+		if modTime.After(e.ModTime) {
+			e.ModTime = modTime
+		}
+	}
+	sort.Strings(keys)
+	entries := make([]Entry, len(keys))
+	for i, k := range keys {
+		entries[i] = *byKey[k]
+	}
+	return entries, nil
+}
+
+// usage returns the total size and the most recent modification of the files under path
+func usage(path string) (int64, time.Time, error) {
+	var size int64
+	var modTime, dirModTime time.Time
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// directories change whenever a file is added, so only files count unless there are none
+		if info.IsDir() {
+			if dirModTime.IsZero() {
+				dirModTime = info.ModTime()
+			}
+			return nil
+		}
+		size += info.Size()
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+		return nil
+	})
+	if modTime.IsZero() {
+		modTime = dirModTime
+	}
+	return size, modTime, err
+}
+
+// Remove deletes the files of the entry. It holds the lock of the entry meanwhile, so a download of the entry in
+// progress completes first.
+func (m *Manager) Remove(e Entry) error {
+	_, err := m.remove(e, false)
+	return err
+}
+
+// remove deletes the files of the entry under its lock and reports whether it did. With unchanged, an entry modified
+// since it was listed, e.g. downloaded again while remove waited for the lock, is kept.
+func (m *Manager) remove(e Entry, unchanged bool) (bool, error) {
+	for _, path := range e.Paths {
+		if rel, err := filepath.Rel(m.root, path); err != nil || strings.HasPrefix(rel, "..") {
+			return false, fmt.Errorf("%s is not in the cache %s", path, m.root)
+		}
+	}
+	if len(e.Paths) == 0 {
+		return true, nil
+	}
+	lockPath := e.lockPath
+	if lockPath == "" {
+		lockPath = filepath.Join(filepath.Dir(e.Paths[0]), e.Name)
+	}
+	lock, err := Lock(lockPath)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+	if unchanged {
+		for _, path := range e.Paths {
+			_, modTime, err := usage(path)
+			if err == nil && modTime.After(e.ModTime) {
+				return false, nil
+			}
+		}
+	}
+	for _, path := range e.Paths {
+		if err := os.RemoveAll(path); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// PruneOptions select the entries removed by Prune. Entries matching either limit are removed.
+type PruneOptions struct {
+	// Kind limits pruning to models or libraries, both if empty
+	Kind Kind
+	// OlderThan removes the entries not modified for longer than this, 0 disables it
+	OlderThan time.Duration
+	// MaxSize removes the least recently modified entries until the remaining ones take at most MaxSize bytes,
+	// 0 disables it
+	MaxSize int64
+	// DryRun only reports the entries that would be removed
+	DryRun bool
+}
+
+// Prune removes the entries selected by opts and returns them. Entries modified while Prune waited for their lock are
+// kept.
+func (m *Manager) Prune(opts PruneOptions) ([]Entry, error) {
+	entries, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	var candidates []Entry
+	for _, e := range entries {
+		if opts.Kind == "" || e.Kind == opts.Kind {
+			candidates = append(candidates, e)
+		}
+	}
+	// oldest first, so the size budget keeps the most recently used entries
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].ModTime.Before(candidates[j].ModTime)
+	})
+	total := TotalSize(candidates)
+	now := time.Now()
+	var pruned []Entry
+	for _, e := range candidates {
+		expired := opts.OlderThan > 0 && now.Sub(e.ModTime) > opts.OlderThan
+		overBudget := opts.MaxSize > 0 && total > opts.MaxSize
+		if !expired && !overBudget {
+			continue
+		}
+		if !opts.DryRun {
+			removed, err := m.remove(e, true)
+			if err != nil {
+				return pruned, err
+			}
+			if !removed {
+				continue
+			}
+		}
+		total -= e.Size
+		pruned = append(pruned, e)
+	}
+	return pruned, nil
+}
+
+// TotalSize returns the total size of the entries in bytes
+func TotalSize(entries []Entry) int64 {
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	return total
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+}
+
+// ParseSize parses a size such as 512MB, 10GB or 1048576 (bytes); units are powers of 1024
+func ParseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			v, unit = strings.TrimSpace(n), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// FormatSize formats a size in bytes with the largest unit that keeps the value at least 1, e.g. 1.5GB
+func FormatSize(size int64) string {
+	for _, u := range sizeUnits {
+		if size >= u.bytes && u.bytes > 1 {
+			return strconv.FormatFloat(float64(size)/float64(u.bytes), 'f', 1, 64) + u.suffix
+		}
+	}
+	return strconv.FormatInt(size, 10) + "B"
+}
@@ -0,0 +1,153 @@
+package cachedir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultRoot(t *testing.T) {
+	t.Setenv(EnvCacheDir, "/srv/llama")
+	t.Setenv("XDG_CACHE_HOME", "/xdg")
+	require.Equal(t, "/srv/llama", DefaultRoot())
+
+	t.Setenv(EnvCacheDir, "")
+	require.Equal(t, filepath.Join("/xdg", "llama_cache"), DefaultRoot())
+
+	t.Setenv("XDG_CACHE_HOME", "relative")
+	t.Setenv("HOME", "/home/user")
+	require.Equal(t, filepath.Join("/home/user", ".cache", "llama_cache"), DefaultRoot())
+}
+
+// writeFile creates a file of size bytes under the cache modified age ago
+func writeFile(t *testing.T, path string, size int, age time.Duration) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0644))
+	modTime := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func newTestCache(t *testing.T) *Manager {
+	m := New(t.TempDir())
+	require.NoError(t, m.Ensure())
+	day := 24 * time.Hour
+	writeFile(t, filepath.Join(m.ModelDir(), "old.gguf"), 400, 30*day)
+	writeFile(t, filepath.Join(m.ModelDir(), "old.calibration.json"), 100, 30*day)
+	writeFile(t, filepath.Join(m.ModelDir(), "new.GGUF"), 300, day)
+	lib := "llama-embedder-linux-x64-v0.0.8"
+	writeFile(t, filepath.Join(m.LibraryDir(), lib, "libllama-embedder.so"), 200, 10*day)
+	writeFile(t, filepath.Join(m.LibraryDir(), lib+".tar.gz"), 50, 10*day)
+	return m
+}
+
+func TestList(t *testing.T) {
+	m := newTestCache(t)
+	entries, err := m.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	require.Equal(t, KindModel, entries[0].Kind)
+	require.Equal(t, "new.GGUF", entries[0].Name)
+	require.Equal(t, "old.gguf", entries[1].Name)
+	require.Equal(t, int64(500), entries[1].Size, "the calibration belongs to the model")
+	require.Len(t, entries[1].Paths, 2)
+
+	require.Equal(t, KindLibrary, entries[2].Kind)
+	require.Equal(t, "llama-embedder-linux-x64-v0.0.8", entries[2].Name)
+	require.Equal(t, int64(250), entries[2].Size, "the archive belongs to the library")
+	require.Equal(t, int64(1050), TotalSize(entries))
+
+	empty, err := New(filepath.Join(t.TempDir(), "missing")).List()
+	require.NoError(t, err)
+	require.Empty(t, empty)
+}
+
+func TestPrune(t *testing.T) {
+	t.Run("By age", func(t *testing.T) {
+		m := newTestCache(t)
+		pruned, err := m.Prune(PruneOptions{OlderThan: 7 * 24 * time.Hour})
+		require.NoError(t, err)
+		require.Len(t, pruned, 2)
+		require.Equal(t, "old.gguf", pruned[0].Name)
+		require.NoFileExists(t, filepath.Join(m.ModelDir(), "old.calibration.json"))
+		require.NoDirExists(t, filepath.Join(m.LibraryDir(), "llama-embedder-linux-x64-v0.0.8"))
+		require.FileExists(t, filepath.Join(m.ModelDir(), "new.GGUF"))
+	})
+
+	t.Run("By size keeps the most recent entries", func(t *testing.T) {
+		m := newTestCache(t)
+		pruned, err := m.Prune(PruneOptions{MaxSize: 600})
+		require.NoError(t, err)
+		require.Len(t, pruned, 1)
+		require.Equal(t, "old.gguf", pruned[0].Name)
+		entries, err := m.List()
+		require.NoError(t, err)
+		require.Equal(t, int64(550), TotalSize(entries))
+	})
+
+	t.Run("Kind and dry run", func(t *testing.T) {
+		m := newTestCache(t)
+		pruned, err := m.Prune(PruneOptions{Kind: KindLibrary, MaxSize: 1, DryRun: true})
+		require.NoError(t, err)
+		require.Len(t, pruned, 1)
+		require.Equal(t, KindLibrary, pruned[0].Kind)
+		entries, err := m.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 3, "a dry run must not remove anything")
+	})
+}
+
+func TestPruneWaitsForDownloads(t *testing.T) {
+	m := newTestCache(t)
+	model := filepath.Join(m.ModelDir(), "old.gguf")
+	lock, err := Lock(model)
+	require.NoError(t, err)
+	entries, err := m.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 3, "lock files are not listed")
+
+	done := make(chan []Entry)
+	go func() {
+		pruned, err := m.Prune(PruneOptions{Kind: KindModel, OlderThan: 7 * 24 * time.Hour})
+		require.NoError(t, err)
+		done <- pruned
+	}()
+	select {
+	case <-done:
+		t.Fatal("Prune must wait for the lock of the entry")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// the model is downloaded again while Prune waits
+	writeFile(t, model, 400, 0)
+	require.NoError(t, lock.Unlock())
+	require.Empty(t, <-done)
+	require.FileExists(t, model)
+
+	require.NoError(t, m.Remove(entries[1]))
+	require.NoFileExists(t, model)
+}
+
+func TestRemoveOutsideCache(t *testing.T) {
+	m := New(t.TempDir())
+	outside := filepath.Join(t.TempDir(), "model.gguf")
+	writeFile(t, outside, 1, 0)
+	require.Error(t, m.Remove(Entry{Paths: []string{outside}}))
+	require.FileExists(t, outside)
+}
+
+func TestSize(t *testing.T) {
+	for s, expected := range map[string]int64{"1024": 1024, "512MB": 512 << 20, "1.5gb": 3 << 29, "10 KB": 10 << 10} {
+		n, err := ParseSize(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, n, s)
+	}
+	for _, s := range []string{"", "GB", "-1", "ten"} {
+		_, err := ParseSize(s)
+		require.Error(t, err, s)
+	}
+	require.Equal(t, "1.5GB", FormatSize(3<<29))
+	require.Equal(t, "512B", FormatSize(512))
+}
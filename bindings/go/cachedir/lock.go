@@ -0,0 +1,93 @@
+package cachedir
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileLock is an exclusive lock coordinating the processes writing the same file of the cache, such as several
+// servers, workers or test binaries downloading the same model at once
+type FileLock struct {
+	f    *os.File
+	path string
+	mu   *sync.Mutex
+}
+
+// locks serializes the goroutines of this process, since the file locks of some platforms are per process
+var (
+	locksMu sync.Mutex
+	locks   = map[string]*sync.Mutex{}
+)
+
+// LockPath returns the lock file guarding path: a hidden file next to it, which List skips
+func LockPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")
+}
+
+// Lock waits for and takes the exclusive lock on path, which does not need to exist. The lock is released with Unlock
+// or when the process exits, so a crashed download does not block the others. Callers check again whether the file
+// was created while they waited.
+func Lock(path string) (*FileLock, error) {
+	lockPath, err := filepath.Abs(LockPath(path))
+	if err != nil {
+		return nil, err
+	}
+	locksMu.Lock()
+	mu, ok := locks[lockPath]
+	if !ok {
+		mu = &sync.Mutex{}
+		locks[lockPath] = mu
+	}
+	locksMu.Unlock()
+	mu.Lock()
+
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		mu.Unlock()
+		return nil, fmt.Errorf("could not create lock directory: %v", err)
+	}
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		mu.Unlock()
+		return nil, fmt.Errorf("could not open lock file: %v", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		mu.Unlock()
+		return nil, fmt.Errorf("could not lock %s: %v", lockPath, err)
+	}
+	return &FileLock{f: f, path: lockPath, mu: mu}, nil
+}
+
+// Unlock releases the lock. The lock file is left in place, removing it would let a waiting process lock a file
+// another one no longer sees.
+func (l *FileLock) Unlock() error {
+	defer l.mu.Unlock()
+	err := unlockFile(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WriteFileAtomic writes r to a hidden temporary file next to path and renames it, so path never holds a partial file
+func WriteFileAtomic(path string, r io.Reader) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".download")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package cachedir
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const lockHelperEnv = "CACHEDIR_LOCK_HELPER"
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models", "model.gguf")
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	holders, maxHolders := 0, 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := Lock(path)
+			require.NoError(t, err)
+			mu.Lock()
+			holders++
+			maxHolders = max(maxHolders, holders)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+			require.NoError(t, l.Unlock())
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, maxHolders)
+	require.FileExists(t, LockPath(path))
+	require.True(t, strings.HasPrefix(filepath.Base(LockPath(path)), "."), "lock files are hidden from List")
+}
+
+// TestLockHelperProcess appends start and end markers to the log while holding the lock, see TestLockAcrossProcesses
+func TestLockHelperProcess(t *testing.T) {
+	log := os.Getenv(lockHelperEnv)
+	if log == "" {
+		t.Skip("helper process")
+	}
+	l, err := Lock(log)
+	require.NoError(t, err)
+	defer l.Unlock()
+	appendLine := func(line string) {
+		f, err := os.OpenFile(log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = fmt.Fprintln(f, line)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	appendLine("start")
+	time.Sleep(50 * time.Millisecond)
+	appendLine("end")
+}
+
+func TestLockAcrossProcesses(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	var cmds []*exec.Cmd
+	for i := 0; i < 3; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+		cmd.Env = append(os.Environ(), lockHelperEnv+"="+log)
+		require.NoError(t, cmd.Start())
+		cmds = append(cmds, cmd)
+	}
+	for _, cmd := range cmds {
+		require.NoError(t, cmd.Wait())
+	}
+	content, err := os.ReadFile(log)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("start\nend\n", 3), string(content), "the processes must not overlap")
+}
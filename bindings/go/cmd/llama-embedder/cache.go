@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/cachedir"
+)
+
+const cacheUsage = `usage: llama-embedder cache <command> [flags]
+
+commands:
+  dir    print the cache root
+  list   list cached models and shared library versions with their sizes
+  prune  remove cached entries by age (-older-than) or total size budget (-max-size)`
+
+// runCache runs the cache subcommand
+func runCache(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprintln(stdout, cacheUsage)
+		return fmt.Errorf("missing cache command")
+	}
+	command := args[0]
+	fs := flag.NewFlagSet("llama-embedder cache "+command, flag.ContinueOnError)
+	cacheDir := fs.String("cache-dir", "", "cache root (default: $LLAMA_CACHE_DIR, $XDG_CACHE_HOME/llama_cache or ~/.cache/llama_cache)")
+	switch command {
+	case "dir":
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, cachedir.New(*cacheDir).Root())
+		return nil
+	case "list":
+		asJSON := fs.Bool("json", false, "print the entries as JSON")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		entries, err := cachedir.New(*cacheDir).List()
+		if err != nil {
+			return err
+		}
+		return printEntries(stdout, entries, *asJSON)
+	case "prune":
+		olderThan := fs.Duration("older-than", 0, "remove entries not modified for longer than this, e.g. 720h")
+		maxSize := fs.String("max-size", "", "remove the oldest entries until the cache fits in this size, e.g. 10GB")
+		kind := fs.String("kind", "", "only prune models or libraries: model or library")
+		dryRun := fs.Bool("dry-run", false, "only print the entries that would be removed")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		opts := cachedir.PruneOptions{OlderThan: *olderThan, DryRun: *dryRun}
+		switch cachedir.Kind(*kind) {
+		case "", cachedir.KindModel, cachedir.KindLibrary:
+			opts.Kind = cachedir.Kind(*kind)
+		default:
+			return fmt.Errorf("unknown kind: %s", *kind)
+		}
+		if *maxSize != "" {
+			size, err := cachedir.ParseSize(*maxSize)
+			if err != nil {
+				return err
+			}
+			opts.MaxSize = size
+		}
+		if opts.OlderThan <= 0 && opts.MaxSize <= 0 {
+			return fmt.Errorf("-older-than or -max-size is required")
+		}
+		pruned, err := cachedir.New(*cacheDir).Prune(opts)
+		if err != nil {
+			return err
+		}
+		verb := "removed"
+		if opts.DryRun {
+			verb = "would remove"
+		}
+		for _, e := range pruned {
+			fmt.Fprintf(stdout, "%s %s %s (%s)\n", verb, e.Kind, e.Name, cachedir.FormatSize(e.Size))
+		}
+		fmt.Fprintf(stdout, "%s %d entries, %s\n", verb, len(pruned), cachedir.FormatSize(cachedir.TotalSize(pruned)))
+		return nil
+	default:
+		fmt.Fprintln(stdout, cacheUsage)
+		return fmt.Errorf("unknown cache command: %s", command)
+	}
+}
+
+func printEntries(w io.Writer, entries []cachedir.Entry, asJSON bool) error {
+	if asJSON {
+		if entries == nil {
+			entries = []cachedir.Entry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tSIZE\tMODIFIED")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Kind, e.Name, cachedir.FormatSize(e.Size), e.ModTime.Format(time.DateTime))
+	}
+	fmt.Fprintf(tw, "total\t\t%s\t\n", cachedir.FormatSize(cachedir.TotalSize(entries)))
+	return tw.Flush()
+}
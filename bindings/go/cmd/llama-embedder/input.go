@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// record is a text to embed with an optional identifier carried to the output
+type record struct {
+	ID   string
+	Text string
+}
+
+type inputFormat string
+
+const (
+	inputText  inputFormat = "text"
+	inputJSONL inputFormat = "jsonl"
+	inputCSV   inputFormat = "csv"
+)
+
+// detectInputFormat infers the input format from the file extension, defaulting to one text per line
+func detectInputFormat(path string) inputFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return inputJSONL
+	case ".csv":
+		return inputCSV
+	default:
+		return inputText
+	}
+}
+
+// readRecords reads the records of r in the given format. For JSONL and CSV inputs textColumn selects the field
+// holding the text and idColumn, if set, the field holding the record id.
+func readRecords(r io.Reader, format inputFormat, textColumn, idColumn string) ([]record, error) {
+	switch format {
+	case inputText:
+		return readTextLines(r)
+	case inputJSONL:
+		return readJSONL(r, textColumn, idColumn)
+	case inputCSV:
+		return readCSV(r, textColumn, idColumn)
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s", format)
+	}
+}
+
+func readTextLines(r io.Reader) ([]record, error) {
+	var records []record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		records = append(records, record{Text: line})
+	}
+	return records, scanner.Err()
+}
+
+// jsonID returns the id field of obj. Non-string ids such as numbers are kept verbatim.
+func jsonID(obj map[string]json.RawMessage, name string) string {
+	raw, ok := obj[name]
+	if !ok {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func readJSONL(r io.Reader, textColumn, idColumn string) ([]record, error) {
+	var records []record
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var obj map[string]json.RawMessage
+		err := dec.Decode(&obj)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSONL record %d: %v", line, err)
+		}
+		raw, ok := obj[textColumn]
+		if !ok {
+			return nil, fmt.Errorf("JSONL record %d has no %q field", line, textColumn)
+		}
+		var rec record
+		if err := json.Unmarshal(raw, &rec.Text); err != nil {
+			return nil, fmt.Errorf("JSONL record %d: field %q is not a string", line, textColumn)
+		}
+		if idColumn != "" {
+			rec.ID = jsonID(obj, idColumn)
+		}
+		records = append(records, rec)
+	}
+}
+
+func readCSV(r io.Reader, textColumn, idColumn string) ([]record, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSV header: %v", err)
+	}
+	textIdx, err := csvColumnIndex(header, textColumn)
+	if err != nil {
+		return nil, err
+	}
+	idIdx := -1
+	if idColumn != "" {
+		if idIdx, err = csvColumnIndex(header, idColumn); err != nil {
+			return nil, err
+		}
+	}
+	var records []record
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec := record{Text: row[textIdx]}
+		if idIdx >= 0 {
+			rec.ID = row[idIdx]
+		}
+		records = append(records, rec)
+	}
+}
+
+// csvColumnIndex finds column by name, or by zero-based index if it is a number
+func csvColumnIndex(header []string, column string) (int, error) {
+	for i, name := range header {
+		if name == column {
+			return i, nil
+		}
+	}
+	if idx, err := strconv.Atoi(column); err == nil && idx >= 0 && idx < len(header) {
+		return idx, nil
+	}
+	return -1, fmt.Errorf("CSV column %q not found", column)
+}
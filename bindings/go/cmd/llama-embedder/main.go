@@ -0,0 +1,251 @@
+// Command llama-embedder embeds texts with a GGUF model using the llama-embedder Go bindings.
+//
+// Texts are read from the command line arguments, from the files given with -input (plain text with one text per
+// line, JSONL or CSV), or from stdin. Embeddings are written as JSONL, CSV or a NumPy .npy array.
+//
+//	llama-embedder -model all-MiniLM-L6-v2.Q4_0.gguf -hf-repo leliuga/all-MiniLM-L6-v2-GGUF "Hello world"
+//	llama-embedder -model ./model.gguf -input corpus.jsonl -column body -id-column id -output embeddings.npy
+//
+// The cache subcommand lists and prunes the downloaded models and shared libraries:
+//
+//	llama-embedder cache list
+//	llama-embedder cache prune -older-than 720h -max-size 10GB
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	llama "github.com/amikos-tech/llamacpp-embedder/bindings/go"
+)
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+type config struct {
+	model         string
+	hfRepo        string
+	cacheDir      string
+	libPath       string
+	libVersion    string
+	skipChecksum  bool
+	pooling       string
+	normalization string
+	inputs        stringList
+	inputFormat   string
+	column        string
+	idColumn      string
+	output        string
+	outputFormat  string
+	batchSize     int
+}
+
+func parsePooling(name string) (llama.PoolingType, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return llama.PoolingNone, nil
+	case "mean":
+		return llama.PoolingMean, nil
+	case "cls":
+		return llama.PoolingCls, nil
+	case "last":
+		return llama.PoolingLast, nil
+	default:
+		return 0, fmt.Errorf("unknown pooling type: %s", name)
+	}
+}
+
+func parseNormalization(name string) (llama.NormalizationType, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return llama.NormalizationNone, nil
+	case "maxabs", "maxabs-int16":
+		return llama.NormalizationMaxAbsInt16, nil
+	case "taxicab", "l1":
+		return llama.NormalizationTaxicab, nil
+	case "l2":
+		return llama.NormalizationL2, nil
+	default:
+		return 0, fmt.Errorf("unknown normalization type: %s", name)
+	}
+}
+
+func parseFlags(args []string) (*config, []string, error) {
+	cfg := &config{}
+	fs := flag.NewFlagSet("llama-embedder", flag.ContinueOnError)
+	fs.StringVar(&cfg.model, "model", "", "path to a GGUF model, or the model file in the repo given with -hf-repo")
+	fs.StringVar(&cfg.hfRepo, "hf-repo", "", "Hugging Face repo to download the model from")
+	fs.StringVar(&cfg.cacheDir, "cache-dir", "", "cache root for downloaded models and shared libraries")
+	fs.StringVar(&cfg.libPath, "lib-path", "", "directory containing the llama-embedder shared library")
+	fs.StringVar(&cfg.libVersion, "lib-version", "", "version of the llama-embedder shared library to download")
+	fs.BoolVar(&cfg.skipChecksum, "insecure-skip-checksum", false, "use a downloaded shared library whose release publishes no checksums, such as v0.0.8 and earlier")
+	fs.StringVar(&cfg.pooling, "pooling", "mean", "pooling type: mean, cls, last or none")
+	fs.StringVar(&cfg.normalization, "normalization", "l2", "normalization type: l2, taxicab, maxabs or none")
+	fs.Var(&cfg.inputs, "input", "input file, - for stdin (repeatable)")
+	fs.StringVar(&cfg.inputFormat, "input-format", "", "input format: text, jsonl or csv (default: from the file extension)")
+	fs.StringVar(&cfg.column, "column", "text", "JSONL field or CSV column holding the text")
+	fs.StringVar(&cfg.idColumn, "id-column", "", "JSONL field or CSV column holding the record id")
+	fs.StringVar(&cfg.output, "output", "-", "output file, - for stdout")
+	fs.StringVar(&cfg.outputFormat, "output-format", "", "output format: jsonl, csv or npy (default: from the file extension)")
+	fs.IntVar(&cfg.batchSize, "batch-size", 32, "number of texts embedded per call")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if cfg.model == "" {
+		return nil, nil, fmt.Errorf("-model is required")
+	}
+	if cfg.batchSize <= 0 {
+		return nil, nil, fmt.Errorf("-batch-size must be positive")
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (cfg *config) embedderOptions() ([]llama.Option, error) {
+	pooling, err := parsePooling(cfg.pooling)
+	if err != nil {
+		return nil, err
+	}
+	normalization, err := parseNormalization(cfg.normalization)
+	if err != nil {
+		return nil, err
+	}
+	opts := []llama.Option{llama.WithPooling(pooling), llama.WithNormalization(normalization)}
+	if cfg.hfRepo != "" {
+		opts = append(opts, llama.WithHFRepo(cfg.hfRepo))
+	}
+	if cfg.cacheDir != "" {
+		opts = append(opts, llama.WithCacheDir(cfg.cacheDir))
+	}
+	if cfg.libVersion != "" {
+		opts = append(opts, llama.WithSharedLibraryVersion(cfg.libVersion))
+	}
+	if cfg.libPath != "" {
+		opts = append(opts, llama.WithSharedLibraryPath(cfg.libPath))
+	}
+	if cfg.skipChecksum {
+		opts = append(opts, llama.WithInsecureSkipChecksum())
+	}
+	return opts, nil
+}
+
+// collectRecords gathers the texts to embed from the arguments and the input files, falling back to stdin
+func (cfg *config) collectRecords(args []string, stdin io.Reader) ([]record, error) {
+	var records []record
+	for _, arg := range args {
+		records = append(records, record{Text: arg})
+	}
+	inputs := cfg.inputs
+	if len(inputs) == 0 && len(args) == 0 {
+		inputs = stringList{"-"}
+	}
+	for _, input := range inputs {
+		format := inputFormat(cfg.inputFormat)
+		if format == "" {
+			format = detectInputFormat(input)
+		}
+		var r io.Reader = stdin
+		if input != "-" {
+			f, err := os.Open(input)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		read, err := readRecords(r, format, cfg.column, cfg.idColumn)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", input, err)
+		}
+		records = append(records, read...)
+	}
+	return records, nil
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) > 0 && args[0] == "cache" {
+		return runCache(args[1:], stdout)
+	}
+	cfg, rest, err := parseFlags(args)
+	if err != nil {
+		return err
+	}
+	opts, err := cfg.embedderOptions()
+	if err != nil {
+		return err
+	}
+	records, err := cfg.collectRecords(rest, stdin)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no texts to embed")
+	}
+
+	format := outputFormat(cfg.outputFormat)
+	if format == "" {
+		format = detectOutputFormat(cfg.output)
+	}
+	if format != outputJSONL && format != outputCSV && format != outputNPY {
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+
+	// the model is loaded first so that a model that fails to load leaves no empty output file behind
+	e, closeFunc, err := llama.NewLlamaEmbedder(cfg.model, opts...)
+	if err != nil {
+		return err
+	}
+	defer closeFunc()
+
+	var out io.Writer = stdout
+	if cfg.output != "-" {
+		f, err := os.Create(cfg.output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	writer, err := newEmbeddingWriter(out, format)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(records); start += cfg.batchSize {
+		end := min(start+cfg.batchSize, len(records))
+		batch := records[start:end]
+		texts := make([]string, len(batch))
+		for i, rec := range batch {
+			texts[i] = rec.Text
+		}
+		embeddings, err := e.EmbedTexts(texts)
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(batch, embeddings); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "llama-embedder: %v\n", err)
+		os.Exit(1)
+	}
+}
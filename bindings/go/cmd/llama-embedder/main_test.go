@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	llama "github.com/amikos-tech/llamacpp-embedder/bindings/go"
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/cachedir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRecords(t *testing.T) {
+	t.Run("Text", func(t *testing.T) {
+		records, err := readRecords(strings.NewReader("hello\n\nworld\r\n"), inputText, "text", "")
+		require.NoError(t, err)
+		require.Equal(t, []record{{Text: "hello"}, {Text: "world"}}, records)
+	})
+	t.Run("JSONL", func(t *testing.T) {
+		input := "{\"id\": 1, \"body\": \"hello\"}\n{\"id\": \"b\", \"body\": \"world\"}\n"
+		records, err := readRecords(strings.NewReader(input), inputJSONL, "body", "id")
+		require.NoError(t, err)
+		require.Equal(t, []record{{ID: "1", Text: "hello"}, {ID: "b", Text: "world"}}, records)
+
+		_, err = readRecords(strings.NewReader(input), inputJSONL, "text", "")
+		require.Error(t, err)
+	})
+	t.Run("CSV", func(t *testing.T) {
+		input := "id,body\n1,\"hello, world\"\n2,bye\n"
+		records, err := readRecords(strings.NewReader(input), inputCSV, "body", "id")
+		require.NoError(t, err)
+		require.Equal(t, []record{{ID: "1", Text: "hello, world"}, {ID: "2", Text: "bye"}}, records)
+
+		records, err = readRecords(strings.NewReader(input), inputCSV, "1", "")
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+
+		_, err = readRecords(strings.NewReader(input), inputCSV, "missing", "")
+		require.Error(t, err)
+	})
+}
+
+func TestDetectFormats(t *testing.T) {
+	require.Equal(t, inputJSONL, detectInputFormat("corpus.JSONL"))
+	require.Equal(t, inputCSV, detectInputFormat("corpus.csv"))
+	require.Equal(t, inputText, detectInputFormat("-"))
+	require.Equal(t, outputNPY, detectOutputFormat("out.npy"))
+	require.Equal(t, outputCSV, detectOutputFormat("out.csv"))
+	require.Equal(t, outputJSONL, detectOutputFormat("-"))
+}
+
+func TestWriteJSONL(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newEmbeddingWriter(&buf, outputJSONL)
+	require.NoError(t, err)
+	require.NoError(t, w.Write([]record{{ID: "a", Text: "hello"}}, [][]float32{{0.5, 1}}))
+	require.NoError(t, w.Close())
+	var line jsonlLine
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	require.Equal(t, jsonlLine{ID: "a", Text: "hello", Embedding: []float32{0.5, 1}}, line)
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newEmbeddingWriter(&buf, outputCSV)
+	require.NoError(t, err)
+	require.NoError(t, w.Write([]record{{Text: "hello"}, {ID: "b", Text: "world"}}, [][]float32{{0.5, 1}, {-2, 0.25}}))
+	require.NoError(t, w.Close())
+	require.Equal(t, "hello,0.5,1\nb,-2,0.25\n", buf.String())
+}
+
+func TestWriteNPY(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newEmbeddingWriter(&buf, outputNPY)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(make([]record, 2), [][]float32{{1, 2, 3}, {4, 5, 6}}))
+	require.Error(t, w.Write(make([]record, 1), [][]float32{{1}}))
+	require.NoError(t, w.Close())
+
+	data := buf.Bytes()
+	require.Equal(t, "\x93NUMPY\x01\x00", string(data[:8]))
+	headerLen := int(binary.LittleEndian.Uint16(data[8:10]))
+	require.Zero(t, (10+headerLen)%64, "header must be padded to a multiple of 64 bytes")
+	header := string(data[10 : 10+headerLen])
+	require.Contains(t, header, "'descr': '<f4'")
+	require.Contains(t, header, "'shape': (2, 3)")
+	values := data[10+headerLen:]
+	require.Len(t, values, 6*4)
+	for i := 0; i < 6; i++ {
+		require.Equal(t, float32(i+1), math.Float32frombits(binary.LittleEndian.Uint32(values[i*4:])))
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	_, _, err := parseFlags([]string{"hello"})
+	require.Error(t, err, "model is required")
+	cfg, rest, err := parseFlags([]string{"-model", "m.gguf", "-pooling", "cls", "-input", "a.txt", "-input", "b.csv", "hello"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello"}, rest)
+	require.Equal(t, stringList{"a.txt", "b.csv"}, cfg.inputs)
+	_, err = cfg.embedderOptions()
+	require.NoError(t, err)
+	cfg.pooling = "max"
+	_, err = cfg.embedderOptions()
+	require.Error(t, err)
+}
+
+func TestRunLeavesNoOutputWhenTheModelFails(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "embeddings.jsonl")
+	err := run([]string{"-model", filepath.Join(dir, "missing.gguf"), "-cache-dir", dir, "-output", output, "hello"}, nil, io.Discard)
+	require.ErrorIs(t, err, llama.ErrModelNotFound)
+	require.NoFileExists(t, output)
+
+	err = run([]string{"-model", filepath.Join(dir, "missing.gguf"), "-output-format", "parquet", "hello"}, nil, io.Discard)
+	require.ErrorContains(t, err, "unsupported output format")
+}
+
+func TestRunCache(t *testing.T) {
+	root := t.TempDir()
+	m := cachedir.New(root)
+	require.NoError(t, m.Ensure())
+	require.NoError(t, os.WriteFile(filepath.Join(m.ModelDir(), "model.gguf"), make([]byte, 2048), 0644))
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"cache", "list", "-cache-dir", root}, nil, &out))
+	require.Contains(t, out.String(), "model.gguf")
+	require.Contains(t, out.String(), "2.0KB")
+
+	out.Reset()
+	require.NoError(t, run([]string{"cache", "list", "-cache-dir", root, "-json"}, nil, &out))
+	var entries []cachedir.Entry
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entries))
+	require.Len(t, entries, 1)
+
+	require.Error(t, run([]string{"cache", "prune", "-cache-dir", root}, nil, &out), "a limit is required")
+	out.Reset()
+	require.NoError(t, run([]string{"cache", "prune", "-cache-dir", root, "-max-size", "1KB"}, nil, &out))
+	require.Contains(t, out.String(), "removed 1 entries")
+	require.NoFileExists(t, filepath.Join(m.ModelDir(), "model.gguf"))
+
+	require.Error(t, run([]string{"cache", "clean"}, nil, &out))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type outputFormat string
+
+const (
+	outputJSONL outputFormat = "jsonl"
+	outputCSV   outputFormat = "csv"
+	outputNPY   outputFormat = "npy"
+)
+
+// detectOutputFormat infers the output format from the file extension, defaulting to JSONL
+func detectOutputFormat(path string) outputFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return outputCSV
+	case ".npy":
+		return outputNPY
+	default:
+		return outputJSONL
+	}
+}
+
+// embeddingWriter writes embeddings as they are produced
+type embeddingWriter interface {
+	Write(records []record, embeddings [][]float32) error
+	Close() error
+}
+
+func newEmbeddingWriter(w io.Writer, format outputFormat) (embeddingWriter, error) {
+	switch format {
+	case outputJSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case outputCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case outputNPY:
+		return &npyWriter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+type jsonlLine struct {
+	ID        string    `json:"id,omitempty"`
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(records []record, embeddings [][]float32) error {
+	for i, rec := range records {
+		if err := j.enc.Encode(jsonlLine{ID: rec.ID, Text: rec.Text, Embedding: embeddings[i]}); err != nil {
+			return err
+		}
+	}
+	return j.w.Flush()
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.w.Flush()
+}
+
+// csvWriter writes one row per embedding: the id (or the text if there is no id) followed by the values
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(records []record, embeddings [][]float32) error {
+	for i, rec := range records {
+		key := rec.ID
+		if key == "" {
+			key = rec.Text
+		}
+		row := make([]string, 0, len(embeddings[i])+1)
+		row = append(row, key)
+		for _, v := range embeddings[i] {
+			row = append(row, strconv.FormatFloat(float64(v), 'g', -1, 32))
+		}
+		if err := c.w.Write(row); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// npyWriter buffers the embeddings and writes a float32 (rows, dims) NumPy array on Close, since the header
+// records the shape
+type npyWriter struct {
+	w    io.Writer
+	data []float32
+	rows int
+	dims int
+}
+
+func (n *npyWriter) Write(_ []record, embeddings [][]float32) error {
+	for _, embedding := range embeddings {
+		if n.rows == 0 {
+			n.dims = len(embedding)
+		} else if len(embedding) != n.dims {
+			return fmt.Errorf("inconsistent embedding dimensions: %d and %d", n.dims, len(embedding))
+		}
+		n.data = append(n.data, embedding...)
+		n.rows++
+	}
+	return nil
+}
+
+func (n *npyWriter) Close() error {
+	return writeNPY(n.w, n.data, n.rows, n.dims)
+}
+
+// writeNPY writes data as a little-endian float32 C-ordered array in NPY format version 1.0
+func writeNPY(w io.Writer, data []float32, rows, cols int) error {
+	header := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", rows, cols)
+	// magic (6) + version (2) + header length (2) + header + newline must be a multiple of 64
+	total := 10 + len(header) + 1
+	if pad := (64 - total%64) % 64; pad > 0 {
+		header += strings.Repeat(" ", pad)
+	}
+	header += "\n"
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString("\x93NUMPY\x01\x00"); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, uint16(len(header))); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString(header); err != nil {
+		return err
+	}
+	var buf [4]byte
+	for _, v := range data {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(v))
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -1,199 +1,187 @@
 package llama_embedder
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
-	"strings"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/cachedir"
 )
 
-// ensureLibrary ensures that the shared library is downloaded and extracted. If it already exists, it will not be downloaded again.
-// It returns the path to the shared library file.
-func ensureLibrary(libraryVersion string) (string, error) {
+// ensureLibrary ensures that the shared library is downloaded, verified and extracted. If it already exists, it will not be downloaded again.
+// libraryVersion is latest, a full or a partial version resolved against the releases endpoint; exact versions are
+// used from the cache or downloaded from their release tag without a lookup. Archives without published checksums
+// are refused unless insecureSkipChecksum is set. It returns the path to the shared library file.
+func ensureLibrary(libCacheDir string, libraryVersion string, releasesEndpoint string, insecureSkipChecksum bool) (string, error) {
 	//llama-embedder-macos-arm64-v0.0.7.tar.gz
-	var cos string
-	var carch string
-	var libArchiveExt string
-	if runtime.GOOS == "darwin" {
-		cos = "macos"
-		libArchiveExt = "tar.gz"
-		if runtime.GOARCH == "arm64" {
-			carch = "arm64"
-		} else {
-			carch = "x64"
-		}
-	} else if runtime.GOOS == "linux" {
-		cos = "linux"
-		libArchiveExt = "tar.gz"
-		if runtime.GOARCH == "arm64" {
-			carch = "arm64"
-		} else {
-			carch = "x64"
-		}
-	} else if runtime.GOOS == "windows" {
-		cos = "win-noavx"
-		carch = "x64"
-		libArchiveExt = "zip"
-	} else {
-		fmt.Println("Unsupported OS")
-		os.Exit(1)
-	}
-	// https://github.com/amikos-tech/llamacpp-embedder/releases/download/go%2F<VERSION>/llama-embedder-<COS>-<CARCH>-<VERSION>.<EXT>
-	var libArchiveBase = "llama-embedder-" + cos + "-" + carch + "-" + libraryVersion
-	var sharedLibFilePath = filepath.Join(defaultLibCacheDir, libArchiveBase, getOSSharedLibName())
-	if _, err := os.Stat(filepath.Join(defaultLibCacheDir, libArchiveBase)); err == nil {
-		return sharedLibFilePath, nil
-	}
-	url := "https://github.com/amikos-tech/llamacpp-embedder/releases/download/go%2F" + libraryVersion + "/" + libArchiveBase + "." + libArchiveExt
-	fmt.Printf("Downloading library from %s\n", url)
-	segments := strings.Split(url, "/")
-	filename := segments[len(segments)-1]
-	if filename == "" {
-		return "", fmt.Errorf("failed to extract filename from URL")
-	}
-
-	// Create the output file
-	libArchive := filepath.Join(defaultLibCacheDir, filename)
-	err := downloadFile(libArchive, url)
+	cos, carch, libArchiveExt, err := libraryPlatform()
 	if err != nil {
 		return "", err
 	}
-
-	if runtime.GOOS == "windows" {
-		// Unzip the file
-		err = extractZip(libArchive, filepath.Join(defaultLibCacheDir, libArchiveBase))
+	archiveBase := func(version string) string {
+		return "llama-embedder-" + cos + "-" + carch + "-" + version
+	}
+	endpoint := releasesURL(releasesEndpoint)
+	// r is only listed for latest and partial versions, the assets of exact versions are found from their tag
+	var r *release
+	var version semver
+	if isExactVersion(libraryVersion) {
+		version, _ = parseVersion(libraryVersion)
+	} else {
+		if err := validateVersion(libraryVersion); err != nil {
+			return "", err
+		}
+		releases, err := fetchReleases(endpoint)
 		if err != nil {
 			return "", err
 		}
-	} else {
-		// Untar the file
-		err = extractTarGz(libArchive, filepath.Join(defaultLibCacheDir, libArchiveBase))
+		resolved, v, err := resolveRelease(releases, libraryVersion)
 		if err != nil {
 			return "", err
 		}
+		r, version = &resolved, v
 	}
-
-	return sharedLibFilePath, nil
-
-}
-
-// downloadFile downloads a file from a URL and saves it to the specified filepath.
-func downloadFile(filepath string, url string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to make HTTP request: %w", err)
+	tag := releaseTagPrefix + version.String()
+	var libArchiveBase = archiveBase(version.String())
+	if path, ok := cachedLibrary(libCacheDir, libArchiveBase); ok {
+		return path, nil
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+	// one process downloads and extracts the version, the others wait and use the result
+	if err := os.MkdirAll(libCacheDir, 0755); err != nil {
+		return "", fmt.Errorf("could not create library cache directory: %v", err)
 	}
-
-	out, err := os.Create(filepath)
+	lock, err := cachedir.Lock(filepath.Join(libCacheDir, libArchiveBase))
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return "", err
 	}
-	defer func(out *os.File) {
-		err := out.Close()
-		if err != nil {
-			fmt.Println("failed to close file:", err)
+	defer lock.Unlock()
+	if path, ok := cachedLibrary(libCacheDir, libArchiveBase); ok {
+		return path, nil
+	}
+	filename := libArchiveBase + "." + libArchiveExt
+	var url string
+	var checksumURLs []string
+	if r == nil {
+		base := releaseDownloadURL(endpoint) + "/" + tag + "/"
+		url = base + filename
+		checksumURLs = []string{base + checksumsAsset, base + filename + ".sha256"}
+	} else {
+		var ok bool
+		if url, ok = r.asset(filename); !ok {
+			return "", fmt.Errorf("release %s has no shared library for %s-%s", tag, cos, carch)
 		}
-	}(out)
-
-	_, err = io.Copy(out, resp.Body)
+		checksumURLs = r.checksumURLs(filename)
+	}
+	expected, err := publishedChecksum(checksumURLs, filename)
 	if err != nil {
-		return fmt.Errorf("failed to copy file contents: %w", err)
+		return "", err
 	}
+	if expected == "" && !insecureSkipChecksum {
+		return "", fmt.Errorf("release %s publishes no checksum for %s, use WithInsecureSkipChecksum to use it unverified", tag, filename)
+	}
+	// progress goes to stderr, stdout may carry the output of the caller such as the embeddings of llama-embedder
+	fmt.Fprintf(os.Stderr, "Downloading library from %s\n", url)
 
-	return nil
-}
-
-// downloadHFModel downloads a model from Hugging Face and saves it to the specified target location.
-func downloadHFModel(hfRepo, hfFile, targetLocation, hfToken string) error {
-	if hfFile == "" || hfRepo == "" {
-		return fmt.Errorf("hfRepo and hfFile are required")
+	// Create the output file
+	libArchive := filepath.Join(libCacheDir, filename)
+	actual, err := downloadFileSHA256(libArchive, url)
+	if err != nil {
+		_ = os.Remove(libArchive)
+		return "", fmt.Errorf("failed to download %s: %w", url, err)
 	}
-	if !strings.HasSuffix(strings.ToLower(hfFile), ".gguf") {
-		return fmt.Errorf("model file must be a .gguf file")
+	if expected == "" {
+		fmt.Fprintf(os.Stderr, "Warning: release %s publishes no checksums, %s is not verified\n", tag, filename)
+	} else if actual != expected {
+		_ = os.Remove(libArchive)
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", filename, expected, actual)
 	}
 
-	if _, err := os.Stat(targetLocation); err == nil {
-		return nil
+	// extract next to the target and rename, so an interrupted extraction is not mistaken for a cached library
+	target := filepath.Join(libCacheDir, libArchiveBase)
+	tmp := target + ".tmp"
+	_ = os.RemoveAll(tmp)
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	if libArchiveExt == "zip" {
+		// Unzip the file
+		err = extractZip(libArchive, tmp)
+	} else {
+		// Untar the file
+		err = extractTarGz(libArchive, tmp)
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		_ = os.RemoveAll(tmp)
+		return "", fmt.Errorf("failed to move library into the cache: %w", err)
 	}
-	client := &http.Client{}
 
-	url := fmt.Sprintf("https://huggingface.co/%s/resolve/main/%s", hfRepo, hfFile)
+	return filepath.Join(target, getOSSharedLibName()), nil
+}
 
-	// Create HTTP GET request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+// libraryPlatform returns the OS and architecture names used in the release archives and the archive extension
+func libraryPlatform() (cos, carch, ext string, err error) {
+	carch = "x64"
+	if runtime.GOARCH == "arm64" {
+		carch = "arm64"
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return "macos", carch, "tar.gz", nil
+	case "linux":
+		return "linux", carch, "tar.gz", nil
+	case "windows":
+		return "win-noavx", "x64", "zip", nil
+	default:
+		return "", "", "", fmt.Errorf("unsupported OS: %s", runtime.GOOS)
 	}
+}
 
-	// Set Authorization header if HF_TOKEN is provided
-	if hfToken != "" {
-		req.Header.Set("Authorization", "Bearer "+hfToken)
+// cachedLibrary returns the path of the shared library in the cached archive directory, if it exists
+func cachedLibrary(libCacheDir string, libArchiveBase string) (string, bool) {
+	dir := filepath.Join(libCacheDir, libArchiveBase)
+	if _, err := os.Stat(dir); err != nil {
+		return "", false
 	}
+	return filepath.Join(dir, getOSSharedLibName()), true
+}
 
-	// Execute the request
-	resp, err := client.Do(req)
+// downloadFileSHA256 downloads a file from a URL, saves it to the specified filepath and returns its hex encoded SHA256.
+func downloadFileSHA256(filepath string, url string) (string, error) {
+	resp, err := http.Get(url)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to make HTTP request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Check for HTTP errors
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP error: %s", resp.Status)
+		return "", fmt.Errorf("bad status: %s", resp.Status)
 	}
 
-	// Extract filename from URL
-	segments := strings.Split(url, "/")
-	filename := segments[len(segments)-1]
-	if filename == "" {
-		return fmt.Errorf("failed to extract filename from URL")
+	out, err := os.Create(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
 	}
-
-	var outputPath string
-
-	// Determine the output path based on targetLocation
-	if targetLocation != "" {
-		// Check if targetLocation is a directory
-		info, err := os.Stat(targetLocation)
-		if err == nil && info.IsDir() {
-			// targetLocation is an existing directory
-			outputPath = filepath.Join(targetLocation, filename)
-		} else if os.IsNotExist(err) && strings.HasSuffix(targetLocation, string(os.PathSeparator)) {
-			// targetLocation is a non-existing directory (ends with / or \)
-			if err := os.MkdirAll(targetLocation, os.ModePerm); err != nil {
-				return fmt.Errorf("failed to create directory: %v", err)
-			}
-			outputPath = filepath.Join(targetLocation, filename)
-		} else {
-			// targetLocation is a file path
-			outputDir := filepath.Dir(targetLocation)
-			// Create the directory if it doesn't exist
-			if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-				return fmt.Errorf("failed to create directory: %v", err)
-			}
-			outputPath = targetLocation
+	defer func(out *os.File) {
+		err := out.Close()
+		if err != nil {
+			fmt.Println("failed to close file:", err)
 		}
-	} else {
-		// No targetLocation provided, use current directory
-		outputPath = filename
-	}
+	}(out)
 
-	// Create the output file
-	outFile, err := os.Create(outputPath)
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), resp.Body)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to copy file contents: %w", err)
 	}
-	defer outFile.Close()
 
-	// Write response body to file
-	_, err = io.Copy(outFile, resp.Body)
-	return err
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
@@ -1,7 +1,9 @@
 package llama_embedder
 
 import (
+	"context"
 	"fmt"
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/modelsource"
 	"github.com/stretchr/testify/require"
 	"os"
 	"path/filepath"
@@ -11,7 +13,8 @@ import (
 func TestDownloadVersion(t *testing.T) {
 	err := ensureCacheDir()
 	require.NoError(t, err, "Error creating cache directory: %v", err)
-	libFilePath, err := ensureLibrary("v0.0.8")
+	skipUnpublishedRelease(t, LatestSharedLibVersion)
+	libFilePath, err := ensureLibrary(defaultLibCacheDir, LatestSharedLibVersion, "", false)
 	parent := filepath.Dir(libFilePath)
 	require.NoError(t, err, "Error downloading version: %v", err)
 	require.FileExists(t, libFilePath, "Library file should exist")
@@ -24,9 +27,16 @@ func TestDownloadVersion(t *testing.T) {
 	})
 }
 
-func TestDownloadModel(t *testing.T) {
-	err := downloadHFModel(defaultHFRepo, defaultModelFile, defaultModelFile, "")
+// downloadTestModel downloads the default model from Hugging Face into the working directory unless it is there
+func downloadTestModel(t *testing.T) {
+	src, err := modelsource.HuggingFace(defaultHFRepo, defaultModelFile, "")
+	require.NoError(t, err)
+	_, err = src.Fetch(context.Background(), ".")
 	require.NoError(t, err, "Failed to download model")
-	_, err = os.Stat(defaultModelFile)
+}
+
+func TestDownloadModel(t *testing.T) {
+	downloadTestModel(t)
+	_, err := os.Stat(defaultModelFile)
 	require.NoError(t, err, "Failed to download model")
 }
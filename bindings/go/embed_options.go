@@ -0,0 +1,62 @@
+package llama_embedder
+
+import "fmt"
+
+// EmbedOption configures a single EmbedTextsWithOptions call
+type EmbedOption func(*embedOptions) error
+
+type embedOptions struct {
+	normalization NormalizationType
+	prefix        string
+	truncate      bool
+	dimensions    int
+}
+
+// WithEmbedNormalization overrides the normalization type of the embedder for a single call
+func WithEmbedNormalization(norm NormalizationType) EmbedOption {
+	return func(o *embedOptions) error {
+		o.normalization = norm
+		return nil
+	}
+}
+
+// WithEmbedPrefix prepends prefix to every text, e.g. "search_query: " or "search_document: " for nomic-embed
+// or "query: " and "passage: " for e5 models.
+func WithEmbedPrefix(prefix string) EmbedOption {
+	return func(o *embedOptions) error {
+		o.prefix = prefix
+		return nil
+	}
+}
+
+// WithEmbedTruncation truncates texts longer than the batch size instead of failing.
+// The first tokens and the final separator token of a truncated text are kept.
+func WithEmbedTruncation(enabled bool) EmbedOption {
+	return func(o *embedOptions) error {
+		o.truncate = enabled
+		return nil
+	}
+}
+
+// WithEmbedDimensions overrides the output dimensions of the embedder for a single call, see WithDimensions
+func WithEmbedDimensions(dimensions int) EmbedOption {
+	return func(o *embedOptions) error {
+		if dimensions <= 0 {
+			return fmt.Errorf("dimensions must be positive")
+		}
+		o.dimensions = dimensions
+		return nil
+	}
+}
+
+// applyPrefix returns texts with prefix prepended to each of them
+func applyPrefix(texts []string, prefix string) []string {
+	if prefix == "" {
+		return texts
+	}
+	prefixed := make([]string, len(texts))
+	for i, t := range texts {
+		prefixed[i] = prefix + t
+	}
+	return prefixed
+}
@@ -0,0 +1,31 @@
+package llama_embedder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNativeError(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		require.NoError(t, nativeError(statusOK, ""))
+	})
+	t.Run("Sentinel", func(t *testing.T) {
+		err := nativeError(statusInputTooLong, "number of tokens in input line (600) exceeds batch size (512)")
+		require.ErrorIs(t, err, ErrInputTooLong)
+		require.Contains(t, err.Error(), "exceeds batch size (512)")
+	})
+	t.Run("Unknown", func(t *testing.T) {
+		err := nativeError(statusUnknown, "boom")
+		require.EqualError(t, err, "boom")
+		for _, sentinel := range statusErrors {
+			require.False(t, errors.Is(err, sentinel))
+		}
+	})
+}
+
+func TestModelNotFound(t *testing.T) {
+	_, _, err := NewLlamaEmbedder("does-not-exist.gguf")
+	require.ErrorIs(t, err, ErrModelNotFound)
+}
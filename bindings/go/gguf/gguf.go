@@ -0,0 +1,202 @@
+// Package gguf reads the metadata of GGUF model files without loading the model, e.g. to validate requests against
+// the embedding length of a model before a worker loads it
+package gguf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+var ErrNotGGUF = errors.New("not a GGUF file")
+
+// value types of the metadata key-value pairs
+const (
+	typeUint8 uint32 = iota
+	typeInt8
+	typeUint16
+	typeInt16
+	typeUint32
+	typeInt32
+	typeFloat32
+	typeBool
+	typeString
+	typeArray
+	typeUint64
+	typeInt64
+	typeFloat64
+)
+
+// maxStringLength bounds the strings read from a corrupt header
+const maxStringLength = 1 << 24
+
+// ReadMetadata returns the scalar metadata of the model file at path as text, keyed like llama.cpp reports it.
+// Arrays, such as the tokenizer vocabulary, are skipped.
+func ReadMetadata(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readMetadata(bufio.NewReaderSize(f, 1<<16))
+}
+
+// EmbeddingLength returns the embedding length of the model file at path, read from <architecture>.embedding_length
+func EmbeddingLength(path string) (int, error) {
+	metadata, err := ReadMetadata(path)
+	if err != nil {
+		return 0, err
+	}
+	key := metadata["general.architecture"] + ".embedding_length"
+	n, err := strconv.Atoi(metadata[key])
+	if err != nil {
+		return 0, fmt.Errorf("%s has no %s", path, key)
+	}
+	return n, nil
+}
+
+type reader struct {
+	r       *bufio.Reader
+	version uint32
+	err     error
+}
+
+func readMetadata(br *bufio.Reader) (map[string]string, error) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != "GGUF" {
+		return nil, ErrNotGGUF
+	}
+	r := &reader{r: br}
+	r.version = r.uint32()
+	if r.err == nil && (r.version < 1 || r.version > 3) {
+		return nil, fmt.Errorf("unsupported GGUF version %d", r.version)
+	}
+	r.count() // tensors
+	kvs := r.count()
+	metadata := make(map[string]string)
+	for i := uint64(0); i < kvs && r.err == nil; i++ {
+		key := r.string()
+		valueType := r.uint32()
+		if value, ok := r.value(valueType); ok {
+			metadata[key] = value
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid GGUF header: %w", r.err)
+	}
+	return metadata, nil
+}
+
+func (r *reader) read(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	b := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, b)
+	return b
+}
+
+func (r *reader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.read(4))
+}
+
+func (r *reader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.read(8))
+}
+
+// count reads a length, 32 bits wide in version 1 and 64 bits wide since
+func (r *reader) count() uint64 {
+	if r.version == 1 {
+		return uint64(r.uint32())
+	}
+	return r.uint64()
+}
+
+func (r *reader) string() string {
+	n := r.count()
+	if n > maxStringLength {
+		r.fail(fmt.Errorf("string of %d bytes", n))
+		return ""
+	}
+	return string(r.read(int(n)))
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+var scalarSizes = map[uint32]int{
+	typeUint8: 1, typeInt8: 1, typeBool: 1,
+	typeUint16: 2, typeInt16: 2,
+	typeUint32: 4, typeInt32: 4, typeFloat32: 4,
+	typeUint64: 8, typeInt64: 8, typeFloat64: 8,
+}
+
+// value reads a value of the given type and formats it, arrays are skipped and not reported
+func (r *reader) value(valueType uint32) (string, bool) {
+	switch valueType {
+	case typeString:
+		return r.string(), true
+	case typeArray:
+		elemType := r.uint32()
+		n := r.count()
+		if size, ok := scalarSizes[elemType]; ok {
+			r.skip(n * uint64(size))
+			return "", false
+		}
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			r.value(elemType)
+		}
+		return "", false
+	}
+	size, ok := scalarSizes[valueType]
+	if !ok {
+		r.fail(fmt.Errorf("unknown value type %d", valueType))
+		return "", false
+	}
+	b := r.read(size)
+	switch valueType {
+	case typeUint8:
+		return strconv.FormatUint(uint64(b[0]), 10), true
+	case typeInt8:
+		return strconv.FormatInt(int64(int8(b[0])), 10), true
+	case typeBool:
+		return strconv.FormatBool(b[0] != 0), true
+	case typeUint16:
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint16(b)), 10), true
+	case typeInt16:
+		return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(b))), 10), true
+	case typeUint32:
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b)), 10), true
+	case typeInt32:
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b))), 10), true
+	case typeFloat32:
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'g', -1, 32), true
+	case typeUint64:
+		return strconv.FormatUint(binary.LittleEndian.Uint64(b), 10), true
+	case typeInt64:
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10), true
+	default: // typeFloat64
+		return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64), true
+	}
+}
+
+func (r *reader) skip(n uint64) {
+	if r.err != nil {
+		return
+	}
+	if n > math.MaxInt64 {
+		r.fail(fmt.Errorf("array of %d bytes", n))
+		return
+	}
+	if _, err := r.r.Discard(int(n)); err != nil {
+		r.fail(err)
+	}
+}
@@ -0,0 +1,101 @@
+package gguf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// header builds a GGUF version 3 header with the given key-value pairs
+type header struct {
+	kvs bytes.Buffer
+	n   uint64
+}
+
+func (h *header) str(b *bytes.Buffer, s string) {
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(s)))
+	b.WriteString(s)
+}
+
+func (h *header) kv(key string, valueType uint32, value func(b *bytes.Buffer)) {
+	h.n++
+	h.str(&h.kvs, key)
+	_ = binary.Write(&h.kvs, binary.LittleEndian, valueType)
+	value(&h.kvs)
+}
+
+func (h *header) write(t *testing.T) string {
+	var b bytes.Buffer
+	b.WriteString("GGUF")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(3))
+	_ = binary.Write(&b, binary.LittleEndian, uint64(1)) // tensors
+	_ = binary.Write(&b, binary.LittleEndian, h.n)
+	b.Write(h.kvs.Bytes())
+	b.WriteString("tensor info and data follow")
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	require.NoError(t, os.WriteFile(path, b.Bytes(), 0644))
+	return path
+}
+
+func TestReadMetadata(t *testing.T) {
+	h := &header{}
+	h.kv("general.architecture", typeString, func(b *bytes.Buffer) { h.str(b, "bert") })
+	h.kv("tokenizer.ggml.tokens", typeArray, func(b *bytes.Buffer) {
+		_ = binary.Write(b, binary.LittleEndian, typeString)
+		_ = binary.Write(b, binary.LittleEndian, uint64(2))
+		h.str(b, "[CLS]")
+		h.str(b, "hello")
+	})
+	h.kv("tokenizer.ggml.scores", typeArray, func(b *bytes.Buffer) {
+		_ = binary.Write(b, binary.LittleEndian, typeFloat32)
+		_ = binary.Write(b, binary.LittleEndian, uint64(2))
+		_ = binary.Write(b, binary.LittleEndian, []float32{0.5, -1})
+	})
+	h.kv("bert.embedding_length", typeUint32, func(b *bytes.Buffer) {
+		_ = binary.Write(b, binary.LittleEndian, uint32(384))
+	})
+	h.kv("bert.attention.layer_norm_epsilon", typeFloat32, func(b *bytes.Buffer) {
+		_ = binary.Write(b, binary.LittleEndian, math.Float32bits(1e-12))
+	})
+	h.kv("bert.attention.causal", typeBool, func(b *bytes.Buffer) { b.WriteByte(0) })
+	path := h.write(t)
+
+	metadata, err := ReadMetadata(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"general.architecture":              "bert",
+		"bert.embedding_length":             "384",
+		"bert.attention.layer_norm_epsilon": "1e-12",
+		"bert.attention.causal":             "false",
+	}, metadata)
+
+	n, err := EmbeddingLength(path)
+	require.NoError(t, err)
+	require.Equal(t, 384, n)
+}
+
+func TestReadMetadataInvalid(t *testing.T) {
+	dir := t.TempDir()
+	notGGUF := filepath.Join(dir, "model.bin")
+	require.NoError(t, os.WriteFile(notGGUF, []byte("PK\x03\x04"), 0644))
+	_, err := ReadMetadata(notGGUF)
+	require.ErrorIs(t, err, ErrNotGGUF)
+
+	h := &header{}
+	h.kv("general.architecture", typeString, func(b *bytes.Buffer) { h.str(b, "bert") })
+	path := h.write(t)
+	_, err = EmbeddingLength(path)
+	require.ErrorContains(t, err, "bert.embedding_length")
+
+	truncated := filepath.Join(dir, "truncated.gguf")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(truncated, content[:30], 0644))
+	_, err = ReadMetadata(truncated)
+	require.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Flat is an exact index comparing the query with every vector
+type Flat struct {
+	dims    int
+	metric  Metric
+	ids     []string
+	vectors [][]float32
+	pos     map[string]int
+	mu      sync.RWMutex
+}
+
+// NewFlat creates an exact index of vectors with dims dimensions
+func NewFlat(dims int, opts ...Option) (*Flat, error) {
+	if dims <= 0 {
+		return nil, fmt.Errorf("dims must be positive")
+	}
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Flat{dims: dims, metric: c.metric, pos: make(map[string]int)}, nil
+}
+
+func (f *Flat) Add(id string, vector []float32) error {
+	v, err := prepare(f.metric, f.dims, vector)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i, ok := f.pos[id]; ok {
+		f.vectors[i] = v
+		return nil
+	}
+	f.pos[id] = len(f.ids)
+	f.ids = append(f.ids, id)
+	f.vectors = append(f.vectors, v)
+	return nil
+}
+
+func (f *Flat) Delete(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i, ok := f.pos[id]
+	if !ok {
+		return false
+	}
+	last := len(f.ids) - 1
+	f.ids[i], f.vectors[i] = f.ids[last], f.vectors[last]
+	f.pos[f.ids[i]] = i
+	f.ids, f.vectors = f.ids[:last], f.vectors[:last]
+	delete(f.pos, id)
+	return true
+}
+
+func (f *Flat) Search(query []float32, k int) ([]Result, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive")
+	}
+	q, err := prepare(f.metric, f.dims, query)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.RLock()
+	results := make([]Result, len(f.ids))
+	for i, v := range f.vectors {
+		results[i] = Result{ID: f.ids[i], Distance: distance(f.metric, q, v)}
+	}
+	f.mu.RUnlock()
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Distance < results[j].Distance
+	})
+	if k < len(results) {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+func (f *Flat) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.ids)
+}
+
+func (f *Flat) Save(path string) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return writeSnapshot(path, &snapshot{Kind: kindFlat, Dims: f.dims, Metric: f.metric, IDs: f.ids, Vectors: f.vectors})
+}
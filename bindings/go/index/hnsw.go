@@ -0,0 +1,253 @@
+package index
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+type hnswNode struct {
+	id        string
+	vector    []float32
+	neighbors [][]int // neighbors per layer, from layer 0 up to the level of the node
+	deleted   bool
+}
+
+// HNSW is an approximate index based on Hierarchical Navigable Small World graphs.
+// Deleted vectors are kept in the graph as tombstones for navigation and skipped in results; they are dropped when the
+// index is saved and loaded again.
+type HNSW struct {
+	dims           int
+	metric         Metric
+	m              int
+	efConstruction int
+	efSearch       int
+	seed           int64
+	levelMult      float64
+	rng            *rand.Rand
+	nodes          []*hnswNode
+	ids            map[string]int
+	entry          int
+	maxLevel       int
+	mu             sync.RWMutex
+}
+
+// NewHNSW creates an approximate index of vectors with dims dimensions
+func NewHNSW(dims int, opts ...Option) (*HNSW, error) {
+	if dims <= 0 {
+		return nil, fmt.Errorf("dims must be positive")
+	}
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &HNSW{
+		dims:           dims,
+		metric:         c.metric,
+		m:              c.m,
+		efConstruction: c.efConstruction,
+		efSearch:       c.efSearch,
+		seed:           c.seed,
+		levelMult:      1 / math.Log(float64(c.m)),
+		rng:            rand.New(rand.NewSource(c.seed)),
+		ids:            make(map[string]int),
+		entry:          -1,
+	}, nil
+}
+
+type candidate struct {
+	node     int
+	distance float32
+}
+
+// candidateHeap is a min-heap of candidates by distance, or a max-heap when max is set
+type candidateHeap struct {
+	items []candidate
+	max   bool
+}
+
+func (h *candidateHeap) Len() int { return len(h.items) }
+func (h *candidateHeap) Less(i, j int) bool {
+	if h.max {
+		return h.items[i].distance > h.items[j].distance
+	}
+	return h.items[i].distance < h.items[j].distance
+}
+func (h *candidateHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *candidateHeap) Push(x any)    { h.items = append(h.items, x.(candidate)) }
+func (h *candidateHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+func (h *HNSW) maxNeighbors(layer int) int {
+	if layer == 0 {
+		return 2 * h.m
+	}
+	return h.m
+}
+
+// searchLayer returns up to ef nodes of layer closest to q, starting from entry, sorted closest first
+func (h *HNSW) searchLayer(q []float32, entry int, ef int, layer int) []candidate {
+	visited := map[int]bool{entry: true}
+	d := distance(h.metric, q, h.nodes[entry].vector)
+	candidates := &candidateHeap{items: []candidate{{entry, d}}}
+	results := &candidateHeap{items: []candidate{{entry, d}}, max: true}
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(candidate)
+		if c.distance > results.items[0].distance && results.Len() >= ef {
+			break
+		}
+		for _, n := range h.nodes[c.node].neighbors[layer] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+			nd := distance(h.metric, q, h.nodes[n].vector)
+			if results.Len() < ef || nd < results.items[0].distance {
+				heap.Push(candidates, candidate{n, nd})
+				heap.Push(results, candidate{n, nd})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	sorted := results.items
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].distance < sorted[j].distance })
+	return sorted
+}
+
+func (h *HNSW) Add(id string, vector []float32) error {
+	v, err := prepare(h.metric, h.dims, vector)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if old, ok := h.ids[id]; ok {
+		h.nodes[old].deleted = true
+	}
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+	node := &hnswNode{id: id, vector: v, neighbors: make([][]int, level+1)}
+	n := len(h.nodes)
+	h.nodes = append(h.nodes, node)
+	h.ids[id] = n
+	if h.entry < 0 {
+		h.entry, h.maxLevel = n, level
+		return nil
+	}
+
+	entry := h.entry
+	for layer := h.maxLevel; layer > level; layer-- {
+		entry = h.searchLayer(v, entry, 1, layer)[0].node
+	}
+	for layer := min(level, h.maxLevel); layer >= 0; layer-- {
+		found := h.searchLayer(v, entry, h.efConstruction, layer)
+		neighbors := make([]int, 0, h.m)
+		for _, c := range found {
+			if len(neighbors) == h.m {
+				break
+			}
+			neighbors = append(neighbors, c.node)
+		}
+		node.neighbors[layer] = neighbors
+		for _, nb := range neighbors {
+			h.connect(nb, n, layer)
+		}
+		entry = found[0].node
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = n, level
+	}
+	return nil
+}
+
+// connect adds n to the neighbors of node in layer, keeping only the closest ones when the node has too many
+func (h *HNSW) connect(node, n, layer int) {
+	nb := append(h.nodes[node].neighbors[layer], n)
+	limit := h.maxNeighbors(layer)
+	if len(nb) > limit {
+		v := h.nodes[node].vector
+		sort.Slice(nb, func(i, j int) bool {
+			return distance(h.metric, v, h.nodes[nb[i]].vector) < distance(h.metric, v, h.nodes[nb[j]].vector)
+		})
+		nb = nb[:limit]
+	}
+	h.nodes[node].neighbors[layer] = nb
+}
+
+func (h *HNSW) Delete(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n, ok := h.ids[id]
+	if !ok {
+		return false
+	}
+	h.nodes[n].deleted = true
+	delete(h.ids, id)
+	return true
+}
+
+func (h *HNSW) Search(query []float32, k int) ([]Result, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive")
+	}
+	q, err := prepare(h.metric, h.dims, query)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.ids) == 0 {
+		return []Result{}, nil
+	}
+	entry := h.entry
+	for layer := h.maxLevel; layer > 0; layer-- {
+		entry = h.searchLayer(q, entry, 1, layer)[0].node
+	}
+	// tombstones take up room in the candidate list, widen it so that k live results can still be found
+	ef := max(h.efSearch, k) + len(h.nodes) - len(h.ids)
+	results := make([]Result, 0, k)
+	for _, c := range h.searchLayer(q, entry, ef, 0) {
+		if h.nodes[c.node].deleted {
+			continue
+		}
+		results = append(results, Result{ID: h.nodes[c.node].id, Distance: c.distance})
+		if len(results) == k {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (h *HNSW) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.ids)
+}
+
+func (h *HNSW) Save(path string) error {
+	h.mu.RLock()
+	s := &snapshot{
+		Kind:           kindHNSW,
+		Dims:           h.dims,
+		Metric:         h.metric,
+		M:              h.m,
+		EfConstruction: h.efConstruction,
+		EfSearch:       h.efSearch,
+		Seed:           h.seed,
+	}
+	for _, node := range h.nodes {
+		if !node.deleted {
+			s.IDs = append(s.IDs, node.id)
+			s.Vectors = append(s.Vectors, node.vector)
+		}
+	}
+	h.mu.RUnlock()
+	return writeSnapshot(path, s)
+}
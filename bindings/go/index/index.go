@@ -0,0 +1,273 @@
+// Package index provides in-memory vector indexes for embeddings created with the llama_embedder bindings: an exact
+// flat index and an approximate HNSW index. Vectors are keyed by string ids and indexes can be saved to and loaded from
+// a file.
+package index
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Metric is the distance used to compare vectors. Smaller distances mean more similar vectors.
+type Metric string
+
+const (
+	// Cosine is 1 - cosine similarity. Vectors are normalized when they are added, so any scale can be used.
+	Cosine Metric = "cosine"
+	// DotProduct is the negated dot product
+	DotProduct Metric = "dot"
+	// Euclidean is the L2 distance
+	Euclidean Metric = "euclidean"
+)
+
+// Result is a search hit
+type Result struct {
+	ID       string  `json:"id"`
+	Distance float32 `json:"distance"`
+}
+
+// Index is an in-memory vector index. Implementations are safe for concurrent use.
+type Index interface {
+	// Add inserts vector under id, replacing any vector already stored under id
+	Add(id string, vector []float32) error
+	// Delete removes the vector stored under id and reports whether it existed
+	Delete(id string) bool
+	// Search returns up to k vectors closest to query, closest first
+	Search(query []float32, k int) ([]Result, error)
+	// Len returns the number of vectors in the index
+	Len() int
+	// Save writes the index to path
+	Save(path string) error
+}
+
+// Embedder embeds texts; *llama_embedder.LlamaEmbedder implements it
+type Embedder interface {
+	EmbedTexts(texts []string) ([][]float32, error)
+}
+
+type config struct {
+	metric         Metric
+	m              int
+	efConstruction int
+	efSearch       int
+	seed           int64
+}
+
+type Option func(*config) error
+
+// WithMetric sets the distance metric, Cosine by default
+func WithMetric(metric Metric) Option {
+	return func(c *config) error {
+		switch metric {
+		case Cosine, DotProduct, Euclidean:
+			c.metric = metric
+			return nil
+		default:
+			return fmt.Errorf("unknown metric: %s", metric)
+		}
+	}
+}
+
+// WithM sets the number of neighbors of each HNSW node (16 by default). Larger values improve recall at the cost of
+// memory and insertion time.
+func WithM(m int) Option {
+	return func(c *config) error {
+		if m < 2 {
+			return fmt.Errorf("m must be at least 2")
+		}
+		c.m = m
+		return nil
+	}
+}
+
+// WithEfConstruction sets the size of the candidate list used while inserting into an HNSW index (200 by default)
+func WithEfConstruction(ef int) Option {
+	return func(c *config) error {
+		if ef < 1 {
+			return fmt.Errorf("ef construction must be positive")
+		}
+		c.efConstruction = ef
+		return nil
+	}
+}
+
+// WithEfSearch sets the size of the candidate list used while searching an HNSW index (50 by default, at least k)
+func WithEfSearch(ef int) Option {
+	return func(c *config) error {
+		if ef < 1 {
+			return fmt.Errorf("ef search must be positive")
+		}
+		c.efSearch = ef
+		return nil
+	}
+}
+
+// WithSeed sets the seed of the random level generator of an HNSW index, for reproducible graphs
+func WithSeed(seed int64) Option {
+	return func(c *config) error {
+		c.seed = seed
+		return nil
+	}
+}
+
+func newConfig(opts []Option) (*config, error) {
+	c := &config{metric: Cosine, m: 16, efConstruction: 200, efSearch: 50, seed: 1}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// prepare validates vector and returns the copy stored in or searched against an index
+func prepare(metric Metric, dims int, vector []float32) ([]float32, error) {
+	if len(vector) != dims {
+		return nil, fmt.Errorf("vector has %d dimensions, index has %d", len(vector), dims)
+	}
+	v := append([]float32(nil), vector...)
+	if metric == Cosine {
+		var sum float64
+		for _, x := range v {
+			sum += float64(x) * float64(x)
+		}
+		if sum > 0 {
+			scale := float32(1 / math.Sqrt(sum))
+			for i := range v {
+				v[i] *= scale
+			}
+		}
+	}
+	return v, nil
+}
+
+func distance(metric Metric, a, b []float32) float32 {
+	switch metric {
+	case Euclidean:
+		var sum float32
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return float32(math.Sqrt(float64(sum)))
+	case DotProduct:
+		return -dot(a, b)
+	default:
+		return 1 - dot(a, b)
+	}
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// AddTexts embeds texts with e and adds them to idx under ids
+func AddTexts(idx Index, e Embedder, ids []string, texts []string) error {
+	if len(ids) != len(texts) {
+		return fmt.Errorf("got %d ids for %d texts", len(ids), len(texts))
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	embeddings, err := e.EmbedTexts(texts)
+	if err != nil {
+		return err
+	}
+	if len(embeddings) != len(texts) {
+		return fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embeddings))
+	}
+	for i, embedding := range embeddings {
+		if err := idx.Add(ids[i], embedding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchText embeds query with e and searches idx for the k closest vectors
+func SearchText(idx Index, e Embedder, query string, k int) ([]Result, error) {
+	embeddings, err := e.EmbedTexts([]string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != 1 {
+		return nil, fmt.Errorf("expected 1 embedding, got %d", len(embeddings))
+	}
+	return idx.Search(embeddings[0], k)
+}
+
+const (
+	kindFlat = "flat"
+	kindHNSW = "hnsw"
+)
+
+// snapshot is the file format of a saved index. HNSW graphs are rebuilt from the vectors when loaded.
+type snapshot struct {
+	Kind           string
+	Dims           int
+	Metric         Metric
+	M              int
+	EfConstruction int
+	EfSearch       int
+	Seed           int64
+	IDs            []string
+	Vectors        [][]float32
+}
+
+func writeSnapshot(path string, s *snapshot) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(tmp).Encode(s); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to encode index: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads an index saved with Save
+func Load(path string) (Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var s snapshot
+	if err := gob.NewDecoder(f).Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to decode index %s: %v", path, err)
+	}
+	if len(s.IDs) != len(s.Vectors) {
+		return nil, fmt.Errorf("corrupt index %s: %d ids for %d vectors", path, len(s.IDs), len(s.Vectors))
+	}
+	var idx Index
+	switch s.Kind {
+	case kindFlat:
+		idx, err = NewFlat(s.Dims, WithMetric(s.Metric))
+	case kindHNSW:
+		idx, err = NewHNSW(s.Dims, WithMetric(s.Metric), WithM(s.M), WithEfConstruction(s.EfConstruction), WithEfSearch(s.EfSearch), WithSeed(s.Seed))
+	default:
+		return nil, fmt.Errorf("unknown index kind %q in %s", s.Kind, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range s.IDs {
+		if err := idx.Add(id, s.Vectors[i]); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
@@ -0,0 +1,158 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func randomVectors(n, dims int, seed int64) [][]float32 {
+	rng := rand.New(rand.NewSource(seed))
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dims)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()*2 - 1
+		}
+	}
+	return vectors
+}
+
+func newIndexes(t *testing.T, dims int, opts ...Option) map[string]Index {
+	flat, err := NewFlat(dims, opts...)
+	require.NoError(t, err)
+	hnsw, err := NewHNSW(dims, opts...)
+	require.NoError(t, err)
+	return map[string]Index{"flat": flat, "hnsw": hnsw}
+}
+
+func TestAddSearchDelete(t *testing.T) {
+	for name, idx := range newIndexes(t, 2) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, idx.Add("right", []float32{1, 0}))
+			require.NoError(t, idx.Add("up", []float32{0, 2}))
+			require.NoError(t, idx.Add("left", []float32{-1, 0}))
+			require.Error(t, idx.Add("bad", []float32{1, 0, 0}))
+			require.Equal(t, 3, idx.Len())
+
+			results, err := idx.Search([]float32{3, 0.1}, 2)
+			require.NoError(t, err)
+			require.Len(t, results, 2)
+			require.Equal(t, "right", results[0].ID)
+			require.Equal(t, "up", results[1].ID)
+			require.InDelta(t, 0, results[0].Distance, 1e-2)
+
+			require.True(t, idx.Delete("right"))
+			require.False(t, idx.Delete("right"))
+			require.Equal(t, 2, idx.Len())
+			results, err = idx.Search([]float32{3, 0.1}, 3)
+			require.NoError(t, err)
+			require.Len(t, results, 2)
+			require.Equal(t, "up", results[0].ID)
+
+			// adding an existing id replaces its vector
+			require.NoError(t, idx.Add("up", []float32{1, 0}))
+			require.Equal(t, 2, idx.Len())
+			results, err = idx.Search([]float32{1, 0}, 1)
+			require.NoError(t, err)
+			require.Equal(t, "up", results[0].ID)
+		})
+	}
+}
+
+func TestHNSWRecall(t *testing.T) {
+	const dims, n, queries, k = 16, 1000, 50, 10
+	vectors := randomVectors(n, dims, 1)
+	indexes := newIndexes(t, dims)
+	for i, v := range vectors {
+		for _, idx := range indexes {
+			require.NoError(t, idx.Add(fmt.Sprint(i), v))
+		}
+	}
+	var found int
+	for _, q := range randomVectors(queries, dims, 2) {
+		exact, err := indexes["flat"].Search(q, k)
+		require.NoError(t, err)
+		approx, err := indexes["hnsw"].Search(q, k)
+		require.NoError(t, err)
+		want := map[string]bool{}
+		for _, r := range exact {
+			want[r.ID] = true
+		}
+		for _, r := range approx {
+			if want[r.ID] {
+				found++
+			}
+		}
+	}
+	require.Greater(t, float64(found)/float64(queries*k), 0.9)
+}
+
+func TestMetrics(t *testing.T) {
+	idx, err := NewFlat(2, WithMetric(Euclidean))
+	require.NoError(t, err)
+	require.NoError(t, idx.Add("near", []float32{1, 1}))
+	require.NoError(t, idx.Add("far", []float32{10, 10}))
+	results, err := idx.Search([]float32{0, 0}, 1)
+	require.NoError(t, err)
+	require.Equal(t, "near", results[0].ID)
+
+	idx, err = NewFlat(2, WithMetric(DotProduct))
+	require.NoError(t, err)
+	require.NoError(t, idx.Add("near", []float32{1, 1}))
+	require.NoError(t, idx.Add("far", []float32{10, 10}))
+	results, err = idx.Search([]float32{1, 1}, 1)
+	require.NoError(t, err)
+	require.Equal(t, "far", results[0].ID)
+
+	_, err = NewFlat(2, WithMetric("manhattan"))
+	require.Error(t, err)
+}
+
+func TestSaveLoad(t *testing.T) {
+	vectors := randomVectors(100, 8, 3)
+	for name, idx := range newIndexes(t, 8, WithMetric(Euclidean)) {
+		t.Run(name, func(t *testing.T) {
+			for i, v := range vectors {
+				require.NoError(t, idx.Add(fmt.Sprint(i), v))
+			}
+			require.True(t, idx.Delete("0"))
+			path := filepath.Join(t.TempDir(), "index.bin")
+			require.NoError(t, idx.Save(path))
+
+			loaded, err := Load(path)
+			require.NoError(t, err)
+			require.IsType(t, idx, loaded)
+			require.Equal(t, 99, loaded.Len())
+			results, err := loaded.Search(vectors[1], 1)
+			require.NoError(t, err)
+			require.Equal(t, "1", results[0].ID)
+			require.InDelta(t, 0, results[0].Distance, 1e-6)
+		})
+	}
+}
+
+type fakeEmbedder struct{}
+
+// EmbedTexts embeds a text as the counts of the letters a and b
+func (fakeEmbedder) EmbedTexts(texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = []float32{float32(strings.Count(text, "a")), float32(strings.Count(text, "b"))}
+	}
+	return embeddings, nil
+}
+
+func TestAddTexts(t *testing.T) {
+	idx, err := NewFlat(2)
+	require.NoError(t, err)
+	require.NoError(t, AddTexts(idx, fakeEmbedder{}, []string{"1", "2"}, []string{"aaa", "bbb"}))
+	require.Error(t, AddTexts(idx, fakeEmbedder{}, []string{"3"}, []string{"a", "b"}))
+	results, err := SearchText(idx, fakeEmbedder{}, "ab bb", 1)
+	require.NoError(t, err)
+	require.Equal(t, "2", results[0].ID)
+}
@@ -0,0 +1,301 @@
+package llama_embedder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SharedLibVersionLatest selects the newest stable release of the shared library with WithSharedLibraryVersion
+const SharedLibVersionLatest = "latest"
+
+// abiVersion is the ABI version of the shared library this package is built against, see LLAMA_EMBEDDER_ABI_VERSION
+const abiVersion = 1
+
+// DefaultReleasesURL is the GitHub releases API endpoint the shared library versions are resolved against
+const DefaultReleasesURL = "https://api.github.com/repos/amikos-tech/llamacpp-embedder/releases"
+
+// DefaultReleaseDownloadURL is the base URL the assets of the releases are downloaded from, as <url>/<tag>/<asset>
+const DefaultReleaseDownloadURL = "https://github.com/amikos-tech/llamacpp-embedder/releases/download"
+
+// releasesURLEnv overrides DefaultReleasesURL, e.g. with a local stand-in for testing
+const releasesURLEnv = "LLAMA_EMBEDDER_RELEASES_URL"
+
+// releaseTagPrefix prefixes the tags of the Go binding releases, e.g. go/v0.0.8
+const releaseTagPrefix = "go/"
+
+// checksumsAsset is the release asset listing the SHA256 checksums of the other assets in sha256sum format
+const checksumsAsset = "checksums.txt"
+
+// versionPattern matches full (v1.2.3, v1.2.3-rc1) and partial (v1, v1.2) versions, the leading v is optional
+var versionPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.]+))?$`)
+
+type semver struct {
+	major, minor, patch int
+	pre                 string
+	// parts is the number of numeric parts given, partial versions match the newest release with the same prefix
+	parts int
+}
+
+// parseVersion parses a full or partial version
+func parseVersion(version string) (semver, error) {
+	m := versionPattern.FindStringSubmatch(version)
+	if m == nil {
+		return semver{}, fmt.Errorf("invalid version %q, expected latest or vX.Y.Z(-rcN/-alphaN/-betaN)", version)
+	}
+	v := semver{pre: m[4], parts: 1}
+	v.major, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		v.minor, _ = strconv.Atoi(m[2])
+		v.parts++
+	}
+	if m[3] != "" {
+		v.patch, _ = strconv.Atoi(m[3])
+		v.parts++
+	}
+	if v.pre != "" && v.parts < 3 {
+		return semver{}, fmt.Errorf("invalid version %q, pre-releases need a full version", version)
+	}
+	return v, nil
+}
+
+// validateVersion checks that version is latest or a full or partial semantic version
+func validateVersion(version string) error {
+	if version == SharedLibVersionLatest {
+		return nil
+	}
+	_, err := parseVersion(version)
+	return err
+}
+
+// isExactVersion reports whether version names a single release, which can be used from the cache without a lookup
+func isExactVersion(version string) bool {
+	v, err := parseVersion(version)
+	return err == nil && v.parts == 3
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("v%d.%d.%d", v.major, v.minor, v.patch)
+	if v.pre != "" {
+		s += "-" + v.pre
+	}
+	return s
+}
+
+// less orders versions by precedence, a pre-release comes before the release
+func (v semver) less(o semver) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+	if v.minor != o.minor {
+		return v.minor < o.minor
+	}
+	if v.patch != o.patch {
+		return v.patch < o.patch
+	}
+	if v.pre == "" || o.pre == "" {
+		return v.pre != "" && o.pre == ""
+	}
+	return comparePreRelease(v.pre, o.pre) < 0
+}
+
+// comparePreRelease compares pre-release labels such as rc2 and rc10 by their prefix, then numerically
+func comparePreRelease(a, b string) int {
+	split := func(s string) (string, int) {
+		i := len(s)
+		for i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+			i--
+		}
+		n, _ := strconv.Atoi(s[i:])
+		return s[:i], n
+	}
+	pa, na := split(a)
+	pb, nb := split(b)
+	if pa != pb {
+		return strings.Compare(pa, pb)
+	}
+	return na - nb
+}
+
+// matches reports whether the release version r satisfies the requested version v
+func (v semver) matches(r semver) bool {
+	if v.parts == 3 {
+		return v.major == r.major && v.minor == r.minor && v.patch == r.patch && v.pre == r.pre
+	}
+	if r.pre != "" {
+		return false
+	}
+	return v.major == r.major && (v.parts < 2 || v.minor == r.minor)
+}
+
+type release struct {
+	TagName    string         `json:"tag_name"`
+	Draft      bool           `json:"draft"`
+	Prerelease bool           `json:"prerelease"`
+	Assets     []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// asset returns the download URL of the named asset of the release
+func (r release) asset(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// releasesURL returns the releases endpoint, DefaultReleasesURL unless overridden with LLAMA_EMBEDDER_RELEASES_URL
+func releasesURL(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if url := os.Getenv(releasesURLEnv); url != "" {
+		return url
+	}
+	return DefaultReleasesURL
+}
+
+// releaseDownloadURL returns the base URL exact versions are downloaded from without listing the releases of endpoint.
+// A releases endpoint other than DefaultReleasesURL serves the assets under <endpoint>/download.
+func releaseDownloadURL(endpoint string) string {
+	if endpoint == DefaultReleasesURL {
+		return DefaultReleaseDownloadURL
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/download"
+}
+
+// fetchReleases lists the releases of the repository. GITHUB_TOKEN is used if set to avoid the API rate limit.
+func fetchReleases(endpoint string) ([]release, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint+"?per_page=100", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list releases: bad status: %s", resp.Status)
+	}
+	var releases []release
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("failed to decode releases: %w", err)
+	}
+	return releases, nil
+}
+
+// resolveRelease returns the newest Go binding release matching version, which is latest, a full or a partial version.
+// latest and partial versions only match stable releases.
+func resolveRelease(releases []release, version string) (release, semver, error) {
+	want := semver{}
+	if version != SharedLibVersionLatest {
+		var err error
+		if want, err = parseVersion(version); err != nil {
+			return release{}, semver{}, err
+		}
+	}
+	var best release
+	var bestVersion semver
+	found := false
+	for _, r := range releases {
+		if r.Draft || !strings.HasPrefix(r.TagName, releaseTagPrefix) {
+			continue
+		}
+		v, err := parseVersion(strings.TrimPrefix(r.TagName, releaseTagPrefix))
+		if err != nil || v.parts != 3 {
+			continue
+		}
+		if version == SharedLibVersionLatest {
+			if r.Prerelease || v.pre != "" {
+				continue
+			}
+		} else if !want.matches(v) {
+			continue
+		}
+		if !found || bestVersion.less(v) {
+			best, bestVersion, found = r, v, true
+		}
+	}
+	if !found {
+		return release{}, semver{}, fmt.Errorf("no release of the shared library matches version %s", version)
+	}
+	return best, bestVersion, nil
+}
+
+// checksumURLs returns the URLs of the checksums of the named asset published with the release
+func (r release) checksumURLs(name string) []string {
+	var urls []string
+	for _, asset := range []string{checksumsAsset, name + ".sha256"} {
+		if url, ok := r.asset(asset); ok {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// publishedChecksum returns the SHA256 checksum of the named asset from the first of urls that exists, either a
+// checksums.txt or an <asset>.sha256 file. It returns an empty string if none of them exists.
+func publishedChecksum(urls []string, name string) (string, error) {
+	for _, url := range urls {
+		resp, err := http.Get(url)
+		if err != nil {
+			return "", fmt.Errorf("failed to download checksums: %w", err)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to download checksums: bad status: %s", resp.Status)
+		}
+		return parseChecksum(resp.Body, url, name)
+	}
+	return "", nil
+}
+
+// parseChecksum reads the checksum of the named asset from the checksums file downloaded from url
+func parseChecksum(body io.Reader, url string, name string) (string, error) {
+	// sha256sum format, "<hex>  <name>" per line; a .sha256 file may hold the checksum alone
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 1 && !strings.HasSuffix(url, checksumsAsset) {
+			return strings.ToLower(fields[0]), nil
+		}
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read checksums: %w", err)
+	}
+	return "", fmt.Errorf("no checksum published for %s", name)
+}
+
+// checkABIVersion checks that the loaded shared library implements the ABI this package is built against. Libraries
+// that predate versioning report -1 and are used through the fallbacks of the wrapper.
+func checkABIVersion(libraryABI int, libraryVersion string) error {
+	if libraryABI < 0 || libraryABI == abiVersion {
+		return nil
+	}
+	return fmt.Errorf("%w: shared library %s implements ABI version %d, this package requires version %d",
+		ErrLibraryLoad, libraryVersion, libraryABI, abiVersion)
+}
@@ -0,0 +1,243 @@
+package llama_embedder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	for _, version := range []string{"latest", "v0.0.8", "0.0.8", "v1", "v1.2", "v0.1.0-rc1", "v0.1.0-beta.2"} {
+		require.NoError(t, validateVersion(version), version)
+	}
+	for _, version := range []string{"", "v", "vX.Y.Z", "v1.2.3.4", "v1-rc1", "v1.2.3-", "../v1.2.3"} {
+		require.Error(t, validateVersion(version), version)
+	}
+	require.True(t, isExactVersion("v0.0.8"))
+	require.False(t, isExactVersion("v0.1"))
+	require.False(t, isExactVersion(SharedLibVersionLatest))
+
+	v, err := parseVersion("0.1.0-rc10")
+	require.NoError(t, err)
+	require.Equal(t, "v0.1.0-rc10", v.String())
+	rc2, _ := parseVersion("v0.1.0-rc2")
+	final, _ := parseVersion("v0.1.0")
+	require.True(t, rc2.less(v))
+	require.True(t, v.less(final))
+	require.False(t, final.less(v))
+}
+
+func TestResolveRelease(t *testing.T) {
+	releases := []release{
+		{TagName: "go/v0.0.8"},
+		{TagName: "go/v0.0.10"},
+		{TagName: "go/v0.1.0"},
+		{TagName: "go/v0.2.0-rc1", Prerelease: true},
+		{TagName: "go/v0.3.0", Draft: true},
+		{TagName: "v9.0.0"},
+		{TagName: "go/nightly"},
+	}
+	for version, expected := range map[string]string{
+		SharedLibVersionLatest: "go/v0.1.0",
+		"v0":                   "go/v0.1.0",
+		"v0.0":                 "go/v0.0.10",
+		"v0.0.8":               "go/v0.0.8",
+		"0.0.8":                "go/v0.0.8",
+		"v0.2.0-rc1":           "go/v0.2.0-rc1",
+	} {
+		r, _, err := resolveRelease(releases, version)
+		require.NoError(t, err, version)
+		require.Equal(t, expected, r.TagName, version)
+	}
+	for _, version := range []string{"v0.3.0", "v0.0.9", "v1", "v9.0.0"} {
+		_, _, err := resolveRelease(releases, version)
+		require.Error(t, err, version)
+	}
+}
+
+func TestCheckABIVersion(t *testing.T) {
+	require.NoError(t, checkABIVersion(abiVersion, "v0.0.9"))
+	require.NoError(t, checkABIVersion(-1, ""), "libraries that predate versioning are accepted")
+	err := checkABIVersion(abiVersion+1, "v9.0.0")
+	require.ErrorIs(t, err, ErrLibraryLoad)
+	require.Contains(t, err.Error(), "v9.0.0")
+}
+
+// releaseServer serves a releases endpoint with a single release holding a shared library archive for this platform
+type releaseServer struct {
+	*httptest.Server
+	name      string
+	archive   []byte
+	checksums string
+	// noChecksums makes the server publish no checksums
+	noChecksums bool
+	requests    atomic.Int32
+	downloads   atomic.Int32
+}
+
+func newReleaseServer(t *testing.T, version string) *releaseServer {
+	cos, carch, ext, err := libraryPlatform()
+	require.NoError(t, err)
+	if ext != "tar.gz" {
+		t.Skip("the test archive is a tar.gz")
+	}
+	name := "llama-embedder-" + cos + "-" + carch + "-" + version + "." + ext
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	content := []byte("not really a shared library")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: getOSSharedLibName(), Mode: 0644, Size: int64(len(content))}))
+	_, err = tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	sum := sha256.Sum256(buf.Bytes())
+
+	s := &releaseServer{name: name, archive: buf.Bytes(), checksums: hex.EncodeToString(sum[:]) + "  " + name + "\n"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/releases", func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		releases := []release{{
+			TagName: releaseTagPrefix + version,
+			Assets: []releaseAsset{
+				{Name: name, URL: s.URL + "/assets/" + name},
+				{Name: checksumsAsset, URL: s.URL + "/assets/" + checksumsAsset},
+			},
+		}}
+		require.NoError(t, json.NewEncoder(w).Encode(releases))
+	})
+	// the assets are listed in the releases and served under the tag for downloads without a lookup
+	for _, prefix := range []string{"/assets/", "/releases/download/" + releaseTagPrefix + version + "/"} {
+		mux.HandleFunc(prefix+name, func(w http.ResponseWriter, r *http.Request) {
+			s.downloads.Add(1)
+			_, _ = w.Write(s.archive)
+		})
+		mux.HandleFunc(prefix+checksumsAsset, func(w http.ResponseWriter, r *http.Request) {
+			if s.noChecksums {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = fmt.Fprint(w, s.checksums)
+		})
+	}
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestEnsureLibraryFromReleases(t *testing.T) {
+	t.Run("Latest is resolved, verified and cached", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		s := newReleaseServer(t, "v0.0.9")
+
+		path, err := ensureLibrary(cacheDir, SharedLibVersionLatest, s.URL+"/releases", false)
+		require.NoError(t, err)
+		require.FileExists(t, path)
+		require.Equal(t, cacheDir, filepath.Dir(filepath.Dir(path)))
+		require.Contains(t, path, "v0.0.9")
+
+		cached, err := ensureLibrary(cacheDir, "v0.0.9", s.URL+"/releases", false)
+		require.NoError(t, err)
+		require.Equal(t, path, cached)
+		require.Equal(t, int32(1), s.requests.Load(), "cached exact versions must not query the releases")
+	})
+
+	t.Run("Exact versions are downloaded without listing the releases", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		s := newReleaseServer(t, "v0.0.9")
+
+		path, err := ensureLibrary(cacheDir, "v0.0.9", s.URL+"/releases", false)
+		require.NoError(t, err)
+		require.FileExists(t, path)
+		require.Equal(t, int32(0), s.requests.Load())
+		require.Equal(t, int32(1), s.downloads.Load())
+
+		_, err = ensureLibrary(t.TempDir(), "v0.0.10", s.URL+"/releases", false)
+		require.Error(t, err)
+	})
+
+	t.Run("Missing checksums are refused unless skipped", func(t *testing.T) {
+		for _, version := range []string{"v0.0.9", SharedLibVersionLatest} {
+			cacheDir := t.TempDir()
+			s := newReleaseServer(t, "v0.0.9")
+			s.noChecksums = true
+
+			_, err := ensureLibrary(cacheDir, version, s.URL+"/releases", false)
+			require.ErrorContains(t, err, "WithInsecureSkipChecksum")
+			require.Equal(t, int32(0), s.downloads.Load())
+
+			path, err := ensureLibrary(cacheDir, version, s.URL+"/releases", true)
+			require.NoError(t, err)
+			require.FileExists(t, path)
+		}
+	})
+
+	t.Run("Releases URL from the environment", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		s := newReleaseServer(t, "v0.0.9")
+		t.Setenv(releasesURLEnv, s.URL+"/releases")
+
+		path, err := ensureLibrary(cacheDir, "v0.0", "", false)
+		require.NoError(t, err)
+		require.FileExists(t, path)
+	})
+
+	t.Run("Concurrent calls download once", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		s := newReleaseServer(t, "v0.0.9")
+
+		var wg sync.WaitGroup
+		paths := make([]string, 4)
+		errs := make([]error, len(paths))
+		for i := range paths {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				paths[i], errs[i] = ensureLibrary(cacheDir, SharedLibVersionLatest, s.URL+"/releases", false)
+			}(i)
+		}
+		wg.Wait()
+		for i := range paths {
+			require.NoError(t, errs[i])
+			require.Equal(t, paths[0], paths[i])
+		}
+		require.Equal(t, int32(1), s.downloads.Load())
+	})
+
+	t.Run("Checksum mismatch", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		s := newReleaseServer(t, "v0.0.9")
+		s.checksums = fmt.Sprintf("%064d  %s\n", 0, s.name)
+
+		_, err := ensureLibrary(cacheDir, "v0.0.9", s.URL+"/releases", false)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "checksum mismatch")
+		entries, err := os.ReadDir(cacheDir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			require.True(t, strings.HasPrefix(entry.Name(), "."), "nothing but the lock file must be left after a failed verification, found %s", entry.Name())
+		}
+	})
+
+	t.Run("Unknown version", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		s := newReleaseServer(t, "v0.0.9")
+
+		_, err := ensureLibrary(cacheDir, "v1", s.URL+"/releases", false)
+		require.Error(t, err)
+	})
+}
@@ -13,11 +13,18 @@ package llama_embedder
 */
 import "C"
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"unsafe"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/cachedir"
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/modelsource"
 )
 
 type NormalizationType int32
@@ -33,7 +40,10 @@ const (
 	PoolingMean              PoolingType       = 1
 	PoolingCls               PoolingType       = 2
 	PoolingLast              PoolingType       = 3
-	LatestSharedLibVersion                     = "v0.0.8"
+	PoolingRank              PoolingType       = 4
+	// LatestSharedLibVersion is the shared library downloaded by default, the release built from this version of the
+	// bindings and published with checksums. Older libraries fail the native APIs they predate with ErrNotSupported.
+	LatestSharedLibVersion = "v0.0.9"
 )
 
 type LlamaEmbedder struct {
@@ -41,16 +51,35 @@ type LlamaEmbedder struct {
 	sharedLibraryPath            string
 	defaultNormalizationType     NormalizationType
 	defaultPoolingType           PoolingType
+	dimensions                   int
+	embeddingSize                int
+	contextParams                contextParams
 	hfRepo                       string
+	modelSource                  modelsource.Source
 	localCacheDir                string
+	libCacheDir                  string
 	sharedLibraryVersion         string
+	releasesURL                  string
+	insecureSkipChecksum         bool
 	sharedLibPathUserProvided    bool
+	modelCacheDirUserProvided    bool
 	sharedLibVersionUserProvided bool
 }
 
+// contextParams are the llama.cpp context parameters of the embedder. Zero values keep the llama.cpp defaults.
+type contextParams struct {
+	contextSize    uint32
+	batchSize      uint32
+	ubatchSize     uint32
+	threads        int32
+	useMmap        bool
+	useMlock       bool
+	flashAttention bool
+}
+
 type Option func(*LlamaEmbedder) error
 
-var defaultCacheDir = filepath.Join(os.Getenv("HOME"), ".cache/llama_cache")
+var defaultCacheDir = cachedir.DefaultRoot()
 var defaultModelCacheDir = filepath.Join(defaultCacheDir, "models")
 var defaultLibCacheDir = filepath.Join(defaultCacheDir, "libs")
 
@@ -64,7 +93,8 @@ func WithNormalization(norm NormalizationType) Option {
 }
 
 // WithPooling sets the pooling type to use
-// Possible values are PoolingNone, PoolingMean (default), PoolingCls, PoolingLast
+// Possible values are PoolingNone, PoolingMean (default), PoolingCls, PoolingLast, PoolingRank.
+// PoolingRank is used with reranker (cross-encoder) models together with Rerank.
 func WithPooling(pool PoolingType) Option {
 	return func(e *LlamaEmbedder) error {
 		e.defaultPoolingType = pool
@@ -72,6 +102,88 @@ func WithPooling(pool PoolingType) Option {
 	}
 }
 
+// WithDimensions truncates embeddings to the first dimensions values before normalization is applied.
+// Use it with Matryoshka-trained models (e.g. nomic-embed, arctic-embed-m, mxbai-embed) to get shorter vectors.
+// dimensions must not exceed the embedding length of the model.
+func WithDimensions(dimensions int) Option {
+	return func(e *LlamaEmbedder) error {
+		if dimensions <= 0 {
+			return fmt.Errorf("dimensions must be positive")
+		}
+		e.dimensions = dimensions
+		return nil
+	}
+}
+
+// WithContextSize sets the context size in tokens. By default the training context size of the model is used.
+// The batch size must be at least the context size.
+func WithContextSize(size uint32) Option {
+	return func(e *LlamaEmbedder) error {
+		if size == 0 {
+			return fmt.Errorf("context size must be positive")
+		}
+		e.contextParams.contextSize = size
+		return nil
+	}
+}
+
+// WithBatchSize sets the logical batch size, which is also the maximum number of tokens of a single text (2048 by default)
+func WithBatchSize(size uint32) Option {
+	return func(e *LlamaEmbedder) error {
+		if size == 0 {
+			return fmt.Errorf("batch size must be positive")
+		}
+		e.contextParams.batchSize = size
+		return nil
+	}
+}
+
+// WithUBatchSize sets the physical batch size. It defaults to the batch size, which non-causal (BERT-like) models require.
+func WithUBatchSize(size uint32) Option {
+	return func(e *LlamaEmbedder) error {
+		if size == 0 {
+			return fmt.Errorf("ubatch size must be positive")
+		}
+		e.contextParams.ubatchSize = size
+		return nil
+	}
+}
+
+// WithThreads sets the number of threads used to compute embeddings. By default llama.cpp uses the number of CPU cores.
+func WithThreads(threads int) Option {
+	return func(e *LlamaEmbedder) error {
+		if threads <= 0 {
+			return fmt.Errorf("threads must be positive")
+		}
+		e.contextParams.threads = int32(threads)
+		return nil
+	}
+}
+
+// WithMmap sets whether the model file is memory-mapped (default true)
+func WithMmap(enabled bool) Option {
+	return func(e *LlamaEmbedder) error {
+		e.contextParams.useMmap = enabled
+		return nil
+	}
+}
+
+// WithMlock sets whether the model is locked in RAM to prevent it from being swapped out (default false)
+func WithMlock(enabled bool) Option {
+	return func(e *LlamaEmbedder) error {
+		e.contextParams.useMlock = enabled
+		return nil
+	}
+}
+
+// WithFlashAttention enables flash attention (default false)
+func WithFlashAttention(enabled bool) Option {
+	return func(e *LlamaEmbedder) error {
+		e.contextParams.flashAttention = enabled
+		return nil
+	}
+}
+
 // WithHFRepo sets the Hugging Face repo to download the model from
 func WithHFRepo(repo string) Option {
 	return func(e *LlamaEmbedder) error {
@@ -83,6 +195,19 @@ func WithHFRepo(repo string) Option {
 	}
 }
 
+// WithModelSource fetches the model from src, e.g. an S3-compatible bucket with credentials other than the AWS
+// environment variables. Remote models are downloaded into the model cache; the modelPath of the constructor is then
+// ignored and may be empty.
+func WithModelSource(src modelsource.Source) Option {
+	return func(e *LlamaEmbedder) error {
+		if src == nil {
+			return fmt.Errorf("model source is nil")
+		}
+		e.modelSource = src
+		return nil
+	}
+}
+
 // WithModelCacheDir sets the directory to cache the model. If the directory does not exist, it will be created.
 func WithModelCacheDir(modelCacheDir string) Option {
 	return func(e *LlamaEmbedder) error {
@@ -99,23 +224,78 @@ func WithModelCacheDir(modelCacheDir string) Option {
 			return err
 		}
 		e.localCacheDir = absDir
+		e.modelCacheDirUserProvided = true
 		return nil
 	}
 }
 
-// WithSharedLibraryVersion sets the shared library version to use. This is overridden by WithSharedLibraryPath
+// WithCacheDir sets the cache root for both downloaded models and shared libraries, overriding LLAMA_CACHE_DIR and
+// XDG_CACHE_HOME. WithModelCacheDir still overrides the model directory. The directories are created if needed.
+func WithCacheDir(cacheDir string) Option {
+	return func(e *LlamaEmbedder) error {
+		if cacheDir == "" {
+			return fmt.Errorf("cache dir is not set")
+		}
+		expandedPath, err := expandTilde(cacheDir)
+		if err != nil {
+			return err
+		}
+		absDir, err := filepath.Abs(expandedPath)
+		if err != nil {
+			return err
+		}
+		m := cachedir.New(absDir)
+		if err := m.Ensure(); err != nil {
+			return err
+		}
+		if !e.modelCacheDirUserProvided {
+			e.localCacheDir = m.ModelDir()
+		}
+		e.libCacheDir = m.LibraryDir()
+		return nil
+	}
+}
+
+// WithSharedLibraryVersion sets the shared library version to use: SharedLibVersionLatest, a full version such as v0.0.8
+// or v0.1.0-rc1, or a partial version such as v0 or v0.1 selecting the newest matching release. This is overridden by
+// WithSharedLibraryPath
 func WithSharedLibraryVersion(version string) Option {
 	return func(e *LlamaEmbedder) error {
 		if version == "" {
 			return fmt.Errorf("shared library version is empty")
 		}
-		// TODO make the check more robust - latest or valid vX.Y.Z(-rcN/-alphaN/-betaN)
+		if err := validateVersion(version); err != nil {
+			return err
+		}
 		e.sharedLibVersionUserProvided = true
 		e.sharedLibraryVersion = version
 		return nil
 	}
 }
 
+// WithSharedLibraryReleasesURL sets the GitHub releases API endpoint shared library versions are resolved against and
+// downloaded from. It defaults to the LLAMA_EMBEDDER_RELEASES_URL environment variable or DefaultReleasesURL. Exact
+// versions are downloaded without a lookup from <url>/download/<tag>/<asset>.
+func WithSharedLibraryReleasesURL(url string) Option {
+	return func(e *LlamaEmbedder) error {
+		if url == "" {
+			return fmt.Errorf("releases URL is empty")
+		}
+		e.releasesURL = url
+		return nil
+	}
+}
+
+// WithInsecureSkipChecksum allows downloading a shared library from a release that publishes no checksums, such as the
+// releases up to v0.0.8, which is used unverified. Archives that do not match a published checksum are refused
+// regardless. The default LatestSharedLibVersion publishes checksums and does not need it.
+func WithInsecureSkipChecksum() Option {
+	return func(e *LlamaEmbedder) error {
+		e.insecureSkipChecksum = true
+		return nil
+	}
+}
+
 // WithSharedLibraryPath sets the shared library path to use. LlamaEmbedder will look for shared library under this path.
 // This overrides WithSharedLibraryVersion.
 func WithSharedLibraryPath(libPath string) Option {
@@ -137,52 +317,80 @@ func WithSharedLibraryPath(libPath string) Option {
 }
 
 func NewLlamaEmbedder(modelPath string, opts ...Option) (*LlamaEmbedder, func(), error) {
+	e, err := prepareEmbedder(modelPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	freeFunc := func() {
+		e.Close()
+	}
+	err = e.initEmbedder()
+	if err != nil {
+		defer freeFunc()
+		return nil, nil, err
+	}
+	if n, ok := e.embeddingLength(); ok {
+		e.embeddingSize = n
+		if e.dimensions > n {
+			defer freeFunc()
+			return nil, nil, fmt.Errorf("dimensions %d exceed the embedding length %d of the model", e.dimensions, n)
+		}
+	}
+	return e, freeFunc, nil
+}
+
+// prepareEmbedder applies the options, makes sure the model is available locally and loads the shared library
+func prepareEmbedder(modelPath string, opts []Option) (*LlamaEmbedder, error) {
 	e := &LlamaEmbedder{
 		defaultNormalizationType: NormalizationL2,
 		defaultPoolingType:       PoolingMean,
 		localCacheDir:            defaultModelCacheDir,
+		libCacheDir:              defaultLibCacheDir,
 		sharedLibraryPath:        filepath.Join(defaultLibCacheDir, LatestSharedLibVersion),
 		sharedLibraryVersion:     LatestSharedLibVersion,
+		contextParams:            contextParams{useMmap: true},
 	}
 	for _, opt := range opts {
 		err := opt(e)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 	}
-	if modelPath == "" {
-		return nil, nil, fmt.Errorf("modelPath is not set")
+	if modelPath == "" && e.modelSource == nil {
+		return nil, fmt.Errorf("modelPath is not set")
 	}
 	err := ensureCacheDir()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	if e.hfRepo != "" {
-		e.modelPath = filepath.Join(e.localCacheDir, filepath.Base(modelPath))
-		err := downloadHFModel(e.hfRepo, modelPath, e.modelPath, "")
+	src := e.modelSource
+	if src == nil && e.hfRepo != "" {
+		if src, err = modelsource.HuggingFace(e.hfRepo, modelPath, os.Getenv("HF_TOKEN")); err != nil {
+			return nil, err
+		}
+	} else if src == nil && modelsource.IsURI(modelPath) {
+		if src, err = modelsource.Parse(modelPath); err != nil {
+			return nil, err
+		}
+	}
+	if src != nil {
+		e.modelPath, err = src.Fetch(context.Background(), e.localCacheDir)
+		if errors.Is(err, modelsource.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrModelNotFound, src)
+		}
 		if err != nil {
-			return nil, nil, err
+			return nil, fmt.Errorf("failed to fetch model from %s: %w", src, err)
 		}
 	} else {
 		if _, err := os.Stat(modelPath); os.IsNotExist(err) {
-			return nil, nil, err
+			return nil, fmt.Errorf("%w: %s", ErrModelNotFound, modelPath)
 		}
 		e.modelPath = modelPath
 	}
-	err = e.loadLibrary()
-	freeFunc := func() {
-		e.Close()
+	if err := e.loadLibrary(); err != nil {
+		return nil, err
 	}
-	if err != nil {
-
-		return nil, nil, err
-	}
-	err = e.initEmbedder()
-	if err != nil {
-		defer freeFunc()
-		return nil, nil, err
-	}
-	return e, freeFunc, nil
+	return e, nil
 }
 
 // loadLibrary loads the shared library.
@@ -192,23 +400,28 @@ func (e *LlamaEmbedder) loadLibrary() (err error) {
 	if e.sharedLibPathUserProvided {
 		actualPath = filepath.Join(e.sharedLibraryPath, getOSSharedLibName())
 	} else if e.sharedLibVersionUserProvided {
-		actualPath, err = ensureLibrary(e.sharedLibraryVersion)
+		actualPath, err = ensureLibrary(e.libCacheDir, e.sharedLibraryVersion, e.releasesURL, e.insecureSkipChecksum)
 		if err != nil {
-			return
+			return fmt.Errorf("%w: %v", ErrLibraryLoad, err)
 		}
 	} else {
-		actualPath, err = ensureLibrary(LatestSharedLibVersion)
+		actualPath, err = ensureLibrary(e.libCacheDir, LatestSharedLibVersion, e.releasesURL, e.insecureSkipChecksum)
 		if err != nil {
-			return
+			return fmt.Errorf("%w: %v", ErrLibraryLoad, err)
 		}
 	}
 	if _, err := os.Stat(actualPath); os.IsNotExist(err) {
-		return fmt.Errorf("shared library not found: %v", err)
+		return fmt.Errorf("%w: shared library not found: %v", ErrLibraryLoad, err)
 	}
 	cLibPath := C.CString(actualPath)
 	defer C.free(unsafe.Pointer(cLibPath))
-	if C.load_library(cLibPath) == nil {
-		return fmt.Errorf("%v", C.GoString(C.get_last_error()))
+	var cErr C.EmbedderError
+	if C.load_library(cLibPath, &cErr) == nil {
+		return toError(&cErr)
+	}
+	if err := checkABIVersion(int(C.llama_embedder_library_abi_version()), LibraryVersion()); err != nil {
+		C.free_llama_embedder()
+		return err
 	}
 	return nil
 }
@@ -217,19 +430,96 @@ func (e *LlamaEmbedder) loadLibrary() (err error) {
 func (e *LlamaEmbedder) initEmbedder() error {
 	cModelPath := C.CString(e.modelPath)
 	defer C.free(unsafe.Pointer(cModelPath))
-	if C.init_llama_embedder(cModelPath, C.uint32_t(uint32(e.defaultPoolingType))) != 0 {
-		return fmt.Errorf("failed to initialize llama backend %v", C.GoString(C.get_last_error()))
+	params := e.params()
+	var cErr C.EmbedderError
+	if C.init_llama_embedder_with_params(cModelPath, &params, &cErr) != 0 {
+		return fmt.Errorf("failed to initialize llama backend: %w", toError(&cErr))
 	}
 	return nil
 }
 
+// params returns the native parameters of the embedder
+func (e *LlamaEmbedder) params() C.EmbedderParams {
+	return C.EmbedderParams{
+		pooling_type: C.uint32_t(uint32(e.defaultPoolingType)),
+		n_ctx:        C.uint32_t(e.contextParams.contextSize),
+		n_batch:      C.uint32_t(e.contextParams.batchSize),
+		n_ubatch:     C.uint32_t(e.contextParams.ubatchSize),
+		n_threads:    C.int32_t(e.contextParams.threads),
+		use_mmap:     C.bool(e.contextParams.useMmap),
+		use_mlock:    C.bool(e.contextParams.useMlock),
+		flash_attn:   C.bool(e.contextParams.flashAttention),
+	}
+}
+
+// toError converts the error reported by a native call to a Go error
+func toError(cErr *C.EmbedderError) error {
+	return nativeError(int32(cErr.code), C.GoString(&cErr.message[0]))
+}
+
+// embeddingLength returns the embedding length of the model from its metadata
+func (e *LlamaEmbedder) embeddingLength() (int, bool) {
+	return embeddingLength(e.GetMetadata())
+}
+
+func embeddingLength(metadata map[string]string) (int, bool) {
+	n, err := strconv.Atoi(metadata[metadata["general.architecture"]+".embedding_length"])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
 // Close closes the embedder and frees any resources
 func (e *LlamaEmbedder) Close() {
 	C.free_llama_embedder()
 }
 
-// EmbedTexts embeds the given texts using the model
+// EmbedTexts embeds the given texts using the model with the default options of the embedder
 func (e *LlamaEmbedder) EmbedTexts(texts []string) ([][]float32, error) {
+	return e.EmbedTextsWithOptions(texts)
+}
+
+// EmbedTextsWithOptions embeds the given texts using the model. The options override the normalization and output
+// dimensions of the embedder for this call only, and can add a prefix to every text or enable truncation of long texts.
+func (e *LlamaEmbedder) EmbedTextsWithOptions(texts []string, opts ...EmbedOption) ([][]float32, error) {
+	return e.EmbedTextsContext(context.Background(), texts, opts...)
+}
+
+// EmbedTextsContext embeds texts like EmbedTextsWithOptions and stops as soon as ctx is done, between the internal
+// batches and within a batch through the llama.cpp abort callback, returning ctx.Err(). Shared libraries older than
+// this version only check ctx before the call starts.
+func (e *LlamaEmbedder) EmbedTextsContext(ctx context.Context, texts []string, opts ...EmbedOption) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	options, err := e.embedOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return embedTexts(ctx, nil, texts, options)
+}
+
+// embedOptions returns the options of a call, starting from the defaults of the embedder
+func (e *LlamaEmbedder) embedOptions(opts []EmbedOption) (embedOptions, error) {
+	options := embedOptions{
+		normalization: e.defaultNormalizationType,
+		dimensions:    e.dimensions,
+	}
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+// embedTexts embeds texts with the pool context handle, or with the embedder of LlamaEmbedder if handle is nil
+func embedTexts(ctx context.Context, handle *C.llama_embedder, texts []string, options embedOptions) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("%w: no texts to embed", ErrEmptyInput)
+	}
+	texts = applyPrefix(texts, options.prefix)
 	cTexts := make([]*C.char, len(texts))
 	for i, t := range texts {
 		cTexts[i] = C.CString(t)
@@ -240,30 +530,227 @@ func (e *LlamaEmbedder) EmbedTexts(texts []string) ([][]float32, error) {
 			C.free(unsafe.Pointer(t))
 		}
 	}()
-	result := C.llama_embedder_embed((**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), C.int32_t(int32(e.defaultNormalizationType)))
+	cOptions := options.toC()
+	var cErr C.EmbedderError
+	var result C.FloatMatrix
+	switch {
+	case handle != nil:
+		var cancel *C.int32_t
+		if ctx.Done() != nil {
+			var release func()
+			cancel, release = watchContext(ctx)
+			defer release()
+		}
+		result = C.llama_embedder_context_embed(handle, (**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), &cOptions, cancel, &cErr)
+	case ctx.Done() == nil:
+		// the context can never be cancelled
+		result = C.llama_embedder_embed_with_options((**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), &cOptions, &cErr)
+	default:
+		cancel, release := watchContext(ctx)
+		result = C.llama_embedder_embed_cancellable((**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), &cOptions, cancel, &cErr)
+		release()
+	}
 	defer func() {
 		C.free_float_matrixw(&result)
 	}()
 	if result.data == nil {
-		return nil, fmt.Errorf("failed to embed text: %v", C.GoString(C.get_last_error()))
+		if cErr.code == statusCancelled && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to embed text: %w", toError(&cErr))
 	}
 
-	// Convert the result to a Go slice
-	goResult := make([][]float32, result.rows)
-	for i := 0; i < int(result.rows); i++ {
-		goResult[i] = make([]float32, result.cols)
-		for j := 0; j < int(result.cols); j++ {
-			index := i*int(result.cols) + j
-			goResult[i][j] = float32(*(*C.float)(unsafe.Pointer(uintptr(unsafe.Pointer(result.data)) + uintptr(index)*unsafe.Sizeof(C.float(0)))))
-		}
+	// Copy the result into a single Go allocation shared by all rows
+	m := NewMatrix(int(result.rows), int(result.cols))
+	copy(m.Data, unsafe.Slice((*float32)(unsafe.Pointer(result.data)), len(m.Data)))
+	goResult := m.Slices()
+	if options.dimensions > 0 {
+		return truncateEmbeddings(goResult, options.dimensions, options.normalization)
 	}
 	return goResult, nil
 }
 
+// watchContext returns a flag in C memory that is set once ctx is done, for the native code to poll, and a function
+// that stops watching and frees the flag
+func watchContext(ctx context.Context) (*C.int32_t, func()) {
+	cancel := (*C.int32_t)(C.calloc(1, C.sizeof_int32_t))
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32((*int32)(unsafe.Pointer(cancel)), 1)
+		case <-done:
+		}
+	}()
+	return cancel, func() {
+		close(done)
+		<-stopped
+		C.free(unsafe.Pointer(cancel))
+	}
+}
+
+// toC returns the native options of a call
+func (o embedOptions) toC() C.EmbedOptions {
+	cOptions := C.EmbedOptions{
+		embd_norm: C.int32_t(int32(o.normalization)),
+		truncate:  C.bool(o.truncate),
+	}
+	if o.dimensions > 0 {
+		// normalize after truncation, the native side would normalize the full vector
+		cOptions.embd_norm = C.int32_t(int32(NormalizationNone))
+	}
+	return cOptions
+}
+
+// EmbedTextsInto embeds texts with the default options of the embedder into dst. The native code writes the
+// embeddings directly into dst.Data, which is reused across calls and only reallocated when it is too small, so
+// embedding in a loop with the same Matrix does not allocate per call.
+func (e *LlamaEmbedder) EmbedTextsInto(dst *Matrix, texts []string) error {
+	if dst == nil {
+		return fmt.Errorf("%w: destination matrix is nil", ErrInvalidArgument)
+	}
+	if len(texts) == 0 {
+		return fmt.Errorf("%w: no texts to embed", ErrEmptyInput)
+	}
+	options := embedOptions{normalization: e.defaultNormalizationType, dimensions: e.dimensions}
+	cOptions := options.toC()
+	cTexts := make([]*C.char, len(texts))
+	for i, t := range texts {
+		cTexts[i] = C.CString(t)
+	}
+	defer func() {
+		for _, t := range cTexts {
+			C.free(unsafe.Pointer(t))
+		}
+	}()
+	dst.grow(len(texts) * e.embeddingSize)
+	for attempt := 0; ; attempt++ {
+		var out *C.float
+		if len(dst.Data) > 0 {
+			out = (*C.float)(unsafe.Pointer(&dst.Data[0]))
+		}
+		var rows, cols C.size_t
+		var cErr C.EmbedderError
+		if C.llama_embedder_embed_into((**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), &cOptions, out, C.size_t(len(dst.Data)), &rows, &cols, &cErr) != 0 {
+			// the shape is reported before anything is computed, grow the buffer once and retry
+			if needed := int(rows) * int(cols); attempt == 0 && needed > len(dst.Data) {
+				dst.grow(needed)
+				continue
+			}
+			return fmt.Errorf("failed to embed text: %w", toError(&cErr))
+		}
+		dst.resize(int(rows), int(cols))
+		break
+	}
+	if options.dimensions > 0 {
+		return dst.truncate(options.dimensions, options.normalization)
+	}
+	return nil
+}
+
+// TokenEmbeddings are the embeddings of every token of a text
+type TokenEmbeddings struct {
+	Tokens     []int32     `json:"tokens"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// EmbedTokens returns one embedding per token of each text together with the token ids, e.g. for ColBERT-style late
+// interaction. The embedder must be created WithPooling(PoolingNone); each token embedding is normalized with the
+// normalization of the embedder.
+func (e *LlamaEmbedder) EmbedTokens(texts []string) ([]TokenEmbeddings, error) {
+	if e.defaultPoolingType != PoolingNone {
+		return nil, fmt.Errorf("%w: token embeddings require an embedder created with PoolingNone", ErrInvalidArgument)
+	}
+	if len(texts) == 0 {
+		return []TokenEmbeddings{}, nil
+	}
+	cTexts := make([]*C.char, len(texts))
+	for i, t := range texts {
+		cTexts[i] = C.CString(t)
+	}
+	defer func() {
+		for _, t := range cTexts {
+			C.free(unsafe.Pointer(t))
+		}
+	}()
+	var cErr C.EmbedderError
+	result := C.llama_embedder_embed_tokens((**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), C.int32_t(int32(e.defaultNormalizationType)), &cErr)
+	defer C.free_token_embeddingsw(&result)
+	if result.lengths == nil {
+		return nil, fmt.Errorf("failed to embed tokens: %w", toError(&cErr))
+	}
+	lengths := unsafe.Slice((*C.size_t)(unsafe.Pointer(result.lengths)), int(result.rows))
+	var total int
+	for _, l := range lengths {
+		total += int(l)
+	}
+	cols := int(result.cols)
+	tokens := unsafe.Slice((*int32)(unsafe.Pointer(result.tokens)), total)
+	data := unsafe.Slice((*float32)(unsafe.Pointer(result.data)), total*cols)
+	out := make([]TokenEmbeddings, len(lengths))
+	offset := 0
+	for i, l := range lengths {
+		n := int(l)
+		out[i].Tokens = append([]int32(nil), tokens[offset:offset+n]...)
+		out[i].Embeddings = make([][]float32, n)
+		for j := 0; j < n; j++ {
+			out[i].Embeddings[j] = append([]float32(nil), data[(offset+j)*cols:(offset+j+1)*cols]...)
+		}
+		offset += n
+	}
+	return out, nil
+}
+
+// Rerank scores each of docs against query using a reranker model. The embedder must be created WithPooling(PoolingRank).
+// Scores are returned in the order of docs; higher scores mean more relevant documents.
+func (e *LlamaEmbedder) Rerank(query string, docs []string) ([]float32, error) {
+	if e.defaultPoolingType != PoolingRank {
+		return nil, fmt.Errorf("%w: rerank requires an embedder created with PoolingRank", ErrInvalidArgument)
+	}
+	if len(docs) == 0 {
+		return []float32{}, nil
+	}
+	cQuery := C.CString(query)
+	defer C.free(unsafe.Pointer(cQuery))
+	cDocs := make([]*C.char, len(docs))
+	for i, d := range docs {
+		cDocs[i] = C.CString(d)
+	}
+	defer func() {
+		for _, d := range cDocs {
+			C.free(unsafe.Pointer(d))
+		}
+	}()
+	var cErr C.EmbedderError
+	result := C.llama_embedder_rerank(cQuery, (**C.char)(unsafe.Pointer(&cDocs[0])), C.size_t(len(docs)), &cErr)
+	defer func() {
+		C.free_float_matrixw(&result)
+	}()
+	if result.data == nil {
+		return nil, fmt.Errorf("failed to rerank documents: %w", toError(&cErr))
+	}
+	scores := make([]float32, result.rows)
+	copy(scores, unsafe.Slice((*float32)(unsafe.Pointer(result.data)), int(result.rows)))
+	return scores, nil
+}
+
 // GetMetadata returns the metadata associated with the model
 func (e *LlamaEmbedder) GetMetadata() map[string]string {
 	var size C.size_t
 	cMetadataArray := C.llama_embedder_get_metadata(&size)
+	return toMetadata(cMetadataArray, size)
+}
+
+// LibraryVersion returns the version of the loaded shared library, such as v0.0.9, "dev" for local builds, or an empty
+// string if no library is loaded or it predates version reporting
+func LibraryVersion() string {
+	return C.GoString(C.llama_embedder_library_version())
+}
+
+// toMetadata converts and frees the key=value entries returned by the native metadata functions
+func toMetadata(cMetadataArray **C.char, size C.size_t) map[string]string {
 	defer C.free_metadata(cMetadataArray, size)
 
 	metadata := make(map[string]string)
@@ -1,22 +1,47 @@
 package llama_embedder
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"net/http"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 const defaultHFRepo = "leliuga/all-MiniLM-L6-v2-GGUF"
 const defaultModelFile = "all-MiniLM-L6-v2.Q4_0.gguf"
 
+// skipIfNotSupported skips a test of a native API that the shared library under test predates, such as a release up to
+// v0.0.8 selected with SHARED_LIB_PATH
+func skipIfNotSupported(t *testing.T, err error) {
+	t.Helper()
+	if errors.Is(err, ErrNotSupported) {
+		t.Skipf("the shared library does not implement this API: %v", err)
+	}
+}
+
+// skipUnpublishedRelease skips a test that downloads the shared library release version before it is published, such
+// as while the release of LatestSharedLibVersion is being built
+func skipUnpublishedRelease(t *testing.T, version string) {
+	t.Helper()
+	resp, err := http.Head("https://github.com/amikos-tech/llamacpp-embedder/releases/tag/go/" + version)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		t.Skipf("shared library release %s is not published yet", version)
+	}
+}
+
 func TestLlamaEmbedder(t *testing.T) {
 	sharedLibPath := os.Getenv("SHARED_LIB_PATH")
 	if sharedLibPath == "" {
 		sharedLibPath = "../../build/"
 	}
-	err := downloadHFModel(defaultHFRepo, defaultModelFile, defaultModelFile, "")
-	require.NoError(t, err, "Failed to download model")
+	downloadTestModel(t)
 	t.Cleanup(func() {
 		err := os.Remove("all-MiniLM-L6-v2.Q4_0.gguf")
 		if err != nil {
@@ -39,6 +64,109 @@ func TestLlamaEmbedder(t *testing.T) {
 		for _, r := range res {
 			require.Len(t, r, 384, "Failed to embed texts")
 		}
+
+		_, err = e.EmbedTexts(nil)
+		require.ErrorIs(t, err, ErrEmptyInput)
+	})
+
+	t.Run("Test EmbedTextsWithOptions", func(t *testing.T) {
+		e, closeFunc, err := NewLlamaEmbedder(defaultModelFile, WithSharedLibraryPath(sharedLibPath), WithBatchSize(512))
+		skipIfNotSupported(t, err)
+		require.NoError(t, err, "Failed to create LlamaEmbedder")
+		t.Cleanup(closeFunc)
+
+		res, err := e.EmbedTextsWithOptions([]string{"hello"}, WithEmbedDimensions(128), WithEmbedPrefix("query: "))
+		require.NoError(t, err, "Failed to embed texts")
+		require.Len(t, res[0], 128)
+
+		defaults, err := e.EmbedTexts([]string{"query: hello"})
+		require.NoError(t, err, "Failed to embed texts")
+		require.Len(t, defaults[0], 384, "per-call options must not change the embedder defaults")
+
+		long := strings.Repeat("hello world ", 1000)
+		_, err = e.EmbedTexts([]string{long})
+		require.ErrorIs(t, err, ErrInputTooLong)
+		res, err = e.EmbedTextsWithOptions([]string{long}, WithEmbedTruncation(true))
+		skipIfNotSupported(t, err)
+		require.NoError(t, err, "Failed to embed truncated text")
+		require.Len(t, res[0], 384)
+
+		_, err = e.EmbedTextsWithOptions([]string{"hello"}, WithEmbedDimensions(0))
+		require.Error(t, err)
+	})
+
+	t.Run("Test EmbedTextsContext", func(t *testing.T) {
+		e, closeFunc, err := NewLlamaEmbedder(defaultModelFile, WithSharedLibraryPath(sharedLibPath))
+		require.NoError(t, err, "Failed to create LlamaEmbedder")
+		t.Cleanup(closeFunc)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = e.EmbedTextsContext(ctx, []string{"hello"})
+		require.ErrorIs(t, err, context.Canceled)
+
+		texts := make([]string, 2000)
+		for i := range texts {
+			texts[i] = strings.Repeat("hello world ", 20)
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = e.EmbedTextsContext(ctx, texts)
+		skipIfNotSupported(t, err)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		res, err := e.EmbedTextsContext(context.Background(), []string{"hello"})
+		require.NoError(t, err, "the embedder must be usable after a cancelled call")
+		require.Len(t, res[0], 384)
+	})
+
+	t.Run("Test EmbedTextsInto", func(t *testing.T) {
+		e, closeFunc, err := NewLlamaEmbedder(defaultModelFile, WithSharedLibraryPath(sharedLibPath))
+		require.NoError(t, err, "Failed to create LlamaEmbedder")
+		t.Cleanup(closeFunc)
+
+		expected, err := e.EmbedTexts([]string{"hello", "world"})
+		require.NoError(t, err, "Failed to embed texts")
+
+		var m Matrix
+		err = e.EmbedTextsInto(&m, []string{"hello", "world"})
+		skipIfNotSupported(t, err)
+		require.NoError(t, err)
+		require.Equal(t, 2, m.Rows)
+		require.Equal(t, 384, m.Cols)
+		require.InDeltaSlice(t, expected[1], m.Row(1), 1e-6)
+
+		data := &m.Data[0]
+		require.NoError(t, e.EmbedTextsInto(&m, []string{"hello"}))
+		require.Equal(t, 1, m.Rows)
+		require.Same(t, data, &m.Data[0], "the matrix must be reused")
+
+		require.ErrorIs(t, e.EmbedTextsInto(&m, nil), ErrEmptyInput)
+	})
+
+	t.Run("Test Rerank requires rank pooling", func(t *testing.T) {
+		e, closeFunc, err := NewLlamaEmbedder(defaultModelFile, WithSharedLibraryPath(sharedLibPath))
+		require.NoError(t, err, "Failed to create LlamaEmbedder")
+		t.Cleanup(closeFunc)
+
+		_, err = e.Rerank("what is a panda?", []string{"The giant panda is a bear species endemic to China."})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "PoolingRank")
+	})
+
+	t.Run("Test context options", func(t *testing.T) {
+		e, closeFunc, err := NewLlamaEmbedder(defaultModelFile, WithSharedLibraryPath(sharedLibPath),
+			WithContextSize(256), WithBatchSize(512), WithThreads(2), WithMmap(false))
+		skipIfNotSupported(t, err)
+		require.NoError(t, err, "Failed to create LlamaEmbedder")
+		t.Cleanup(closeFunc)
+
+		res, err := e.EmbedTexts([]string{"hello"})
+		require.NoError(t, err, "Failed to embed texts")
+		require.Len(t, res[0], 384)
+
+		_, _, err = NewLlamaEmbedder(defaultModelFile, WithSharedLibraryPath(sharedLibPath), WithThreads(0))
+		require.Error(t, err)
 	})
 
 	t.Run("Test GetMetadata", func(t *testing.T) {
@@ -71,6 +199,7 @@ func TestLlamaEmbedder(t *testing.T) {
 	})
 
 	t.Run("Test With Download Shared libs", func(t *testing.T) {
+		skipUnpublishedRelease(t, LatestSharedLibVersion)
 		e, closeFunc, err := NewLlamaEmbedder(defaultModelFile, WithSharedLibraryVersion(LatestSharedLibVersion))
 		require.NoError(t, err, "Failed to create LlamaEmbedder")
 		t.Cleanup(func() {
@@ -84,6 +213,18 @@ func TestLlamaEmbedder(t *testing.T) {
 		}
 	})
 
+	t.Run("Test a different shared library is not loaded while one is in use", func(t *testing.T) {
+		skipUnpublishedRelease(t, LatestSharedLibVersion)
+		_, closeFunc, err := NewLlamaEmbedder(defaultModelFile, WithSharedLibraryPath(sharedLibPath))
+		require.NoError(t, err, "Failed to create LlamaEmbedder")
+		t.Cleanup(func() {
+			closeFunc()
+		})
+		_, _, err = NewLlamaEmbedder(defaultModelFile, WithSharedLibraryVersion(LatestSharedLibVersion))
+		require.ErrorIs(t, err, ErrLibraryLoad)
+		require.ErrorContains(t, err, "already loaded")
+	})
+
 	t.Run("Test with HF Model and target cache dir", func(t *testing.T) {
 		hfRepo := "ChristianAzinn/snowflake-arctic-embed-s-gguf"
 		hfFile := "snowflake-arctic-embed-s-f16.GGUF"
@@ -0,0 +1,60 @@
+package llama_embedder
+
+import "fmt"
+
+// Matrix is a row-major matrix of embeddings backed by a single contiguous slice.
+// A Matrix can be reused across EmbedTextsInto calls; Data is only reallocated when it is too small.
+type Matrix struct {
+	Data []float32
+	Rows int
+	Cols int
+}
+
+// NewMatrix returns a matrix with room for rows embeddings of cols values
+func NewMatrix(rows, cols int) *Matrix {
+	return &Matrix{Data: make([]float32, rows*cols), Rows: rows, Cols: cols}
+}
+
+// Row returns the i-th embedding. The returned slice shares memory with the matrix.
+func (m *Matrix) Row(i int) []float32 {
+	return m.Data[i*m.Cols : (i+1)*m.Cols : (i+1)*m.Cols]
+}
+
+// Slices returns the rows of the matrix as slices sharing memory with the matrix
+func (m *Matrix) Slices() [][]float32 {
+	rows := make([][]float32, m.Rows)
+	for i := range rows {
+		rows[i] = m.Row(i)
+	}
+	return rows
+}
+
+// grow makes sure Data can hold n values, keeping the backing array when it is large enough
+func (m *Matrix) grow(n int) {
+	if cap(m.Data) < n {
+		m.Data = make([]float32, n)
+	}
+	m.Data = m.Data[:cap(m.Data)]
+}
+
+// resize sets the shape of the matrix and trims Data to rows*cols values
+func (m *Matrix) resize(rows, cols int) {
+	m.Rows = rows
+	m.Cols = cols
+	m.Data = m.Data[:rows*cols]
+}
+
+// truncate keeps the first dimensions values of every row in place and normalizes the rows with norm
+func (m *Matrix) truncate(dimensions int, norm NormalizationType) error {
+	if dimensions > m.Cols {
+		return fmt.Errorf("dimensions %d exceed the embedding length %d", dimensions, m.Cols)
+	}
+	for i := 0; i < m.Rows; i++ {
+		// rows only move towards the start of the buffer, so copying in order never overwrites unread values
+		row := m.Data[i*dimensions : (i+1)*dimensions]
+		copy(row, m.Data[i*m.Cols:i*m.Cols+dimensions])
+		normalize(row, norm)
+	}
+	m.resize(m.Rows, dimensions)
+	return nil
+}
@@ -0,0 +1,38 @@
+package llama_embedder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatrix(t *testing.T) {
+	t.Run("Row", func(t *testing.T) {
+		m := &Matrix{Data: []float32{1, 2, 3, 4, 5, 6}, Rows: 2, Cols: 3}
+		require.Equal(t, []float32{4, 5, 6}, m.Row(1))
+		require.Equal(t, 3, cap(m.Row(0)), "appending to a row must not overwrite the next one")
+
+		rows := m.Slices()
+		require.Len(t, rows, 2)
+		rows[0][0] = 10
+		require.Equal(t, float32(10), m.Data[0], "rows must share memory with the matrix")
+	})
+	t.Run("Reuse", func(t *testing.T) {
+		m := NewMatrix(4, 2)
+		data := &m.Data[0]
+		m.grow(4)
+		m.resize(2, 2)
+		require.Len(t, m.Data, 4)
+		m.grow(8)
+		require.Same(t, data, &m.Data[0], "a large enough buffer must be reused")
+		m.grow(10)
+		require.Len(t, m.Data, 10)
+	})
+	t.Run("Truncate", func(t *testing.T) {
+		m := &Matrix{Data: []float32{3, 4, 1, 0, 5, 2}, Rows: 2, Cols: 3}
+		require.NoError(t, m.truncate(2, NormalizationL2))
+		require.Equal(t, 2, m.Cols)
+		require.InDeltaSlice(t, []float32{0.6, 0.8, 0, 1}, m.Data, 1e-6)
+		require.Error(t, m.truncate(3, NormalizationNone))
+	})
+}
@@ -0,0 +1,234 @@
+// Package modelsource fetches GGUF models from where they are published: Hugging Face, plain HTTP(S) URLs, local or
+// network file systems and S3-compatible object stores. Remote models are downloaded once into the model cache.
+package modelsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/cachedir"
+)
+
+// ErrNotFound is returned when the source does not have the model
+var ErrNotFound = errors.New("model not found")
+
+// Source is a location a model can be fetched from
+type Source interface {
+	// FileName is the name of the model file in the cache
+	FileName() string
+	// Fetch makes the model available locally and returns its path. Remote sources download it into dir unless it is
+	// already there, local sources return their own path.
+	Fetch(ctx context.Context, dir string) (string, error)
+	// String returns the URI of the source
+	String() string
+}
+
+// IsURI reports whether s is a source URI rather than a local path, i.e. it has a scheme such as hf:// or s3://, or is
+// an Ollama model name such as ollama:nomic-embed-text
+func IsURI(s string) bool {
+	if strings.HasPrefix(s, ollamaScheme) {
+		return true
+	}
+	scheme, _, ok := strings.Cut(s, "://")
+	return ok && scheme != "" && !strings.ContainsAny(scheme, `/\.`)
+}
+
+// Parse returns the source of a URI:
+//
+//	hf://<owner>/<repo>/<file>.gguf    Hugging Face, authenticated with HF_TOKEN if set
+//	https://host/path/model.gguf       plain HTTP(S)
+//	file:///mnt/models/model.gguf      a local or network file system, used in place
+//	s3://<bucket>/<key>.gguf           an S3-compatible object store configured from the environment, see S3ConfigFromEnv
+//	ollama:<model>[:<tag>]             a model pulled with Ollama, used in place from the Ollama store, see OllamaRoot
+func Parse(uri string) (Source, error) {
+	if strings.HasPrefix(uri, ollamaScheme) {
+		return Ollama("", uri)
+	}
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid model source %q, expected a URI such as hf://owner/repo/model.gguf", uri)
+	}
+	switch strings.ToLower(scheme) {
+	case "hf":
+		segments := strings.Split(rest, "/")
+		if len(segments) < 3 {
+			return nil, fmt.Errorf("invalid Hugging Face source %q, expected hf://owner/repo/file.gguf", uri)
+		}
+		return HuggingFace(segments[0]+"/"+segments[1], strings.Join(segments[2:], "/"), os.Getenv("HF_TOKEN"))
+	case "http", "https":
+		return HTTP(uri)
+	case "file":
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		p := u.Path
+		// file:///C:/models/model.gguf on Windows
+		if runtime.GOOS == "windows" && len(p) > 2 && p[0] == '/' && p[2] == ':' {
+			p = p[1:]
+		}
+		return File(filepath.FromSlash(p))
+	case "s3":
+		bucket, key, _ := strings.Cut(rest, "/")
+		return S3(S3ConfigFromEnv(), bucket, key)
+	default:
+		return nil, fmt.Errorf("unsupported model source scheme %q", scheme)
+	}
+}
+
+// validFileName checks the name a model is cached under
+func validFileName(name string) error {
+	if name == "" || name == "." || name == "/" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid model file name %q", name)
+	}
+	return nil
+}
+
+type httpSource struct {
+	url      string
+	fileName string
+	// prepare adds the authentication of the request
+	prepare func(*http.Request) error
+	client  *http.Client
+}
+
+// HuggingFace returns the source of a file of a Hugging Face repository. token may be empty for public repositories.
+func HuggingFace(repo, file, token string) (Source, error) {
+	if repo == "" || file == "" {
+		return nil, fmt.Errorf("hfRepo and hfFile are required")
+	}
+	if !strings.HasSuffix(strings.ToLower(file), ".gguf") {
+		return nil, fmt.Errorf("model file must be a .gguf file")
+	}
+	if strings.Contains(repo, "..") || strings.Contains(file, "..") {
+		return nil, fmt.Errorf("invalid Hugging Face source %s/%s", repo, file)
+	}
+	s := &httpSource{
+		url:      fmt.Sprintf("https://huggingface.co/%s/resolve/main/%s", repo, file),
+		fileName: path.Base(file),
+		prepare: func(req *http.Request) error {
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			return nil
+		},
+		client: http.DefaultClient,
+	}
+	return s, validFileName(s.fileName)
+}
+
+// HTTP returns the source of a model served at a plain HTTP(S) URL. The file name is the last segment of the path.
+func HTTP(rawURL string) (Source, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid HTTP source %q", rawURL)
+	}
+	s := &httpSource{url: rawURL, fileName: path.Base(u.Path), client: http.DefaultClient}
+	return s, validFileName(s.fileName)
+}
+
+func (s *httpSource) FileName() string {
+	return s.fileName
+}
+
+func (s *httpSource) String() string {
+	return s.url
+}
+
+func (s *httpSource) Fetch(ctx context.Context, dir string) (string, error) {
+	target := filepath.Join(dir, s.fileName)
+	return target, download(target, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if s.prepare != nil {
+			if err := s.prepare(req); err != nil {
+				return nil, err
+			}
+		}
+		return s.client.Do(req)
+	})
+}
+
+// download writes the response body to target unless it exists. Concurrent downloads of the same target, also from
+// other processes, wait for the first one and reuse its file.
+func download(target string, get func() (*http.Response, error)) error {
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	lock, err := cachedir.Lock(target)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	resp, err := get()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrNotFound, resp.Request.URL.Redacted())
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP error: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return cachedir.WriteFileAtomic(target, resp.Body)
+}
+
+type fileSource struct {
+	path string
+}
+
+// File returns the source of a model on a local or network file system. The model is used in place, not copied.
+func File(path string) (Source, error) {
+	if path == "" {
+		return nil, fmt.Errorf("model path is empty")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSource{path: abs}, nil
+}
+
+func (s *fileSource) FileName() string {
+	return filepath.Base(s.path)
+}
+
+func (s *fileSource) String() string {
+	return "file://" + filepath.ToSlash(s.path)
+}
+
+func (s *fileSource) Fetch(_ context.Context, _ string) (string, error) {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, s.path)
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("model path is a directory: %s", s.path)
+	}
+	return s.path, nil
+}
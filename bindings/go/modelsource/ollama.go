@@ -0,0 +1,157 @@
+package modelsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ollamaScheme prefixes the names of models pulled with Ollama, e.g. ollama:nomic-embed-text
+const ollamaScheme = "ollama:"
+
+// ollamaModelMediaType is the media type of the manifest layer holding the GGUF weights
+const ollamaModelMediaType = "application/vnd.ollama.image.model"
+
+const (
+	ollamaDefaultRegistry  = "registry.ollama.ai"
+	ollamaDefaultNamespace = "library"
+	ollamaDefaultTag       = "latest"
+)
+
+// OllamaRoot returns the Ollama model store: OLLAMA_MODELS if set, otherwise ~/.ollama/models, falling back to the
+// store of the Linux system service if only that one exists
+func OllamaRoot() string {
+	if dir := os.Getenv("OLLAMA_MODELS"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.Getenv("HOME")
+	}
+	root := filepath.Join(home, ".ollama", "models")
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		if _, err := os.Stat("/usr/share/ollama/.ollama/models"); err == nil {
+			return "/usr/share/ollama/.ollama/models"
+		}
+	}
+	return root
+}
+
+type ollamaSource struct {
+	root                            string
+	registry, namespace, model, tag string
+}
+
+// Ollama returns the source of a model pulled with Ollama, such as nomic-embed-text, mxbai-embed-large:v1 or
+// registry.example.com/team/model:tag. The GGUF blob is used in place, nothing is downloaded. root is the Ollama model
+// store, OllamaRoot if empty.
+func Ollama(root, name string) (Source, error) {
+	if root == "" {
+		root = OllamaRoot()
+	}
+	s := &ollamaSource{root: root, registry: ollamaDefaultRegistry, namespace: ollamaDefaultNamespace, tag: ollamaDefaultTag}
+	name = strings.TrimPrefix(strings.TrimPrefix(name, ollamaScheme), "//")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, s.tag = name[:i], name[i+1:]
+	}
+	parts := strings.Split(name, "/")
+	switch len(parts) {
+	case 1:
+		s.model = parts[0]
+	case 2:
+		s.namespace, s.model = parts[0], parts[1]
+	case 3:
+		s.registry, s.namespace, s.model = parts[0], parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("invalid Ollama model name %q", name)
+	}
+	for _, part := range []string{s.registry, s.namespace, s.model, s.tag} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\`) {
+			return nil, fmt.Errorf("invalid Ollama model name %q", name)
+		}
+	}
+	return s, nil
+}
+
+// FileName names the model after the Ollama model and tag, e.g. nomic-embed-text.gguf for the latest tag and
+// mxbai-embed-large-v1.gguf otherwise
+func (s *ollamaSource) FileName() string {
+	if s.tag == ollamaDefaultTag {
+		return s.model + ".gguf"
+	}
+	return s.model + "-" + s.tag + ".gguf"
+}
+
+func (s *ollamaSource) String() string {
+	name := s.model + ":" + s.tag
+	if s.registry != ollamaDefaultRegistry {
+		name = s.registry + "/" + s.namespace + "/" + name
+	} else if s.namespace != ollamaDefaultNamespace {
+		name = s.namespace + "/" + name
+	}
+	return ollamaScheme + name
+}
+
+type ollamaManifest struct {
+	Layers []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"layers"`
+}
+
+// Fetch resolves the manifest of the model to the path of its GGUF blob
+func (s *ollamaSource) Fetch(_ context.Context, _ string) (string, error) {
+	manifestPath := filepath.Join(s.root, "manifests", s.registry, s.namespace, s.model, s.tag)
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s is not in the Ollama store %s, pull it with ollama pull", ErrNotFound, s, s.root)
+	}
+	if err != nil {
+		return "", err
+	}
+	var manifest ollamaManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", fmt.Errorf("invalid Ollama manifest %s: %w", manifestPath, err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != ollamaModelMediaType {
+			continue
+		}
+		algorithm, digest, ok := strings.Cut(layer.Digest, ":")
+		if !ok || algorithm == "" || digest == "" || strings.ContainsAny(layer.Digest, `/\`) {
+			return "", fmt.Errorf("invalid digest %q in Ollama manifest %s", layer.Digest, manifestPath)
+		}
+		// current Ollama versions name blobs sha256-<hex>, older ones sha256:<hex>
+		for _, name := range []string{algorithm + "-" + digest, algorithm + ":" + digest} {
+			path := filepath.Join(s.root, "blobs", name)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			if err := checkGGUF(path); err != nil {
+				return "", err
+			}
+			return path, nil
+		}
+		return "", fmt.Errorf("%w: blob %s of %s is missing from the Ollama store", ErrNotFound, layer.Digest, s)
+	}
+	return "", fmt.Errorf("%s has no model layer in its Ollama manifest", s)
+}
+
+// checkGGUF checks the magic number, Ollama stores other model formats in blobs too
+func checkGGUF(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil || !bytes.Equal(magic, []byte("GGUF")) {
+		return fmt.Errorf("%s is not a GGUF model", path)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package modelsource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeOllamaModel adds a model to a fake Ollama store the way ollama pull lays it out
+func writeOllamaModel(t *testing.T, root, manifestPath, blob, content string) {
+	manifest := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json",` +
+		`"config":{"mediaType":"application/vnd.docker.container.image.v1+json","digest":"sha256:cfg"},` +
+		`"layers":[{"mediaType":"application/vnd.ollama.image.model","digest":"sha256:` + blob + `"},` +
+		`{"mediaType":"application/vnd.ollama.image.license","digest":"sha256:license"}]}`
+	path := filepath.Join(root, "manifests", filepath.FromSlash(manifestPath))
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(manifest), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "blobs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "blobs", "sha256-"+blob), []byte(content), 0644))
+}
+
+func TestOllama(t *testing.T) {
+	root := t.TempDir()
+	writeOllamaModel(t, root, "registry.ollama.ai/library/nomic-embed-text/latest", "aaa", "GGUF...")
+	writeOllamaModel(t, root, "registry.ollama.ai/library/mxbai-embed-large/v1", "bbb", "GGUF...")
+	writeOllamaModel(t, root, "registry.ollama.ai/team/safetensors/latest", "ccc", "not gguf")
+	t.Setenv("OLLAMA_MODELS", root)
+
+	src, err := Parse("ollama:nomic-embed-text")
+	require.NoError(t, err)
+	require.Equal(t, "nomic-embed-text.gguf", src.FileName())
+	require.Equal(t, "ollama:nomic-embed-text:latest", src.String())
+	path, err := src.Fetch(context.Background(), t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "blobs", "sha256-aaa"), path, "the blob is used in place")
+
+	src, err = Ollama(root, "mxbai-embed-large:v1")
+	require.NoError(t, err)
+	require.Equal(t, "mxbai-embed-large-v1.gguf", src.FileName())
+	_, err = src.Fetch(context.Background(), "")
+	require.NoError(t, err)
+
+	src, err = Parse("ollama:team/safetensors")
+	require.NoError(t, err)
+	_, err = src.Fetch(context.Background(), "")
+	require.ErrorContains(t, err, "not a GGUF model")
+
+	src, err = Parse("ollama:all-minilm")
+	require.NoError(t, err)
+	_, err = src.Fetch(context.Background(), "")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	src, err = Ollama(root, "localhost:5000/team/model:v2")
+	require.NoError(t, err)
+	require.Equal(t, "ollama:localhost:5000/team/model:v2", src.String())
+
+	for _, name := range []string{"ollama:", "ollama:a/b/c/d", "ollama:../model", "ollama:model:"} {
+		_, err := Parse(name)
+		require.Error(t, err, name)
+	}
+	require.True(t, IsURI("ollama:nomic-embed-text"))
+}
@@ -0,0 +1,51 @@
+package llama_embedder
+
+import (
+	"fmt"
+	"math"
+)
+
+// normalize scales v in place the same way llama.cpp's llama_embd_normalize does
+func normalize(v []float32, norm NormalizationType) {
+	var sum float64
+	switch norm {
+	case NormalizationNone:
+		return
+	case NormalizationMaxAbsInt16:
+		for _, x := range v {
+			sum = math.Max(sum, math.Abs(float64(x)))
+		}
+		sum /= 32760.0 // int16 range
+	case NormalizationL2:
+		for _, x := range v {
+			sum += float64(x) * float64(x)
+		}
+		sum = math.Sqrt(sum)
+	default: // p-norm, NormalizationTaxicab is p=1
+		p := float64(norm)
+		for _, x := range v {
+			sum += math.Pow(math.Abs(float64(x)), p)
+		}
+		sum = math.Pow(sum, 1.0/p)
+	}
+	var scale float32
+	if sum > 0 {
+		scale = float32(1.0 / sum)
+	}
+	for i := range v {
+		v[i] *= scale
+	}
+}
+
+// truncateEmbeddings keeps the first dimensions values of each raw (unnormalized) embedding and then applies norm,
+// so that Matryoshka embeddings shortened this way are still correctly normalized
+func truncateEmbeddings(embeddings [][]float32, dimensions int, norm NormalizationType) ([][]float32, error) {
+	for i, embedding := range embeddings {
+		if dimensions > len(embedding) {
+			return nil, fmt.Errorf("dimensions %d exceed the embedding length %d", dimensions, len(embedding))
+		}
+		embeddings[i] = embedding[:dimensions:dimensions]
+		normalize(embeddings[i], norm)
+	}
+	return embeddings, nil
+}
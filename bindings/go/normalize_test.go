@@ -0,0 +1,54 @@
+package llama_embedder
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Run("L2", func(t *testing.T) {
+		v := []float32{3, 4}
+		normalize(v, NormalizationL2)
+		require.InDeltaSlice(t, []float32{0.6, 0.8}, v, 1e-6)
+	})
+	t.Run("Taxicab", func(t *testing.T) {
+		v := []float32{1, -3}
+		normalize(v, NormalizationTaxicab)
+		require.InDeltaSlice(t, []float32{0.25, -0.75}, v, 1e-6)
+	})
+	t.Run("MaxAbsInt16", func(t *testing.T) {
+		v := []float32{0.5, -1}
+		normalize(v, NormalizationMaxAbsInt16)
+		require.InDeltaSlice(t, []float32{16380, -32760}, v, 1e-2)
+	})
+	t.Run("None", func(t *testing.T) {
+		v := []float32{3, 4}
+		normalize(v, NormalizationNone)
+		require.Equal(t, []float32{3, 4}, v)
+	})
+	t.Run("Zero vector", func(t *testing.T) {
+		v := []float32{0, 0}
+		normalize(v, NormalizationL2)
+		require.Equal(t, []float32{0, 0}, v)
+	})
+}
+
+func TestTruncateEmbeddings(t *testing.T) {
+	embeddings, err := truncateEmbeddings([][]float32{{3, 4, 12}, {0, 2, 1}}, 2, NormalizationL2)
+	require.NoError(t, err)
+	require.Len(t, embeddings, 2)
+	for _, e := range embeddings {
+		require.Len(t, e, 2)
+		var norm float64
+		for _, x := range e {
+			norm += float64(x) * float64(x)
+		}
+		require.InDelta(t, 1.0, math.Sqrt(norm), 1e-6)
+	}
+	require.InDeltaSlice(t, []float32{0.6, 0.8}, embeddings[0], 1e-6)
+
+	_, err = truncateEmbeddings([][]float32{{1, 2}}, 3, NormalizationL2)
+	require.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package llama_embedder
+
+/*
+#include <stdlib.h>
+#include "wrapper.h"
+*/
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// ErrPoolClosed is returned by the methods of an EmbedderPool after Close
+var ErrPoolClosed = errors.New("embedder pool is closed")
+
+// EmbedderPool embeds texts with several llama.cpp contexts over a single loaded model, so concurrent calls run in
+// parallel instead of one at a time. Each call takes a free context, waiting for one if all are busy.
+type EmbedderPool struct {
+	config   *LlamaEmbedder
+	contexts []*C.llama_embedder
+	free     chan *C.llama_embedder
+	closed   bool
+	mu       sync.RWMutex
+}
+
+// NewEmbedderPool loads the model once and creates size contexts over it. It accepts the same options as
+// NewLlamaEmbedder; the context parameters, such as WithThreads, apply to every context. The returned function closes
+// the pool. Pools need a shared library built from this version or later.
+func NewEmbedderPool(modelPath string, size int, opts ...Option) (*EmbedderPool, func(), error) {
+	if size <= 0 {
+		return nil, nil, fmt.Errorf("%w: pool size must be positive", ErrInvalidArgument)
+	}
+	config, err := prepareEmbedder(modelPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &EmbedderPool{
+		config: config,
+		free:   make(chan *C.llama_embedder, size),
+	}
+	closeFunc := func() {
+		p.Close()
+	}
+	cModelPath := C.CString(config.modelPath)
+	defer C.free(unsafe.Pointer(cModelPath))
+	params := config.params()
+	var cErr C.EmbedderError
+	base := C.llama_embedder_new_context(cModelPath, &params, &cErr)
+	if base == nil {
+		C.free_llama_embedder()
+		return nil, nil, fmt.Errorf("failed to initialize embedder pool: %w", toError(&cErr))
+	}
+	p.contexts = append(p.contexts, base)
+	for len(p.contexts) < size {
+		ctx := C.llama_embedder_clone_context(base, &cErr)
+		if ctx == nil {
+			defer closeFunc()
+			return nil, nil, fmt.Errorf("failed to create embedder context %d: %w", len(p.contexts), toError(&cErr))
+		}
+		p.contexts = append(p.contexts, ctx)
+	}
+	for _, ctx := range p.contexts {
+		p.free <- ctx
+	}
+	if n, ok := embeddingLength(p.GetMetadata()); ok && config.dimensions > n {
+		defer closeFunc()
+		return nil, nil, fmt.Errorf("dimensions %d exceed the embedding length %d of the model", config.dimensions, n)
+	}
+	return p, closeFunc, nil
+}
+
+// Size returns the number of contexts of the pool, the maximum number of calls computed in parallel
+func (p *EmbedderPool) Size() int {
+	return len(p.contexts)
+}
+
+// EmbedTexts embeds the given texts using the model with the default options of the pool
+func (p *EmbedderPool) EmbedTexts(texts []string) ([][]float32, error) {
+	return p.EmbedTextsContext(context.Background(), texts)
+}
+
+// EmbedTextsWithOptions embeds the given texts, the options override the defaults of the pool for this call only
+func (p *EmbedderPool) EmbedTextsWithOptions(texts []string, opts ...EmbedOption) ([][]float32, error) {
+	return p.EmbedTextsContext(context.Background(), texts, opts...)
+}
+
+// EmbedTextsContext embeds the given texts and stops as soon as ctx is done, also while waiting for a free context,
+// returning ctx.Err()
+func (p *EmbedderPool) EmbedTextsContext(ctx context.Context, texts []string, opts ...EmbedOption) ([][]float32, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	options, err := p.config.embedOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	var handle *C.llama_embedder
+	select {
+	case handle = <-p.free:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		p.free <- handle
+	}()
+	return embedTexts(ctx, handle, texts, options)
+}
+
+// GetMetadata returns the metadata of the model
+func (p *EmbedderPool) GetMetadata() map[string]string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return map[string]string{}
+	}
+	var size C.size_t
+	cMetadataArray := C.llama_embedder_context_get_metadata(p.contexts[0], &size)
+	return toMetadata(cMetadataArray, size)
+}
+
+// Close waits for the running calls, frees the contexts and the model, and releases the shared library
+func (p *EmbedderPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for _, ctx := range p.contexts {
+		C.llama_embedder_free_context(ctx)
+	}
+	C.free_llama_embedder()
+}
@@ -0,0 +1,73 @@
+package llama_embedder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbedderPoolSize(t *testing.T) {
+	_, _, err := NewEmbedderPool(defaultModelFile, 0)
+	require.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestEmbedderPool(t *testing.T) {
+	sharedLibPath := os.Getenv("SHARED_LIB_PATH")
+	if sharedLibPath == "" {
+		sharedLibPath = "../../build/"
+	}
+	downloadTestModel(t)
+	t.Cleanup(func() {
+		err := os.Remove(defaultModelFile)
+		if err != nil {
+			fmt.Printf("Error removing file: %v", err)
+		}
+	})
+
+	p, closeFunc, err := NewEmbedderPool(defaultModelFile, 4, WithSharedLibraryPath(sharedLibPath), WithThreads(1))
+	skipIfNotSupported(t, err)
+	require.NoError(t, err, "Failed to create EmbedderPool")
+	require.Equal(t, 4, p.Size())
+	require.Equal(t, "bert", p.GetMetadata()["general.architecture"])
+
+	expected, err := p.EmbedTexts([]string{"hello"})
+	require.NoError(t, err, "Failed to embed texts")
+	require.Len(t, expected[0], 384)
+
+	t.Run("Concurrent calls", func(t *testing.T) {
+		var wg sync.WaitGroup
+		results := make([][][]float32, 16)
+		errs := make([]error, len(results))
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], errs[i] = p.EmbedTexts([]string{"hello"})
+			}(i)
+		}
+		wg.Wait()
+		for i := range results {
+			require.NoError(t, errs[i])
+			require.InDeltaSlice(t, expected[0], results[i][0], 1e-5)
+		}
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		res, err := p.EmbedTextsWithOptions([]string{"hello"}, WithEmbedDimensions(128))
+		require.NoError(t, err, "Failed to embed texts")
+		require.Len(t, res[0], 128)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = p.EmbedTextsContext(ctx, []string{"hello"})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	closeFunc()
+	_, err = p.EmbedTexts([]string{"hello"})
+	require.ErrorIs(t, err, ErrPoolClosed)
+}
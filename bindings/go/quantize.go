@@ -0,0 +1,75 @@
+package llama_embedder
+
+import (
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/quantize"
+)
+
+// Calibration holds the per-dimension value ranges used to scalar-quantize embeddings of a model to int8, see
+// quantize.Calibration
+type Calibration = quantize.Calibration
+
+// Calibrate computes the per-dimension minimum and maximum of embeddings
+func Calibrate(embeddings [][]float32) (*Calibration, error) {
+	return quantize.Calibrate(embeddings)
+}
+
+// UnitCalibration returns the [-1, 1] range of every dimension of L2 normalized embeddings
+func UnitCalibration(dims int) *Calibration {
+	return quantize.UnitCalibration(dims)
+}
+
+// LoadCalibration reads a calibration saved with Save
+func LoadCalibration(path string) (*Calibration, error) {
+	return quantize.LoadCalibration(path)
+}
+
+// QuantizeInt8 maps each dimension of the embeddings from its calibrated range onto the 256 int8 values
+func QuantizeInt8(embeddings [][]float32, c *Calibration) ([][]int8, error) {
+	return quantize.QuantizeInt8(embeddings, c)
+}
+
+// QuantizeUbinary packs the sign of each dimension into bits, see quantize.QuantizeUbinary
+func QuantizeUbinary(embeddings [][]float32) [][]uint8 {
+	return quantize.QuantizeUbinary(embeddings)
+}
+
+// QuantizeBinary packs the sign of each dimension into bits shifted into the int8 range, see quantize.QuantizeBinary
+func QuantizeBinary(embeddings [][]float32) [][]int8 {
+	return quantize.QuantizeBinary(embeddings)
+}
+
+// HammingDistance returns the number of differing bits between two ubinary embeddings
+func HammingDistance(a, b []uint8) (int, error) {
+	return quantize.HammingDistance(a, b)
+}
+
+// EmbedTextsInt8 embeds texts and scalar-quantizes them to int8 with the calibration of the model.
+// If c is nil the embeddings are quantized from the [-1, 1] range, which suits L2 normalized embeddings.
+func (e *LlamaEmbedder) EmbedTextsInt8(texts []string, c *Calibration) ([][]int8, error) {
+	embeddings, err := e.EmbedTexts(texts)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil && len(embeddings) > 0 {
+		c = UnitCalibration(len(embeddings[0]))
+	}
+	return QuantizeInt8(embeddings, c)
+}
+
+// EmbedTextsBinary embeds texts and returns them as signed packed bits, see QuantizeBinary
+func (e *LlamaEmbedder) EmbedTextsBinary(texts []string) ([][]int8, error) {
+	embeddings, err := e.EmbedTexts(texts)
+	if err != nil {
+		return nil, err
+	}
+	return QuantizeBinary(embeddings), nil
+}
+
+// EmbedTextsUbinary embeds texts and returns them as packed bits for Hamming distance search, see QuantizeUbinary
+func (e *LlamaEmbedder) EmbedTextsUbinary(texts []string) ([][]uint8, error) {
+	embeddings, err := e.EmbedTexts(texts)
+	if err != nil {
+		return nil, err
+	}
+	return QuantizeUbinary(embeddings), nil
+}
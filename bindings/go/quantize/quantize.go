@@ -0,0 +1,173 @@
+// Package quantize converts float embeddings to the compact int8, binary and ubinary encodings. It is shared by the
+// bindings and the server.
+package quantize
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Encoding is the representation of the embeddings in a response
+type Encoding string
+
+const (
+	EncodingFloat   Encoding = "float"
+	EncodingInt8    Encoding = "int8"
+	EncodingBinary  Encoding = "binary"
+	EncodingUbinary Encoding = "ubinary"
+)
+
+// ParseEncoding parses an encoding name, defaulting to EncodingFloat when name is empty
+func ParseEncoding(name string) (Encoding, error) {
+	switch e := Encoding(strings.ToLower(strings.TrimSpace(name))); e {
+	case "":
+		return EncodingFloat, nil
+	case EncodingFloat, EncodingInt8, EncodingBinary, EncodingUbinary:
+		return e, nil
+	default:
+		return "", fmt.Errorf("unknown encoding: %s", name)
+	}
+}
+
+// CalibrationPath returns the path of the int8 calibration file of the model at modelPath
+func CalibrationPath(modelPath string) string {
+	return strings.TrimSuffix(modelPath, filepath.Ext(modelPath)) + ".calibration.json"
+}
+
+// Calibration holds the per-dimension value ranges used to scalar-quantize embeddings of a model to int8.
+// Compute it once per model with Calibrate from a representative sample of embeddings and store it with Save. The
+// server loads it from <model>.calibration.json next to the model, see CalibrationPath.
+type Calibration struct {
+	Min []float32 `json:"min"`
+	Max []float32 `json:"max"`
+}
+
+// Calibrate computes the per-dimension minimum and maximum of embeddings
+func Calibrate(embeddings [][]float32) (*Calibration, error) {
+	if len(embeddings) == 0 {
+		return nil, fmt.Errorf("at least one embedding is required for calibration")
+	}
+	dims := len(embeddings[0])
+	c := &Calibration{Min: make([]float32, dims), Max: make([]float32, dims)}
+	copy(c.Min, embeddings[0])
+	copy(c.Max, embeddings[0])
+	for _, embedding := range embeddings[1:] {
+		if len(embedding) != dims {
+			return nil, fmt.Errorf("embeddings have different lengths: %d and %d", dims, len(embedding))
+		}
+		for j, v := range embedding {
+			c.Min[j] = float32(math.Min(float64(c.Min[j]), float64(v)))
+			c.Max[j] = float32(math.Max(float64(c.Max[j]), float64(v)))
+		}
+	}
+	return c, nil
+}
+
+// UnitCalibration returns the [-1, 1] range of every dimension of L2 normalized embeddings. It is used when no
+// calibration is computed for a model.
+func UnitCalibration(dims int) *Calibration {
+	c := &Calibration{Min: make([]float32, dims), Max: make([]float32, dims)}
+	for i := 0; i < dims; i++ {
+		c.Min[i] = -1
+		c.Max[i] = 1
+	}
+	return c
+}
+
+// LoadCalibration reads a calibration saved with Save
+func LoadCalibration(path string) (*Calibration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Calibration
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid calibration file %s: %v", path, err)
+	}
+	if len(c.Min) != len(c.Max) {
+		return nil, fmt.Errorf("invalid calibration file %s: min and max have different lengths", path)
+	}
+	return &c, nil
+}
+
+// Save writes the calibration to path as JSON
+func (c *Calibration) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// QuantizeInt8 maps each dimension of the embeddings from its calibrated range onto the 256 int8 values.
+// Values outside of the range are clipped.
+func QuantizeInt8(embeddings [][]float32, c *Calibration) ([][]int8, error) {
+	if c == nil {
+		return nil, fmt.Errorf("calibration is required for int8 quantization")
+	}
+	out := make([][]int8, len(embeddings))
+	for i, embedding := range embeddings {
+		if len(embedding) != len(c.Min) {
+			return nil, fmt.Errorf("embedding length %d does not match calibration length %d", len(embedding), len(c.Min))
+		}
+		q := make([]int8, len(embedding))
+		for j, v := range embedding {
+			step := (float64(c.Max[j]) - float64(c.Min[j])) / 255
+			if step <= 0 {
+				q[j] = -128
+				continue
+			}
+			x := math.Round((float64(v)-float64(c.Min[j]))/step) - 128
+			q[j] = int8(math.Max(-128, math.Min(127, x)))
+		}
+		out[i] = q
+	}
+	return out, nil
+}
+
+// QuantizeUbinary packs the sign of each dimension into bits, most significant bit first; positive values are 1.
+// Embeddings whose length is not a multiple of 8 are padded with 0 bits.
+func QuantizeUbinary(embeddings [][]float32) [][]uint8 {
+	out := make([][]uint8, len(embeddings))
+	for i, embedding := range embeddings {
+		packed := make([]uint8, (len(embedding)+7)/8)
+		for j, v := range embedding {
+			if v > 0 {
+				packed[j/8] |= 1 << (7 - uint(j%8))
+			}
+		}
+		out[i] = packed
+	}
+	return out
+}
+
+// QuantizeBinary is QuantizeUbinary with each packed byte shifted by -128 into the int8 range, the signed binary
+// encoding used by vector stores that only accept int8 vectors
+func QuantizeBinary(embeddings [][]float32) [][]int8 {
+	unsigned := QuantizeUbinary(embeddings)
+	out := make([][]int8, len(unsigned))
+	for i, packed := range unsigned {
+		out[i] = make([]int8, len(packed))
+		for j, b := range packed {
+			out[i][j] = int8(int(b) - 128)
+		}
+	}
+	return out
+}
+
+// HammingDistance returns the number of differing bits between two ubinary embeddings
+func HammingDistance(a, b []uint8) (int, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("embeddings have different lengths: %d and %d", len(a), len(b))
+	}
+	var d int
+	for i := range a {
+		d += bits.OnesCount8(a[i] ^ b[i])
+	}
+	return d, nil
+}
@@ -0,0 +1,62 @@
+package quantize
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantizeInt8(t *testing.T) {
+	c, err := Calibrate([][]float32{{-1, 0}, {1, 2}})
+	require.NoError(t, err)
+	require.Equal(t, []float32{-1, 0}, c.Min)
+	require.Equal(t, []float32{1, 2}, c.Max)
+
+	q, err := QuantizeInt8([][]float32{{-1, 2}, {1, 0}, {5, -5}}, c)
+	require.NoError(t, err)
+	require.Equal(t, []int8{-128, 127}, q[0])
+	require.Equal(t, []int8{127, -128}, q[1])
+	require.Equal(t, []int8{127, -128}, q[2], "values outside of the range are clipped")
+
+	_, err = QuantizeInt8([][]float32{{1, 2, 3}}, c)
+	require.Error(t, err)
+}
+
+func TestCalibrationSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calibration.json")
+	c := UnitCalibration(3)
+	require.NoError(t, c.Save(path))
+	loaded, err := LoadCalibration(path)
+	require.NoError(t, err)
+	require.Equal(t, c, loaded)
+}
+
+func TestQuantizeBinary(t *testing.T) {
+	embeddings := [][]float32{{0.1, -0.2, 0.3, 0, 0, 0, 0, 0.5, 0.9}}
+	ubinary := QuantizeUbinary(embeddings)
+	require.Equal(t, [][]uint8{{0b10100001, 0b10000000}}, ubinary)
+	binary := QuantizeBinary(embeddings)
+	require.Equal(t, [][]int8{{int8(0b10100001 - 128), 0}}, binary)
+
+	d, err := HammingDistance(ubinary[0], []uint8{0b10100000, 0})
+	require.NoError(t, err)
+	require.Equal(t, 2, d)
+	_, err = HammingDistance(ubinary[0], []uint8{0})
+	require.Error(t, err)
+}
+
+func TestParseEncoding(t *testing.T) {
+	e, err := ParseEncoding("")
+	require.NoError(t, err)
+	require.Equal(t, EncodingFloat, e)
+	e, err = ParseEncoding("UBINARY")
+	require.NoError(t, err)
+	require.Equal(t, EncodingUbinary, e)
+	_, err = ParseEncoding("int4")
+	require.Error(t, err)
+}
+
+func TestCalibrationPath(t *testing.T) {
+	require.Equal(t, filepath.Join("models", "model.calibration.json"), CalibrationPath(filepath.Join("models", "model.gguf")))
+}
@@ -11,6 +11,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/cachedir"
 )
 
 func expandTilde(path string) (string, error) {
@@ -142,20 +144,15 @@ func getOSSharedLibName() string {
 }
 
 func ensureCacheDir() error {
-	if _, err := os.Stat(defaultCacheDir); os.IsNotExist(err) {
-		err := os.MkdirAll(defaultCacheDir, 0755)
-		if err != nil {
+	m := cachedir.New(defaultCacheDir)
+	if err := m.Ensure(); err != nil {
+		return err
+	}
+	// the model and library directories can be moved independently in tests
+	for _, dir := range []string{defaultModelCacheDir, defaultLibCacheDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("could not create cache directory: %v", err)
 		}
-		err = os.MkdirAll(defaultModelCacheDir, 0755)
-		if err != nil {
-			return fmt.Errorf("could not create model cache directory: %v", err)
-		}
-		err = os.MkdirAll(defaultLibCacheDir, 0755)
-		if err != nil {
-			return fmt.Errorf("could not create library cache directory: %v", err)
-		}
 	}
-
 	return nil
 }
@@ -13,7 +13,6 @@
 #include <thread>
 
 static std::mutex embedder_mutex;
-typedef struct llama_embedder llama_embedder;
 
 #if defined(_WIN32) || defined(_WIN64)
 
@@ -50,12 +49,40 @@ std::string GetLastErrorAsString() {
         typedef FloatMatrix (*embed_c_local_func)(llama_embedder*, const char**, size_t, int32_t);
         typedef int (*get_metadata_c_local_func)(llama_embedder*, MetadataPair**, size_t*);
         typedef void (*free_metadata_c_local_func)(MetadataPair*, size_t);
+        typedef FloatMatrix (*rerank_c_local_func)(llama_embedder*, const char*, const char**, size_t);
+        typedef TokenEmbeddings (*embed_tokens_c_local_func)(llama_embedder*, const char**, size_t, int32_t);
+        typedef void (*free_token_embeddings_local_func)(TokenEmbeddings*);
+        typedef llama_embedder* (*init_embedder_with_params_local_func)(const char*, const EmbedderParams*);
+        typedef FloatMatrix (*embed_with_options_c_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*);
+        typedef llama_embedder* (*init_embedder_e_local_func)(const char*, const EmbedderParams*, EmbedderError*);
+        typedef FloatMatrix (*embed_e_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*, EmbedderError*);
+        typedef TokenEmbeddings (*embed_tokens_e_local_func)(llama_embedder*, const char**, size_t, int32_t, EmbedderError*);
+        typedef FloatMatrix (*rerank_e_local_func)(llama_embedder*, const char*, const char**, size_t, EmbedderError*);
+        typedef int32_t (*embed_into_e_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*, float*, size_t, size_t*, size_t*, EmbedderError*);
+        typedef FloatMatrix (*embed_cancellable_e_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*, const volatile int32_t*, EmbedderError*);
+        typedef llama_embedder* (*clone_embedder_e_local_func)(llama_embedder*, EmbedderError*);
+        typedef const char* (*version_local_func)();
+        typedef int32_t (*abi_version_local_func)();
     #else
         typedef llama_embedder* (__cdecl *init_embedder_local_func)(const char*, uint32_t);
         typedef void (__cdecl *free_embedder_local_func)(llama_embedder*);
         typedef FloatMatrix (__cdecl *embed_c_local_func)(llama_embedder*, const char**, size_t, int32_t);
         typedef int (__cdecl *get_metadata_c_local_func)(llama_embedder*, MetadataPair**, size_t*);
         typedef void (__cdecl *free_metadata_c_local_func)(MetadataPair*, size_t);
+        typedef FloatMatrix (__cdecl *rerank_c_local_func)(llama_embedder*, const char*, const char**, size_t);
+        typedef TokenEmbeddings (__cdecl *embed_tokens_c_local_func)(llama_embedder*, const char**, size_t, int32_t);
+        typedef void (__cdecl *free_token_embeddings_local_func)(TokenEmbeddings*);
+        typedef llama_embedder* (__cdecl *init_embedder_with_params_local_func)(const char*, const EmbedderParams*);
+        typedef FloatMatrix (__cdecl *embed_with_options_c_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*);
+        typedef llama_embedder* (__cdecl *init_embedder_e_local_func)(const char*, const EmbedderParams*, EmbedderError*);
+        typedef FloatMatrix (__cdecl *embed_e_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*, EmbedderError*);
+        typedef TokenEmbeddings (__cdecl *embed_tokens_e_local_func)(llama_embedder*, const char**, size_t, int32_t, EmbedderError*);
+        typedef FloatMatrix (__cdecl *rerank_e_local_func)(llama_embedder*, const char*, const char**, size_t, EmbedderError*);
+        typedef int32_t (__cdecl *embed_into_e_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*, float*, size_t, size_t*, size_t*, EmbedderError*);
+        typedef FloatMatrix (__cdecl *embed_cancellable_e_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*, const volatile int32_t*, EmbedderError*);
+        typedef llama_embedder* (__cdecl *clone_embedder_e_local_func)(llama_embedder*, EmbedderError*);
+        typedef const char* (__cdecl *version_local_func)();
+        typedef int32_t (__cdecl *abi_version_local_func)();
     #endif
 #else
     typedef llama_embedder* (*init_embedder_local_func)(const char*, uint32_t);
@@ -63,32 +90,77 @@ std::string GetLastErrorAsString() {
     typedef FloatMatrix (*embed_c_local_func)(llama_embedder*, const char**, size_t, int32_t);
     typedef int (*get_metadata_c_local_func)(llama_embedder*, MetadataPair**, size_t*);
     typedef void (*free_metadata_c_local_func)(MetadataPair*, size_t);
+    typedef FloatMatrix (*rerank_c_local_func)(llama_embedder*, const char*, const char**, size_t);
+    typedef TokenEmbeddings (*embed_tokens_c_local_func)(llama_embedder*, const char**, size_t, int32_t);
+    typedef void (*free_token_embeddings_local_func)(TokenEmbeddings*);
+    typedef llama_embedder* (*init_embedder_with_params_local_func)(const char*, const EmbedderParams*);
+    typedef FloatMatrix (*embed_with_options_c_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*);
+    typedef llama_embedder* (*init_embedder_e_local_func)(const char*, const EmbedderParams*, EmbedderError*);
+    typedef FloatMatrix (*embed_e_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*, EmbedderError*);
+    typedef TokenEmbeddings (*embed_tokens_e_local_func)(llama_embedder*, const char**, size_t, int32_t, EmbedderError*);
+    typedef FloatMatrix (*rerank_e_local_func)(llama_embedder*, const char*, const char**, size_t, EmbedderError*);
+    typedef int32_t (*embed_into_e_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*, float*, size_t, size_t*, size_t*, EmbedderError*);
+    typedef FloatMatrix (*embed_cancellable_e_local_func)(llama_embedder*, const char**, size_t, const EmbedOptions*, const volatile int32_t*, EmbedderError*);
+    typedef llama_embedder* (*clone_embedder_e_local_func)(llama_embedder*, EmbedderError*);
+    typedef const char* (*version_local_func)();
+    typedef int32_t (*abi_version_local_func)();
 #endif
 
 std::atomic<int> library_ref_count(0);
 lib_handle libh = nullptr;
+// libh_path is the path libh was loaded from, only one shared library can be loaded at a time
+std::string libh_path;
 llama_embedder * embedder = nullptr;
 init_embedder_local_func init_embedder_f = nullptr;
 free_embedder_local_func free_embedder_f = nullptr;
 embed_c_local_func embed_f = nullptr;
 get_metadata_c_local_func get_metadata_f = nullptr;
 free_metadata_c_local_func free_metadata_f = nullptr;
+rerank_c_local_func rerank_f = nullptr; // optional, older shared libraries do not export rerank_c
+embed_tokens_c_local_func embed_tokens_f = nullptr; // optional
+free_token_embeddings_local_func free_token_embeddings_f = nullptr; // optional
+init_embedder_with_params_local_func init_embedder_with_params_f = nullptr; // optional
+embed_with_options_c_local_func embed_with_options_f = nullptr; // optional
+// optional, the *_e functions report typed errors, older shared libraries do not export them
+init_embedder_e_local_func init_embedder_e_f = nullptr;
+embed_e_local_func embed_e_f = nullptr;
+embed_tokens_e_local_func embed_tokens_e_f = nullptr;
+rerank_e_local_func rerank_e_f = nullptr;
+embed_into_e_local_func embed_into_e_f = nullptr;
+embed_cancellable_e_local_func embed_cancellable_e_f = nullptr;
+clone_embedder_e_local_func clone_embedder_e_f = nullptr;
+// optional, libraries that predate versioning do not export them
+version_local_func version_f = nullptr;
+abi_version_local_func abi_version_f = nullptr;
 
-static std::string last_error;
-
-extern "C" {
-const char* get_last_error() {
-    std::lock_guard<std::mutex> lock(embedder_mutex);
-    return last_error.c_str();
+// Reports an error of the given status through err
+static void set_error(EmbedderError *err, int32_t code, const std::string &message) {
+    if (err == nullptr) {
+        return;
+    }
+    err->code = code;
+    snprintf(err->message, sizeof(err->message), "%s", message.c_str());
 }
 
-void set_last_error(const char* error_message) {
-    std::lock_guard<std::mutex> lock(embedder_mutex);
-    last_error = error_message;
+static void clear_error(EmbedderError *err) {
+    set_error(err, LLAMA_EMBEDDER_OK, "");
 }
 
-lib_handle load_library(const char * shared_lib_path){
+extern "C" {
+lib_handle load_library(const char * shared_lib_path, EmbedderError * err){
     std::lock_guard<std::mutex> lock(embedder_mutex);
+    clear_error(err);
+    if (libh != nullptr) {
+        if (libh_path != shared_lib_path) {
+            set_error(err, LLAMA_EMBEDDER_ERROR_LIBRARY_LOAD,
+                      "Shared library " + libh_path + " is already loaded, cannot load " + std::string(shared_lib_path) +
+                      " until every embedder using it is closed.");
+            return nullptr;
+        }
+        // embedders and pools created while the library is loaded share it
+        library_ref_count++;
+        return libh;
+    }
     try {
 #if defined(_WIN32) || defined(_WIN64)
         libh = LoadLibraryA(shared_lib_path);
@@ -121,6 +193,20 @@ lib_handle load_library(const char * shared_lib_path){
             std::string error_message = "Failed to load free_metadata function: " + GetLastErrorAsString();
             throw std::runtime_error(error_message);
         }
+        rerank_f = reinterpret_cast<rerank_c_local_func>(GetProcAddress(libh, "rerank_c"));
+        embed_tokens_f = reinterpret_cast<embed_tokens_c_local_func>(GetProcAddress(libh, "embed_tokens_c"));
+        free_token_embeddings_f = reinterpret_cast<free_token_embeddings_local_func>(GetProcAddress(libh, "free_token_embeddings"));
+        init_embedder_with_params_f = reinterpret_cast<init_embedder_with_params_local_func>(GetProcAddress(libh, "init_embedder_with_params"));
+        embed_with_options_f = reinterpret_cast<embed_with_options_c_local_func>(GetProcAddress(libh, "embed_with_options_c"));
+        init_embedder_e_f = reinterpret_cast<init_embedder_e_local_func>(GetProcAddress(libh, "init_embedder_e"));
+        embed_e_f = reinterpret_cast<embed_e_local_func>(GetProcAddress(libh, "embed_e"));
+        embed_tokens_e_f = reinterpret_cast<embed_tokens_e_local_func>(GetProcAddress(libh, "embed_tokens_e"));
+        rerank_e_f = reinterpret_cast<rerank_e_local_func>(GetProcAddress(libh, "rerank_e"));
+        embed_into_e_f = reinterpret_cast<embed_into_e_local_func>(GetProcAddress(libh, "embed_into_e"));
+        embed_cancellable_e_f = reinterpret_cast<embed_cancellable_e_local_func>(GetProcAddress(libh, "embed_cancellable_e"));
+        clone_embedder_e_f = reinterpret_cast<clone_embedder_e_local_func>(GetProcAddress(libh, "clone_embedder_e"));
+        version_f = reinterpret_cast<version_local_func>(GetProcAddress(libh, "llama_embedder_version"));
+        abi_version_f = reinterpret_cast<abi_version_local_func>(GetProcAddress(libh, "llama_embedder_abi_version"));
 #else
         libh = dlopen(shared_lib_path, RTLD_LAZY);
         if (!libh) {
@@ -152,12 +238,26 @@ lib_handle load_library(const char * shared_lib_path){
             std::string error_message = "Failed to load free_metadata function: " + std::string(dlerror());
             throw std::runtime_error(error_message);
         }
+        rerank_f = reinterpret_cast<rerank_c_local_func>(dlsym(libh, "rerank_c"));
+        embed_tokens_f = reinterpret_cast<embed_tokens_c_local_func>(dlsym(libh, "embed_tokens_c"));
+        free_token_embeddings_f = reinterpret_cast<free_token_embeddings_local_func>(dlsym(libh, "free_token_embeddings"));
+        init_embedder_with_params_f = reinterpret_cast<init_embedder_with_params_local_func>(dlsym(libh, "init_embedder_with_params"));
+        embed_with_options_f = reinterpret_cast<embed_with_options_c_local_func>(dlsym(libh, "embed_with_options_c"));
+        init_embedder_e_f = reinterpret_cast<init_embedder_e_local_func>(dlsym(libh, "init_embedder_e"));
+        embed_e_f = reinterpret_cast<embed_e_local_func>(dlsym(libh, "embed_e"));
+        embed_tokens_e_f = reinterpret_cast<embed_tokens_e_local_func>(dlsym(libh, "embed_tokens_e"));
+        rerank_e_f = reinterpret_cast<rerank_e_local_func>(dlsym(libh, "rerank_e"));
+        embed_into_e_f = reinterpret_cast<embed_into_e_local_func>(dlsym(libh, "embed_into_e"));
+        embed_cancellable_e_f = reinterpret_cast<embed_cancellable_e_local_func>(dlsym(libh, "embed_cancellable_e"));
+        clone_embedder_e_f = reinterpret_cast<clone_embedder_e_local_func>(dlsym(libh, "clone_embedder_e"));
+        version_f = reinterpret_cast<version_local_func>(dlsym(libh, "llama_embedder_version"));
+        abi_version_f = reinterpret_cast<abi_version_local_func>(dlsym(libh, "llama_embedder_abi_version"));
 #endif
         library_ref_count = 1;
+        libh_path = shared_lib_path;
         return libh;
     } catch (const std::exception &e) {
-        std::string error_message = "Failed to load shared library: " + std::string(e.what());
-        set_last_error(error_message.c_str());
+        set_error(err, LLAMA_EMBEDDER_ERROR_LIBRARY_LOAD, e.what());
         if (libh != nullptr) {
 
 #if defined(_WIN32) || defined(_WIN64)
@@ -169,35 +269,63 @@ lib_handle load_library(const char * shared_lib_path){
                 fprintf(stderr, "Failed to close library %s\n", dlerror());
             }
 #endif
+            libh = nullptr;
         }
         return nullptr;
     }
 }
 
-int init_llama_embedder(char * model_path, uint32_t pooling_type ) {
-    std::lock_guard<std::mutex> lock(embedder_mutex);
+// init_locked initializes the embedder, embedder_mutex must be held
+static int init_locked(char * model_path, EmbedderParams * params, EmbedderError * err) {
     if (!libh) {
-        set_last_error("Shared library not loaded, use load_library first.");
+        set_error(err, LLAMA_EMBEDDER_ERROR_LIBRARY_LOAD, "Shared library not loaded, use load_library first.");
         return -1;
     }
+    if (init_embedder_e_f) {
+        embedder = init_embedder_e_f(model_path, params, err);
+        return embedder == nullptr ? -1 : 0;
+    }
     try {
-        embedder = init_embedder_f(model_path, pooling_type);
+        if (init_embedder_with_params_f) {
+            embedder = init_embedder_with_params_f(model_path, params);
+        } else if (params->n_ctx != 0 || params->n_batch != 0 || params->n_ubatch != 0 || params->n_threads != 0 ||
+                   !params->use_mmap || params->use_mlock || params->flash_attn) {
+            // older shared libraries only accept the pooling type
+            set_error(err, LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED,
+                      "context parameters are not supported by the loaded shared library");
+            return -1;
+        } else {
+            embedder = init_embedder_f(model_path, params->pooling_type);
+        }
         if (!embedder) {
             throw std::runtime_error("Embedder not initialized properly.");
         }
     } catch (const std::exception &e) {
-        std::string error_message = "Failed to initialize embedder: " + std::string(e.what());
-        set_last_error(error_message.c_str());
+        set_error(err, LLAMA_EMBEDDER_ERROR_UNKNOWN, "Failed to initialize embedder: " + std::string(e.what()));
         return -1;
     }
     return 0;
 }
 
+int init_llama_embedder(char * model_path, uint32_t pooling_type, EmbedderError * err) {
+    std::lock_guard<std::mutex> lock(embedder_mutex);
+    clear_error(err);
+    EmbedderParams params = {pooling_type, 0, 0, 0, 0, true, false, false};
+    return init_locked(model_path, &params, err);
+}
+
+int init_llama_embedder_with_params(char * model_path, EmbedderParams * params, EmbedderError * err) {
+    std::lock_guard<std::mutex> lock(embedder_mutex);
+    clear_error(err);
+    return init_locked(model_path, params, err);
+}
+
 void free_llama_embedder() {
     std::lock_guard<std::mutex> lock(embedder_mutex);
     if (library_ref_count.fetch_sub(1) == 1) {
         if (embedder != nullptr) {
             free_embedder_f(embedder);
+            embedder = nullptr;
         }
         if (libh != nullptr) {
 #if defined(_WIN32) || defined(_WIN64)
@@ -210,29 +338,189 @@ void free_llama_embedder() {
                 fprintf(stderr, "Failed to close library %s\n", dlerror());
             }
 #endif
+            libh = nullptr;
+            libh_path.clear();
+        }
+    }
+}
+
+const char * llama_embedder_library_version() {
+    std::lock_guard<std::mutex> lock(embedder_mutex);
+    return libh != nullptr && version_f ? version_f() : "";
+}
+
+int32_t llama_embedder_library_abi_version() {
+    std::lock_guard<std::mutex> lock(embedder_mutex);
+    return libh != nullptr && abi_version_f ? abi_version_f() : -1;
+}
+
+llama_embedder * llama_embedder_new_context(char * model_path, EmbedderParams * params, EmbedderError * err) {
+    std::lock_guard<std::mutex> lock(embedder_mutex);
+    clear_error(err);
+    if (!libh) {
+        set_error(err, LLAMA_EMBEDDER_ERROR_LIBRARY_LOAD, "Shared library not loaded, use load_library first.");
+        return nullptr;
+    }
+    if (!init_embedder_e_f || !clone_embedder_e_f) {
+        set_error(err, LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED, "embedder pools are not supported by the loaded shared library");
+        return nullptr;
+    }
+    return init_embedder_e_f(model_path, params, err);
+}
+
+llama_embedder * llama_embedder_clone_context(llama_embedder * base, EmbedderError * err) {
+    std::lock_guard<std::mutex> lock(embedder_mutex);
+    clear_error(err);
+    if (!clone_embedder_e_f) {
+        set_error(err, LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED, "embedder pools are not supported by the loaded shared library");
+        return nullptr;
+    }
+    return clone_embedder_e_f(base, err);
+}
+
+void llama_embedder_free_context(llama_embedder * ctx) {
+    std::lock_guard<std::mutex> lock(embedder_mutex);
+    if (ctx != nullptr && free_embedder_f) {
+        free_embedder_f(ctx);
+    }
+}
+
+FloatMatrix llama_embedder_context_embed(llama_embedder * ctx, const char** texts, size_t text_count, EmbedOptions* options,
+                                         const volatile int32_t* cancel, EmbedderError * err) {
+    // contexts are independent of each other and of the global embedder, the caller uses each from one thread at a time
+    clear_error(err);
+    if (embed_cancellable_e_f) {
+        return embed_cancellable_e_f(ctx, texts, text_count, options, cancel, err);
+    }
+    return embed_e_f(ctx, texts, text_count, options, err);
+}
+
+FloatMatrix llama_embedder_embed(const char** texts, size_t text_count, int32_t norm, EmbedderError * err) {
+    EmbedOptions options = {norm, false};
+    return llama_embedder_embed_with_options(texts, text_count, &options, err);
+}
+
+FloatMatrix llama_embedder_embed_with_options(const char** texts, size_t text_count, EmbedOptions* options, EmbedderError * err) {
+    std::lock_guard<std::mutex> lock(embedder_mutex);
+    clear_error(err);
+    FloatMatrix fm = {nullptr, 0, 0};
+    if (embed_e_f) {
+        return embed_e_f(embedder, texts, text_count, options, err);
+    }
+    try {
+        if (embed_with_options_f) {
+            fm = embed_with_options_f(embedder, texts, text_count, options);
+        } else if (options->truncate) {
+            set_error(err, LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED, "truncation is not supported by the loaded shared library");
+            return fm;
+        } else {
+            fm = embed_f(embedder, texts, text_count, options->embd_norm);
+        }
+        if (fm.data == nullptr) {
+            // older embed_c implementations only print the error to stderr
+            set_error(err, LLAMA_EMBEDDER_ERROR_UNKNOWN, "failed to embed texts");
         }
+    } catch (const std::exception &e) {
+        set_error(err, LLAMA_EMBEDDER_ERROR_UNKNOWN, e.what());
+    }
+    return fm;
+}
+
+FloatMatrix llama_embedder_embed_cancellable(const char** texts, size_t text_count, EmbedOptions* options,
+                                             const volatile int32_t* cancel, EmbedderError * err) {
+    if (embed_cancellable_e_f) {
+        std::lock_guard<std::mutex> lock(embedder_mutex);
+        clear_error(err);
+        return embed_cancellable_e_f(embedder, texts, text_count, options, cancel, err);
     }
+    // older shared libraries cannot be interrupted once the call has started
+    if (cancel != nullptr && *cancel != 0) {
+        set_error(err, LLAMA_EMBEDDER_ERROR_CANCELLED, "embedding cancelled");
+        return {nullptr, 0, 0};
+    }
+    return llama_embedder_embed_with_options(texts, text_count, options, err);
 }
 
-FloatMatrix llama_embedder_embed(const char** texts, size_t text_count, int32_t norm) {
+int llama_embedder_embed_into(const char** texts, size_t text_count, EmbedOptions* options, float* out, size_t out_len,
+                              size_t* rows, size_t* cols, EmbedderError * err) {
+    if (embed_into_e_f) {
+        std::lock_guard<std::mutex> lock(embedder_mutex);
+        clear_error(err);
+        return embed_into_e_f(embedder, texts, text_count, options, out, out_len, rows, cols, err) == LLAMA_EMBEDDER_OK ? 0 : -1;
+    }
+    // older shared libraries return a malloc'd matrix that is copied into out
+    FloatMatrix fm = llama_embedder_embed_with_options(texts, text_count, options, err);
+    if (fm.data == nullptr) {
+        return -1;
+    }
+    *rows = fm.rows;
+    *cols = fm.cols;
+    int result = 0;
+    if (out == nullptr || out_len < fm.rows * fm.cols) {
+        set_error(err, LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "output buffer is too small for the embeddings");
+        result = -1;
+    } else {
+        std::memcpy(out, fm.data, fm.rows * fm.cols * sizeof(float));
+    }
+    free_float_matrixw(&fm);
+    return result;
+}
+
+FloatMatrix llama_embedder_rerank(const char* query, const char** documents, size_t document_count, EmbedderError * err) {
     std::lock_guard<std::mutex> lock(embedder_mutex);
+    clear_error(err);
     FloatMatrix fm = {nullptr, 0, 0};
+    if (rerank_e_f) {
+        return rerank_e_f(embedder, query, documents, document_count, err);
+    }
+    if (!rerank_f) {
+        set_error(err, LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED, "rerank is not supported by the loaded shared library");
+        return fm;
+    }
     try {
-        std::vector<std::vector<float>> output;
-        fm = embed_f(embedder, texts, text_count, norm);
+        fm = rerank_f(embedder, query, documents, document_count);
     } catch (const std::exception &e) {
-        set_last_error(e.what());
+        set_error(err, LLAMA_EMBEDDER_ERROR_UNKNOWN, e.what());
     }
     return fm;
 }
 
+TokenEmbeddings llama_embedder_embed_tokens(const char** texts, size_t text_count, int32_t norm, EmbedderError * err) {
+    std::lock_guard<std::mutex> lock(embedder_mutex);
+    clear_error(err);
+    TokenEmbeddings te = {nullptr, nullptr, nullptr, 0, 0};
+    if (!free_token_embeddings_f || (!embed_tokens_e_f && !embed_tokens_f)) {
+        set_error(err, LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED, "token embeddings are not supported by the loaded shared library");
+        return te;
+    }
+    if (embed_tokens_e_f) {
+        return embed_tokens_e_f(embedder, texts, text_count, norm, err);
+    }
+    try {
+        te = embed_tokens_f(embedder, texts, text_count, norm);
+    } catch (const std::exception &e) {
+        set_error(err, LLAMA_EMBEDDER_ERROR_UNKNOWN, e.what());
+    }
+    return te;
+}
+
+void free_token_embeddingsw(TokenEmbeddings * te) {
+    if (te != nullptr && free_token_embeddings_f) {
+        free_token_embeddings_f(te);
+    }
+}
+
 char** llama_embedder_get_metadata(size_t* size) {
+    return llama_embedder_context_get_metadata(embedder, size);
+}
+
+char** llama_embedder_context_get_metadata(llama_embedder * ctx, size_t* size) {
     std::lock_guard<std::mutex> lock(embedder_mutex);
     MetadataPair* metadata_array = nullptr;
     char** metadata = nullptr;
     *size = 0;
 
-    if (get_metadata_f(embedder, &metadata_array, size) != 0 || metadata_array == nullptr) {
+    if (get_metadata_f(ctx, &metadata_array, size) != 0 || metadata_array == nullptr) {
         fprintf(stderr, "Failed to get metadata\n");
         return nullptr;
     }
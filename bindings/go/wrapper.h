@@ -22,26 +22,92 @@
 extern "C" {
 #endif
 
+typedef struct llama_embedder llama_embedder;
+
 typedef struct {
     float *data;
     size_t rows;
     size_t cols;
 } FloatMatrix;
 
+// EmbedderParams mirrors llama_embedder_params of the shared library
+typedef struct {
+    uint32_t pooling_type;
+    uint32_t n_ctx;
+    uint32_t n_batch;
+    uint32_t n_ubatch;
+    int32_t n_threads;
+    bool use_mmap;
+    bool use_mlock;
+    bool flash_attn;
+} EmbedderParams;
+
+// EmbedOptions mirrors llama_embed_options of the shared library
+typedef struct {
+    int32_t embd_norm;
+    bool truncate;
+} EmbedOptions;
+
+typedef struct {
+    float *data;
+    int32_t *tokens;
+    size_t *lengths;
+    size_t rows;
+    size_t cols;
+} TokenEmbeddings;
+
 typedef struct {
     const char* key;
     const char* value;
 } MetadataPair;
 
-EXPORT_GO_WRAPPER lib_handle load_library(const char *shared_lib_path);
-EXPORT_GO_WRAPPER int init_llama_embedder(char *model_path, uint32_t pooling_type);
+// EmbedderStatus mirrors llama_embedder_status of the shared library
+typedef enum {
+    LLAMA_EMBEDDER_OK = 0,
+    LLAMA_EMBEDDER_ERROR_UNKNOWN = 1,
+    LLAMA_EMBEDDER_ERROR_LIBRARY_LOAD = 2,
+    LLAMA_EMBEDDER_ERROR_MODEL_NOT_FOUND = 3,
+    LLAMA_EMBEDDER_ERROR_MODEL_LOAD = 4,
+    LLAMA_EMBEDDER_ERROR_INPUT_TOO_LONG = 5,
+    LLAMA_EMBEDDER_ERROR_EMPTY_INPUT = 6,
+    LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT = 7,
+    LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED = 8,
+    LLAMA_EMBEDDER_ERROR_DECODE = 9,
+    LLAMA_EMBEDDER_ERROR_OUT_OF_MEMORY = 10,
+    LLAMA_EMBEDDER_ERROR_CANCELLED = 11,
+} EmbedderStatus;
+
+// EmbedderError mirrors llama_embedder_error of the shared library
+typedef struct {
+    int32_t code;
+    char message[512];
+} EmbedderError;
+
+EXPORT_GO_WRAPPER lib_handle load_library(const char *shared_lib_path, EmbedderError *err);
+EXPORT_GO_WRAPPER int init_llama_embedder(char *model_path, uint32_t pooling_type, EmbedderError *err);
+EXPORT_GO_WRAPPER int init_llama_embedder_with_params(char *model_path, EmbedderParams *params, EmbedderError *err);
 EXPORT_GO_WRAPPER void free_llama_embedder();
-EXPORT_GO_WRAPPER FloatMatrix llama_embedder_embed(const char **texts, size_t text_count, int32_t norm);
+// Version and ABI version of the loaded shared library, "" and -1 for libraries that predate versioning
+EXPORT_GO_WRAPPER const char * llama_embedder_library_version();
+EXPORT_GO_WRAPPER int32_t llama_embedder_library_abi_version();
+EXPORT_GO_WRAPPER FloatMatrix llama_embedder_embed(const char **texts, size_t text_count, int32_t norm, EmbedderError *err);
+EXPORT_GO_WRAPPER FloatMatrix llama_embedder_embed_with_options(const char **texts, size_t text_count, EmbedOptions *options, EmbedderError *err);
+EXPORT_GO_WRAPPER FloatMatrix llama_embedder_embed_cancellable(const char **texts, size_t text_count, EmbedOptions *options, const volatile int32_t *cancel, EmbedderError *err);
+EXPORT_GO_WRAPPER int llama_embedder_embed_into(const char **texts, size_t text_count, EmbedOptions *options, float *out, size_t out_len, size_t *rows, size_t *cols, EmbedderError *err);
+EXPORT_GO_WRAPPER FloatMatrix llama_embedder_rerank(const char *query, const char **documents, size_t document_count, EmbedderError *err);
 EXPORT_GO_WRAPPER void free_float_matrixw(FloatMatrix * fm);
-
-EXPORT_GO_WRAPPER const char* get_last_error();
+EXPORT_GO_WRAPPER TokenEmbeddings llama_embedder_embed_tokens(const char **texts, size_t text_count, int32_t norm, EmbedderError *err);
+EXPORT_GO_WRAPPER void free_token_embeddingsw(TokenEmbeddings * te);
 
 EXPORT_GO_WRAPPER char ** llama_embedder_get_metadata(size_t* size);
+
+// Embedder contexts used by EmbedderPool, independent of the global embedder. A context created with
+// llama_embedder_new_context loads the model, llama_embedder_clone_context adds a context sharing its model.
+EXPORT_GO_WRAPPER llama_embedder * llama_embedder_new_context(char *model_path, EmbedderParams *params, EmbedderError *err);
+EXPORT_GO_WRAPPER llama_embedder * llama_embedder_clone_context(llama_embedder *base, EmbedderError *err);
+EXPORT_GO_WRAPPER void llama_embedder_free_context(llama_embedder *ctx);
+EXPORT_GO_WRAPPER FloatMatrix llama_embedder_context_embed(llama_embedder *ctx, const char **texts, size_t text_count, EmbedOptions *options, const volatile int32_t *cancel, EmbedderError *err);
+EXPORT_GO_WRAPPER char ** llama_embedder_context_get_metadata(llama_embedder *ctx, size_t* size);
 EXPORT_GO_WRAPPER void free_metadata(char** metadata_array, size_t size);
 
 #ifdef __cplusplus
@@ -17,6 +17,7 @@ enum class PoolingType {
     MEAN = 1,
     CLS = 2,
     LAST = 3,
+    RANK = 4,
 };
 
 class TokenizerData {
@@ -103,6 +104,7 @@ py::enum_<PoolingType>(m, "PoolingType")
 .value("MEAN", PoolingType::MEAN)
 .value("CLS", PoolingType::CLS)
 .value("LAST", PoolingType::LAST)
+.value("RANK", PoolingType::RANK)
 .export_values();
 
 py::class_<TokenizerData>(m, "TokenizerData")
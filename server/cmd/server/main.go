@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/api"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/cache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/collections"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embcache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/grpcserver"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/jobs"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/middleware"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/worker"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 )
 
 func main() {
@@ -15,17 +26,118 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	embeddingCache, err := embcache.NewFromEnv(utils.GetCacheDir())
+	if err != nil {
+		log.Fatalf("Failed to open the embedding cache: %v", err)
+	}
+	svc := &service.Service{Pools: cache.NewCache(), EmbeddingCache: embeddingCache, EmbedderOptions: worker.OptionsFingerprint()}
+	// batch jobs yield the worker pools to interactive requests
+	jobManager, err := jobs.NewManager(filepath.Join(utils.GetCacheDir(), "jobs"), func(ctx context.Context, model string, texts []string) ([][]float32, error) {
+		embeddings, _, err := svc.EmbedTextsWithPriority(ctx, model, texts, worker.PriorityLow)
+		return embeddings, err
+	})
+	if err != nil {
+		log.Fatalf("Failed to load the batch jobs: %v", err)
+	}
+	collectionManager, err := collections.NewManager(filepath.Join(utils.GetCacheDir(), "collections"), func(ctx context.Context, model string, texts []string) ([][]float32, error) {
+		embeddings, _, err := svc.EmbedTexts(ctx, model, texts)
+		return embeddings, err
+	}, service.ModelIdentity)
+	if err != nil {
+		log.Fatalf("Failed to load the collections: %v", err)
+	}
+	middleware.Configure(svc, jobManager, collectionManager)
 	if modelsToDownload, exists := os.LookupEnv("LLAMA_CACHED_MODELS"); exists {
 		err := utils.EnsureModels(modelsToDownload)
 		if err != nil {
 			panic(err)
 		}
+		// loads the worker pools of the cached models while the server starts, /ready reports when they can serve
+		if value, exists := os.LookupEnv("LLAMA_WARMUP"); exists {
+			warm, err := strconv.ParseBool(value)
+			if err != nil {
+				log.Fatalf("invalid LLAMA_WARMUP: %s", value)
+			}
+			if warm {
+				models, err := utils.ModelNames(modelsToDownload)
+				if err != nil {
+					panic(err)
+				}
+				middleware.GetWarmup().Start(context.Background(), models, svc.Warmup)
+			}
+		}
 	}
+	// resumes the jobs that were queued or running when the server stopped
+	jobManager.Start()
+
 	mux := http.NewServeMux()
 	mux.Handle("GET /embed_models", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.EmbedModelsHandler))))
 	mux.Handle("POST /embed_texts", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.EmbedTextsHandler))))
+	mux.Handle("POST /embed_texts/stream", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.EmbedTextsStreamHandler))))
+	mux.Handle("POST /similarity", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.SimilarityHandler))))
+	mux.Handle("POST /similarity/pairwise", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.PairwiseSimilarityHandler))))
+	mux.Handle("POST /rerank", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.RerankHandler))))
+	mux.Handle("GET /embed_cache/stats", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.EmbedCacheStatsHandler))))
+	mux.Handle("POST /jobs", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.CreateJobHandler))))
+	mux.Handle("GET /jobs", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.ListJobsHandler))))
+	mux.Handle("GET /jobs/{id}", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.GetJobHandler))))
+	mux.Handle("GET /jobs/{id}/results", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.GetJobResultsHandler))))
+	mux.Handle("POST /jobs/{id}/cancel", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.CancelJobHandler))))
+	mux.Handle("POST /collections", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.CreateCollectionHandler))))
+	mux.Handle("GET /collections", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.ListCollectionsHandler))))
+	mux.Handle("GET /collections/{name}", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.GetCollectionHandler))))
+	mux.Handle("DELETE /collections/{name}", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.DeleteCollectionHandler))))
+	mux.Handle("POST /collections/{name}/documents", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.AddDocumentsHandler))))
+	mux.Handle("DELETE /collections/{name}/documents/{id}", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.DeleteDocumentHandler))))
+	mux.Handle("POST /collections/{name}/query", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.QueryCollectionHandler))))
 	mux.Handle("GET /version", middleware.LoggingMiddleware(http.HandlerFunc(api.VersionHandler)))
 	mux.Handle("GET /health", middleware.LoggingMiddleware(http.HandlerFunc(api.HealthHandler)))
+	mux.Handle("GET /ready", middleware.LoggingMiddleware(middleware.CachingMiddleware(http.HandlerFunc(api.ReadyHandler))))
+
+	var grpcPort = fmt.Sprintf(":%d", 9090)
+	if envPort, exists := os.LookupEnv("GRPC_PORT"); exists {
+		grpcPort = fmt.Sprintf(":%s", envPort)
+	}
+	if grpcPort != ":0" {
+		lis, err := net.Listen("tcp", grpcPort)
+		if err != nil {
+			log.Fatalf("gRPC server failed to listen: %v", err)
+		}
+		grpcServer := grpcserver.NewServer(svc)
+		go func() {
+			log.Printf("gRPC server starting on port %s", grpcPort)
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatalf("gRPC server failed: %v", err)
+			}
+		}()
+	}
+
+	// the admin API controls the worker pools and is only served on its own listener, disabled unless ADMIN_ADDR or
+	// ADMIN_PORT is set. It listens on the loopback interface unless ADMIN_ADDR names another one.
+	if adminAddr, enabled := adminAddress(); enabled {
+		adminToken := os.Getenv("LLAMA_ADMIN_TOKEN")
+		if adminToken == "" {
+			insecure, _ := strconv.ParseBool(os.Getenv("LLAMA_ADMIN_INSECURE"))
+			if !insecure {
+				log.Fatalf("the admin API requires LLAMA_ADMIN_TOKEN, set LLAMA_ADMIN_INSECURE=true to serve it without authentication")
+			}
+			log.Printf("LLAMA_ADMIN_TOKEN is not set, the admin API on %s is not authenticated", adminAddr)
+		}
+		admin := func(handler http.HandlerFunc) http.Handler {
+			return middleware.LoggingMiddleware(middleware.AdminAuthMiddleware(adminToken, middleware.CachingMiddleware(handler)))
+		}
+		adminMux := http.NewServeMux()
+		adminMux.Handle("GET /admin/pools", admin(api.ListPoolsHandler))
+		adminMux.Handle("POST /admin/pools", admin(api.LoadPoolHandler))
+		adminMux.Handle("PATCH /admin/pools/{model}", admin(api.ResizePoolHandler))
+		adminMux.Handle("DELETE /admin/pools/{model}", admin(api.UnloadPoolHandler))
+		go func() {
+			log.Printf("Admin server starting on %s", adminAddr)
+			if err := http.ListenAndServe(adminAddr, adminMux); err != nil {
+				log.Fatalf("Admin server failed: %v", err)
+			}
+		}()
+	}
 
 	var port = fmt.Sprintf(":%d", 8080)
 	if envPort, exists := os.LookupEnv("PORT"); exists {
@@ -37,3 +149,14 @@ func main() {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
+
+// adminAddress returns the listen address of the admin API: ADMIN_ADDR if set, otherwise ADMIN_PORT on 127.0.0.1
+func adminAddress() (string, bool) {
+	if addr, exists := os.LookupEnv("ADMIN_ADDR"); exists {
+		return addr, true
+	}
+	if port, exists := os.LookupEnv("ADMIN_PORT"); exists {
+		return net.JoinHostPort("127.0.0.1", port), true
+	}
+	return "", false
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	cache2 "github.com/amikos-tech/llamacpp-embedder/server/internal/cache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/middleware"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/worker"
+)
+
+func poolCacheFromRequest(w http.ResponseWriter, r *http.Request) *cache2.Cache {
+	pools, _ := r.Context().Value(middleware.CacheKey).(*cache2.Cache)
+	if pools == nil {
+		http.Error(w, "Cache not found", http.StatusInternalServerError)
+	}
+	return pools
+}
+
+// writePoolError writes err with the HTTP status matching its cause
+func writePoolError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cache2.ErrPoolNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, cache2.ErrUnknownKind):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, worker.ErrPoolClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeServiceError(w, err)
+	}
+}
+
+// poolKind returns the kind query parameter, embed if it is not set
+func poolKind(r *http.Request) string {
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		return kind
+	}
+	return cache2.KindEmbed
+}
+
+// ListPoolsHandler lists the loaded worker pools
+func ListPoolsHandler(w http.ResponseWriter, r *http.Request) {
+	pools := poolCacheFromRequest(w, r)
+	if pools == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"pools": pools.List()})
+}
+
+// LoadPoolHandler loads the pool of a model and waits until its workers loaded the model. A pool that is already
+// loaded is resized to the requested number of workers. Loaded pools stay loaded until they are unloaded.
+func LoadPoolHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.LoadPoolRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = cache2.KindEmbed
+	}
+	if req.Workers == 0 {
+		req.Workers = service.DefaultPoolWorkers
+	}
+	if req.Workers < 0 {
+		http.Error(w, "workers must be positive", http.StatusBadRequest)
+		return
+	}
+	if err := service.ValidateModel(req.Model); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	pools := poolCacheFromRequest(w, r)
+	if pools == nil {
+		return
+	}
+	pool, err := pools.Load(req.Model, req.Kind, req.Workers)
+	if err != nil {
+		writePoolError(w, err)
+		return
+	}
+	if err := pool.Resize(r.Context(), req.Workers); err != nil {
+		writePoolError(w, err)
+		return
+	}
+	if err := pool.WaitLoaded(r.Context()); err != nil {
+		// a pool whose workers cannot load the model would never process a job
+		_ = pools.Unload(req.Model, req.Kind)
+		writePoolError(w, err)
+		return
+	}
+	_, info, err := pools.Get(req.Model, req.Kind)
+	if err != nil {
+		writePoolError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// ResizePoolHandler changes the number of workers of the pool of the model in the path
+func ResizePoolHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.ResizePoolRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Workers < 1 {
+		http.Error(w, "workers must be positive", http.StatusBadRequest)
+		return
+	}
+	pools := poolCacheFromRequest(w, r)
+	if pools == nil {
+		return
+	}
+	model, kind := r.PathValue("model"), poolKind(r)
+	pool, _, err := pools.Get(model, kind)
+	if err != nil {
+		writePoolError(w, err)
+		return
+	}
+	if err := pool.Resize(r.Context(), req.Workers); err != nil {
+		writePoolError(w, err)
+		return
+	}
+	_, info, err := pools.Get(model, kind)
+	if err != nil {
+		writePoolError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// UnloadPoolHandler closes the pool of the model in the path once its workers finished their current job
+func UnloadPoolHandler(w http.ResponseWriter, r *http.Request) {
+	pools := poolCacheFromRequest(w, r)
+	if pools == nil {
+		return
+	}
+	if err := pools.Unload(r.PathValue("model"), poolKind(r)); err != nil {
+		writePoolError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/collections"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/middleware"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
+)
+
+const defaultQueryTopK = 10
+
+func collectionManagerFromRequest(w http.ResponseWriter, r *http.Request) *collections.Manager {
+	manager, _ := r.Context().Value(middleware.CollectionsKey).(*collections.Manager)
+	if manager == nil {
+		http.Error(w, "Collection manager not found", http.StatusInternalServerError)
+	}
+	return manager
+}
+
+// writeCollectionError writes err with the HTTP status matching its cause
+func writeCollectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, collections.ErrCollectionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, collections.ErrCollectionExists), errors.Is(err, collections.ErrModelChanged):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, collections.ErrInvalidName), errors.Is(err, collections.ErrInvalidDocument):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeServiceError(w, err)
+	}
+}
+
+func CreateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.CreateCollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := service.ValidateModel(req.Model); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	manager := collectionManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	info, err := manager.Create(req.Name, req.Model)
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/collections/"+info.Name)
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func ListCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	manager := collectionManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"collections": manager.List()})
+}
+
+func GetCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	manager := collectionManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	info, err := manager.Get(r.PathValue("name"))
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func DeleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	manager := collectionManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	if err := manager.Drop(r.PathValue("name")); err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddDocumentsHandler embeds documents with the model of the collection and stores them
+func AddDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.AddDocumentsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	manager := collectionManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	docs := make([]collections.Document, len(req.Documents))
+	for i, doc := range req.Documents {
+		docs[i] = collections.Document{ID: doc.ID, Text: doc.Text, Metadata: doc.Metadata}
+	}
+	info, err := manager.Add(r.Context(), r.PathValue("name"), docs)
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func DeleteDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	manager := collectionManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	id := r.PathValue("id")
+	deleted, err := manager.Delete(r.PathValue("name"), []string{id})
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "document not found: "+id, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// QueryCollectionHandler returns the documents of a collection most similar to the query text
+func QueryCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.QueryCollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Query == "" {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		return
+	}
+	if req.TopK < 0 {
+		http.Error(w, "top_k must be non-negative", http.StatusBadRequest)
+		return
+	}
+	if req.TopK == 0 {
+		req.TopK = defaultQueryTopK
+	}
+	manager := collectionManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	results, err := manager.Query(r.Context(), r.PathValue("name"), req.Query, req.TopK)
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
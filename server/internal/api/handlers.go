@@ -1,35 +1,30 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/middleware"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
-	cache2 "github.com/amikos-tech/llamacpp-embedder/server/internal/cache"
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/quantize"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embcache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embedder"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
-	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/warmup"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/worker"
 )
 
 func EmbedModelsHandler(w http.ResponseWriter, _ *http.Request) {
-	files, err := os.ReadDir(utils.GetModelCacheDir())
+	ggufFiles, err := service.ListModels()
 	if err != nil {
 		http.Error(w, "Failed to read cache directory", http.StatusInternalServerError)
 		return
 	}
 
-	var ggufFiles []string
-	for _, file := range files {
-		if !file.IsDir() && filepath.Ext(file.Name()) == ".gguf" {
-			ggufFiles = append(ggufFiles, file.Name())
-		}
-	}
-
 	resp := types.EmbedModelListResponse{Models: ggufFiles}
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(resp)
@@ -47,38 +42,63 @@ func EmbedTextsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !strings.HasSuffix(strings.ToLower(req.Model), ".gguf") || strings.Contains(req.Model, "/") || strings.Contains(req.Model, "\\") || strings.Contains(req.Model, "..") {
-		http.Error(w, "Invalid model", http.StatusBadRequest)
+	if err := service.ValidateModel(req.Model); err != nil {
+		writeServiceError(w, err)
 		return
 	}
-
-	cache := r.Context().Value(middleware.CacheKey).(*cache2.Cache)
-	if cache == nil {
-		http.Error(w, "Cache not found", http.StatusInternalServerError)
+	encoding, err := quantize.ParseEncoding(req.Encoding)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	pool, err := cache.GetOrCreateWorkerPool(req.Model, 5) // Create a pool with 5 workers
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get or create worker pool: %v", err), http.StatusInternalServerError)
+	if req.TokenEmbeddings && (req.Dimensions != 0 || encoding != quantize.EncodingFloat) {
+		http.Error(w, "token_embeddings cannot be combined with dimensions or encoding", http.StatusBadRequest)
 		return
 	}
 
-	responseChan := make(chan *types.EmbedResponse)
-	job := worker.Job{
-		Request:  &req,
-		Response: responseChan,
+	svc, _ := r.Context().Value(middleware.ServiceKey).(*service.Service)
+	if svc == nil {
+		http.Error(w, "Cache not found", http.StatusInternalServerError)
+		return
 	}
 
-	pool.Submit(job)
-	resp := <-responseChan
+	if req.TokenEmbeddings {
+		tokenEmbeddings, err := svc.EmbedTokens(r.Context(), req.Model, req.Texts)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, types.EmbedResponse{TokenEmbeddings: tokenEmbeddings})
+		return
+	}
 
-	if resp.Error != "" {
-		http.Error(w, resp.Error, http.StatusInternalServerError)
+	if err := service.ValidateDimensions(req.Model, req.Dimensions); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	embeddings, hits, err := svc.EmbedTexts(r.Context(), req.Model, req.Texts)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
+	embeddings, err = service.TruncateEmbeddings(embeddings, req.Dimensions)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	resp := types.EmbedResponse{Embeddings: embeddings}
+	if encoding != quantize.EncodingFloat {
+		quantized, err := service.Quantize(req.Model, embeddings, encoding)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		resp = types.EmbedResponse{Encoding: string(encoding), QuantizedEmbeddings: quantized}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Embedding-Cache-Hits", strconv.Itoa(hits))
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -86,6 +106,40 @@ func EmbedTextsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func EmbedCacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	embeddingCache, _ := r.Context().Value(middleware.EmbeddingCacheKey).(*embcache.Cache)
+	if embeddingCache == nil {
+		http.Error(w, "Embedding cache not found", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(embeddingCache.Stats())
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// writeServiceError writes err with the HTTP status matching its cause
+func writeServiceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidModel), errors.Is(err, service.ErrInvalidDimensions),
+		errors.Is(err, service.ErrInvalidEncoding), errors.Is(err, embedder.ErrInputTooLong), errors.Is(err, embedder.ErrEmptyInput),
+		errors.Is(err, embedder.ErrInvalidArgument):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrModelNotFound), errors.Is(err, embedder.ErrModelNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, embedder.ErrModelLoad), errors.Is(err, embedder.ErrNotSupported):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, embedder.ErrOutOfMemory), errors.Is(err, worker.ErrPoolClosed):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func VersionHandler(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(map[string]string{"version": types.VERSION})
@@ -103,3 +157,19 @@ func HealthHandler(w http.ResponseWriter, _ *http.Request) {
 		return
 	}
 }
+
+// ReadyHandler reports whether the models warmed up at startup can serve, with the load state of each of them. It
+// responds with 503 until warmup completes and while a model failed to load.
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	tracker, _ := r.Context().Value(middleware.WarmupKey).(*warmup.Tracker)
+	if tracker == nil {
+		http.Error(w, "Warmup not found", http.StatusInternalServerError)
+		return
+	}
+	status := tracker.Status()
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
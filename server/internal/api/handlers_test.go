@@ -2,21 +2,42 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/cache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embcache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embedder"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/middleware"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/warmup"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/worker"
 	"github.com/stretchr/testify/require"
 )
 
 const defaultHFRepo = "leliuga/all-MiniLM-L6-v2-GGUF"
 const defaultModelFile = "all-MiniLM-L6-v2.Q4_0.gguf"
 
+func TestMain(m *testing.M) {
+	embeddingCache, err := embcache.New(64<<20, "")
+	if err != nil {
+		panic(err)
+	}
+	// the handlers under test do not use the batch jobs or the collections
+	middleware.Configure(&service.Service{Pools: cache.NewCache(), EmbeddingCache: embeddingCache}, nil, nil)
+	os.Exit(m.Run())
+}
+
 func TestHealthHandler(t *testing.T) {
 	req, err := http.NewRequest("GET", "/health", nil)
 	if err != nil {
@@ -43,6 +64,39 @@ func TestHealthHandler(t *testing.T) {
 	require.IsTypef(t, float64(0), returned["time"], "time should be a float64")
 }
 
+func TestReadyHandler(t *testing.T) {
+	release := make(chan struct{})
+	tracker := warmup.NewTracker()
+	tracker.Start(context.Background(), []string{defaultModelFile}, func(context.Context, string) error {
+		<-release
+		return nil
+	})
+	ready := func() (int, warmup.Status) {
+		req := httptest.NewRequest("GET", "/ready", nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.WarmupKey, tracker))
+		rr := httptest.NewRecorder()
+		ReadyHandler(rr, req)
+		var status warmup.Status
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+		return rr.Code, status
+	}
+
+	code, status := ready()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.False(t, status.Ready)
+	require.Len(t, status.Models, 1)
+	require.Equal(t, defaultModelFile, status.Models[0].Model)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		code, _ := ready()
+		return code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+	_, status = ready()
+	require.True(t, status.Ready)
+	require.Equal(t, warmup.StateReady, status.Models[0].State)
+}
+
 func TestVersionHandler(t *testing.T) {
 	req, err := http.NewRequest("GET", "/version", nil)
 	if err != nil {
@@ -70,7 +124,7 @@ func TestVersionHandler(t *testing.T) {
 func TestEmbedModelsHandler(t *testing.T) {
 	err := utils.EnsureCacheDir()
 	require.NoErrorf(t, err, "Error creating cache directory: %v", err)
-	err = utils.DownloadHFModel(defaultHFRepo, defaultModelFile, filepath.Join(utils.GetModelCacheDir(), defaultModelFile), "")
+	err = utils.EnsureModels(defaultHFRepo + "/" + defaultModelFile)
 	require.NoError(t, err, "Failed to download model")
 	req, err := http.NewRequest("GET", "/embed_models", nil)
 	require.NoError(t, err, "Failed to create request")
@@ -92,7 +146,7 @@ func TestEmbedModelsHandler(t *testing.T) {
 func TestEmbedTextsHandler(t *testing.T) {
 	err := utils.EnsureCacheDir()
 	require.NoErrorf(t, err, "Error creating cache directory: %v", err)
-	err = utils.DownloadHFModel(defaultHFRepo, defaultModelFile, filepath.Join(utils.GetModelCacheDir(), defaultModelFile), "")
+	err = utils.EnsureModels(defaultHFRepo + "/" + defaultModelFile)
 	require.NoError(t, err, "Failed to download model")
 	embedReq := types.EmbedRequest{Model: defaultModelFile, Texts: []string{"hello", "world"}}
 	marshal, err := json.Marshal(embedReq)
@@ -116,3 +170,224 @@ func TestEmbedTextsHandler(t *testing.T) {
 		require.Len(t, r, 384, "Embeddings should have length 384")
 	}
 }
+
+func TestEmbedTextsStreamHandler(t *testing.T) {
+	err := utils.EnsureCacheDir()
+	require.NoErrorf(t, err, "Error creating cache directory: %v", err)
+	err = utils.EnsureModels(defaultHFRepo + "/" + defaultModelFile)
+	require.NoError(t, err, "Failed to download model")
+	body := "\"hello\"\n{\"id\": \"w\", \"text\": \"world\"}\n\"again\"\n"
+	req, err := http.NewRequest("POST", "/embed_texts/stream?model="+defaultModelFile+"&batch_size=2", bytes.NewBufferString(body))
+	require.NoError(t, err, "Failed to create request")
+	rr := httptest.NewRecorder()
+	handler := http.Handler(middleware.CachingMiddleware(http.HandlerFunc(EmbedTextsStreamHandler)))
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	dec := json.NewDecoder(rr.Body)
+	var lines []types.StreamEmbedOutput
+	for dec.More() {
+		var line types.StreamEmbedOutput
+		require.NoError(t, dec.Decode(&line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 3)
+	for i, line := range lines {
+		require.Empty(t, line.Error)
+		require.Equal(t, i, line.Index)
+		require.Len(t, line.Embedding, 384)
+	}
+	require.Equal(t, "w", lines[1].ID)
+}
+
+func TestEmbedTextsStreamHandlerInvalidInput(t *testing.T) {
+	err := utils.EnsureCacheDir()
+	require.NoErrorf(t, err, "Error creating cache directory: %v", err)
+	err = utils.EnsureModels(defaultHFRepo + "/" + defaultModelFile)
+	require.NoError(t, err, "Failed to download model")
+	req, err := http.NewRequest("POST", "/embed_texts/stream?model="+defaultModelFile, bytes.NewBufferString("\"hello\"\n{not json\n"))
+	require.NoError(t, err, "Failed to create request")
+	rr := httptest.NewRecorder()
+	handler := http.Handler(middleware.CachingMiddleware(http.HandlerFunc(EmbedTextsStreamHandler)))
+	handler.ServeHTTP(rr, req)
+
+	dec := json.NewDecoder(rr.Body)
+	var last types.StreamEmbedOutput
+	for dec.More() {
+		require.NoError(t, dec.Decode(&last))
+	}
+	require.Contains(t, last.Error, "invalid input line 2")
+}
+
+func TestEmbedTextsStreamHandlerReturnsWhileClientSends(t *testing.T) {
+	// the model passes validation, the service without worker pools fails the first batch
+	modelPath := filepath.Join(utils.GetModelCacheDir(), "stream-test.gguf")
+	require.NoError(t, os.WriteFile(modelPath, []byte("not a GGUF model"), 0644))
+	t.Cleanup(func() { _ = os.Remove(modelPath) })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		EmbedTextsStreamHandler(w, r.WithContext(context.WithValue(r.Context(), middleware.ServiceKey, &service.Service{})))
+	}))
+	t.Cleanup(srv.Close)
+	body, input := io.Pipe()
+	t.Cleanup(func() { _ = input.Close() })
+	go func() {
+		// the client keeps the request open after the first line
+		_, _ = input.Write([]byte("\"hello\"\n"))
+	}()
+
+	done := make(chan string, 1)
+	go func() {
+		resp, err := http.Post(srv.URL+"/embed_texts/stream?batch_size=1&model=stream-test.gguf", "application/x-ndjson", body)
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		out, _ := io.ReadAll(resp.Body)
+		done <- string(out)
+	}()
+	select {
+	case out := <-done:
+		require.Contains(t, out, "cache not found")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the handler waited for the client to finish sending")
+	}
+}
+
+func TestSimilarityHandler(t *testing.T) {
+	err := utils.EnsureCacheDir()
+	require.NoErrorf(t, err, "Error creating cache directory: %v", err)
+	err = utils.EnsureModels(defaultHFRepo + "/" + defaultModelFile)
+	require.NoError(t, err, "Failed to download model")
+	simReq := types.SimilarityRequest{Model: defaultModelFile, Query: "a fast car", Candidates: []string{"a slow snail", "a quick automobile"}, TopK: 1}
+	marshal, err := json.Marshal(simReq)
+	require.NoError(t, err, "Failed to marshal request")
+	req, err := http.NewRequest("POST", "/similarity", bytes.NewBuffer(marshal))
+	require.NoError(t, err, "Failed to create request")
+	rr := httptest.NewRecorder()
+	handler := http.Handler(middleware.CachingMiddleware(http.HandlerFunc(SimilarityHandler)))
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var returned types.SimilarityResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &returned)
+	require.NoError(t, err, "Failed to unmarshal response")
+	require.Equal(t, "cosine", returned.Metric)
+	require.Len(t, returned.Scores, 1)
+	require.Equal(t, 1, returned.Scores[0].Index)
+}
+
+func TestRerankHandlerRequiresDocuments(t *testing.T) {
+	marshal, err := json.Marshal(types.RerankRequest{Model: defaultModelFile, Query: "what is a panda?"})
+	require.NoError(t, err, "Failed to marshal request")
+	req, err := http.NewRequest("POST", "/rerank", bytes.NewBuffer(marshal))
+	require.NoError(t, err, "Failed to create request")
+	rr := httptest.NewRecorder()
+	handler := http.Handler(middleware.CachingMiddleware(http.HandlerFunc(RerankHandler)))
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestEmbedTextsHandlerInvalidEncoding(t *testing.T) {
+	marshal, err := json.Marshal(types.EmbedRequest{Model: defaultModelFile, Texts: []string{"hello"}, Encoding: "int4"})
+	require.NoError(t, err, "Failed to marshal request")
+	req, err := http.NewRequest("POST", "/embed_texts", bytes.NewBuffer(marshal))
+	require.NoError(t, err, "Failed to create request")
+	rr := httptest.NewRecorder()
+	handler := http.Handler(middleware.CachingMiddleware(http.HandlerFunc(EmbedTextsHandler)))
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestHandlersMissingModel(t *testing.T) {
+	for path, handler := range map[string]http.HandlerFunc{
+		"/embed_texts": EmbedTextsHandler,
+		"/similarity":  SimilarityHandler,
+		"/rerank":      RerankHandler,
+	} {
+		body := `{"model": "missing.gguf", "texts": ["hello"], "query": "hello", "candidates": ["hello"], "documents": ["hello"]}`
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		middleware.CachingMiddleware(handler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusNotFound, rr.Code, path)
+	}
+}
+
+func TestWriteServiceError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("failed to embed text: %w", embedder.ErrInputTooLong), http.StatusBadRequest},
+		{embedder.ErrEmptyInput, http.StatusBadRequest},
+		{embedder.ErrModelNotFound, http.StatusNotFound},
+		{fmt.Errorf("%w: int4 is not quantized", service.ErrInvalidEncoding), http.StatusBadRequest},
+		{embedder.ErrModelLoad, http.StatusUnprocessableEntity},
+		{embedder.ErrOutOfMemory, http.StatusServiceUnavailable},
+		{worker.ErrPoolClosed, http.StatusServiceUnavailable},
+		{embedder.ErrDecode, http.StatusInternalServerError},
+		{fmt.Errorf("embedding: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		writeServiceError(rr, c.err)
+		require.Equal(t, c.code, rr.Code, c.err.Error())
+	}
+}
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	handler := middleware.AdminAuthMiddleware("secret", middleware.CachingMiddleware(http.HandlerFunc(ListPoolsHandler)))
+	for token, code := range map[string]int{"": http.StatusUnauthorized, "Bearer wrong": http.StatusUnauthorized, "Bearer secret": http.StatusOK} {
+		req := httptest.NewRequest("GET", "/admin/pools", nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		require.Equal(t, code, rr.Code, token)
+	}
+}
+
+func TestPoolAdminHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/pools", middleware.CachingMiddleware(http.HandlerFunc(ListPoolsHandler)))
+	mux.Handle("POST /admin/pools", middleware.CachingMiddleware(http.HandlerFunc(LoadPoolHandler)))
+	mux.Handle("PATCH /admin/pools/{model}", middleware.CachingMiddleware(http.HandlerFunc(ResizePoolHandler)))
+	mux.Handle("DELETE /admin/pools/{model}", middleware.CachingMiddleware(http.HandlerFunc(UnloadPoolHandler)))
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, target, bytes.NewBufferString(body)))
+		return rr
+	}
+	err := utils.EnsureModels(defaultHFRepo + "/" + defaultModelFile)
+	require.NoError(t, err, "Failed to download model")
+
+	rr := serve("POST", "/admin/pools", `{"model": "`+defaultModelFile+`", "workers": 2}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var info cache.PoolInfo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+	require.Equal(t, defaultModelFile, info.Model)
+	require.Equal(t, cache.KindEmbed, info.Kind)
+	require.Equal(t, 2, info.Workers)
+	require.True(t, info.Pinned)
+	require.Positive(t, info.MemoryEstimateBytes)
+
+	rr = serve("PATCH", "/admin/pools/"+defaultModelFile, `{"workers": 1}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+	require.Equal(t, 1, info.Workers)
+
+	rr = serve("GET", "/admin/pools", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var list struct{ Pools []cache.PoolInfo }
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	require.Contains(t, list.Pools, info)
+
+	require.Equal(t, http.StatusBadRequest, serve("DELETE", "/admin/pools/"+defaultModelFile+"?kind=other", "").Code)
+	require.Equal(t, http.StatusNotFound, serve("DELETE", "/admin/pools/"+defaultModelFile+"?kind=rerank", "").Code)
+	require.Equal(t, http.StatusNoContent, serve("DELETE", "/admin/pools/"+defaultModelFile, "").Code)
+	require.Equal(t, http.StatusNotFound, serve("PATCH", "/admin/pools/"+defaultModelFile, `{"workers": 3}`).Code)
+	require.Equal(t, http.StatusNotFound, serve("POST", "/admin/pools", `{"model": "missing.gguf"}`).Code)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/jobs"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/middleware"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
+)
+
+func jobManagerFromRequest(w http.ResponseWriter, r *http.Request) *jobs.Manager {
+	manager, _ := r.Context().Value(middleware.JobsKey).(*jobs.Manager)
+	if manager == nil {
+		http.Error(w, "Job manager not found", http.StatusInternalServerError)
+	}
+	return manager
+}
+
+// writeJobError writes err with the HTTP status matching its cause
+func writeJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, jobs.ErrJobFinished), errors.Is(err, jobs.ErrJobNotCompleted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, jobs.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateJobHandler stores a JSONL body of {"id", "text"} records and queues a job embedding it with the model given
+// in the model query parameter
+func CreateJobHandler(w http.ResponseWriter, r *http.Request) {
+	model := r.URL.Query().Get("model")
+	if err := service.ValidateModel(model); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	manager := jobManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	status, err := manager.Submit(model, r.Body)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+status.ID)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+func ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	manager := jobManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": manager.List()})
+}
+
+func GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	manager := jobManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	status, err := manager.Get(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// GetJobResultsHandler streams the JSONL results of a completed job
+func GetJobResultsHandler(w http.ResponseWriter, r *http.Request) {
+	manager := jobManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	results, err := manager.OpenResults(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	defer results.Close()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	_, _ = io.Copy(w, results)
+}
+
+func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	manager := jobManagerFromRequest(w, r)
+	if manager == nil {
+		return
+	}
+	status, err := manager.Cancel(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
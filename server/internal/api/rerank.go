@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/middleware"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
+)
+
+// RerankHandler scores documents against a query with a reranker model and returns them from the most to the least
+// relevant
+func RerankHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.RerankRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := service.ValidateModel(req.Model); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if req.Query == "" || len(req.Documents) == 0 {
+		http.Error(w, "query and documents are required", http.StatusBadRequest)
+		return
+	}
+	if req.TopK < 0 {
+		http.Error(w, "top_k must be non-negative", http.StatusBadRequest)
+		return
+	}
+	svc, _ := r.Context().Value(middleware.ServiceKey).(*service.Service)
+	if svc == nil {
+		http.Error(w, "Cache not found", http.StatusInternalServerError)
+		return
+	}
+
+	scores, err := svc.Rerank(r.Context(), req.Model, req.Query, req.Documents)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	results := make([]types.RerankResult, len(scores))
+	for i, score := range scores {
+		results[i] = types.RerankResult{Index: i, Document: req.Documents[i], RelevanceScore: score}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].RelevanceScore > results[j].RelevanceScore
+	})
+	if req.TopK > 0 && req.TopK < len(results) {
+		results = results[:req.TopK]
+	}
+	writeJSON(w, http.StatusOK, types.RerankResponse{Results: results})
+}
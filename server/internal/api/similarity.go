@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embedder"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/middleware"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/similarity"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
+)
+
+// l2Normalized reports whether the worker pools produce unit length vectors, in which case cosine similarity is a dot
+// product
+const l2Normalized = service.Normalization == embedder.NormalizationL2
+
+// SimilarityHandler embeds a query and candidate texts and returns the candidates sorted from the most to the least
+// similar. Euclidean scores are distances, so smaller scores come first.
+func SimilarityHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.SimilarityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := service.ValidateModel(req.Model); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if req.Query == "" || len(req.Candidates) == 0 {
+		http.Error(w, "query and candidates are required", http.StatusBadRequest)
+		return
+	}
+	metric, err := similarity.ParseMetric(req.Metric)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	svc, _ := r.Context().Value(middleware.ServiceKey).(*service.Service)
+	if svc == nil {
+		http.Error(w, "Cache not found", http.StatusInternalServerError)
+		return
+	}
+
+	texts := append([]string{req.Query}, req.Candidates...)
+	embeddings, _, err := svc.EmbedTexts(r.Context(), req.Model, texts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	ranked, err := similarity.Rank(metric, l2Normalized, embeddings[0], embeddings[1:], req.TopK)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := types.SimilarityResponse{Metric: string(metric), Scores: make([]types.SimilarityScore, len(ranked))}
+	for i, rk := range ranked {
+		resp.Scores[i] = types.SimilarityScore{Index: rk.Index, Text: req.Candidates[rk.Index], Score: rk.Score}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// PairwiseSimilarityHandler returns the matrix of scores between every pair of texts
+func PairwiseSimilarityHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.PairwiseSimilarityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := service.ValidateModel(req.Model); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if len(req.Texts) == 0 {
+		http.Error(w, "texts are required", http.StatusBadRequest)
+		return
+	}
+	metric, err := similarity.ParseMetric(req.Metric)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	svc, _ := r.Context().Value(middleware.ServiceKey).(*service.Service)
+	if svc == nil {
+		http.Error(w, "Cache not found", http.StatusInternalServerError)
+		return
+	}
+
+	embeddings, _, err := svc.EmbedTexts(r.Context(), req.Model, append(append([]string{}, req.Texts...), req.Others...))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	rows := embeddings[:len(req.Texts)]
+	cols := rows
+	if len(req.Others) > 0 {
+		cols = embeddings[len(req.Texts):]
+	}
+	scores, err := similarity.Matrix(metric, l2Normalized, rows, cols)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, types.PairwiseSimilarityResponse{Metric: string(metric), Scores: scores})
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/middleware"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
+)
+
+const (
+	defaultStreamBatchSize = 32
+	maxStreamBatchSize     = 1024
+)
+
+type streamBatch struct {
+	offset int
+	inputs []types.StreamEmbedInput
+}
+
+type streamResult struct {
+	batch      streamBatch
+	embeddings [][]float32
+	err        error
+}
+
+// readStreamInput decodes the next NDJSON value, which is either a bare string or a StreamEmbedInput object
+func readStreamInput(dec *json.Decoder) (types.StreamEmbedInput, error) {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return types.StreamEmbedInput{}, err
+	}
+	raw = bytes.TrimSpace(raw)
+	var input types.StreamEmbedInput
+	if len(raw) > 0 && raw[0] == '"' {
+		if err := json.Unmarshal(raw, &input.Text); err != nil {
+			return input, err
+		}
+		return input, nil
+	}
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return input, err
+	}
+	return input, nil
+}
+
+// EmbedTextsStreamHandler embeds newline-delimited JSON texts and streams back one embedding per line.
+// The model is selected with the model query parameter and the internal batch size with batch_size.
+// Input is read one batch ahead of the worker pool: at most DefaultPoolWorkers batches are in flight at any time.
+func EmbedTextsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	model := r.URL.Query().Get("model")
+	if err := service.ValidateModel(model); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	batchSize := defaultStreamBatchSize
+	if v := r.URL.Query().Get("batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxStreamBatchSize {
+			http.Error(w, fmt.Sprintf("Invalid batch_size, must be between 1 and %d", maxStreamBatchSize), http.StatusBadRequest)
+			return
+		}
+		batchSize = n
+	}
+	svc, _ := r.Context().Value(middleware.ServiceKey).(*service.Service)
+	if svc == nil {
+		http.Error(w, "Cache not found", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	rc := http.NewResponseController(w)
+	// allow reading the request body while the response is being written (HTTP/1.x)
+	_ = rc.EnableFullDuplex()
+
+	pending := make(chan chan streamResult, service.DefaultPoolWorkers)
+	// the request body must not be read after the handler returns, so wait for the reader on every exit path. The
+	// expired read deadline unblocks a reader waiting for the client to send more input.
+	defer func() {
+		cancel()
+		_ = rc.SetReadDeadline(time.Now())
+		for range pending {
+		}
+	}()
+	go func() {
+		defer close(pending)
+		dec := json.NewDecoder(r.Body)
+		offset := 0
+		for ctx.Err() == nil {
+			batch := streamBatch{offset: offset}
+			var readErr error
+			for len(batch.inputs) < batchSize {
+				input, err := readStreamInput(dec)
+				if err != nil {
+					readErr = err
+					break
+				}
+				batch.inputs = append(batch.inputs, input)
+			}
+			offset += len(batch.inputs)
+			if len(batch.inputs) > 0 {
+				resultChan := make(chan streamResult, 1)
+				select {
+				case pending <- resultChan:
+				case <-ctx.Done():
+					return
+				}
+				go func(batch streamBatch) {
+					texts := make([]string, len(batch.inputs))
+					for i, input := range batch.inputs {
+						texts[i] = input.Text
+					}
+					embeddings, _, err := svc.EmbedTexts(ctx, model, texts)
+					resultChan <- streamResult{batch: batch, embeddings: embeddings, err: err}
+				}(batch)
+			}
+			if readErr != nil {
+				if !errors.Is(readErr, io.EOF) {
+					resultChan := make(chan streamResult, 1)
+					resultChan <- streamResult{batch: streamBatch{offset: offset}, err: fmt.Errorf("invalid input line %d: %v", offset+1, readErr)}
+					select {
+					case pending <- resultChan:
+					case <-ctx.Done():
+					}
+				}
+				return
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for resultChan := range pending {
+		res := <-resultChan
+		if res.err != nil {
+			_ = enc.Encode(types.StreamEmbedOutput{Index: res.batch.offset, Error: res.err.Error()})
+			_ = rc.Flush()
+			return
+		}
+		for i, embedding := range res.embeddings {
+			err := enc.Encode(types.StreamEmbedOutput{
+				Index:     res.batch.offset + i,
+				ID:        res.batch.inputs[i].ID,
+				Embedding: embedding,
+			})
+			if err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -1,20 +1,68 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embedder"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/worker"
 )
 
+// poolKey identifies a pool; the same model file can be loaded with different pooling types
+type poolKey struct {
+	model   string
+	pooling embedder.PoolingType
+}
+
+// Kinds name the pools of a model by what they serve, each loads the model with its own pooling type
+const (
+	KindEmbed  = "embed"
+	KindRerank = "rerank"
+	KindTokens = "tokens"
+)
+
+var kindPooling = map[string]embedder.PoolingType{
+	KindEmbed:  embedder.PoolingMean,
+	KindRerank: embedder.PoolingRank,
+	KindTokens: embedder.PoolingNone,
+}
+
+var (
+	ErrUnknownKind  = errors.New("unknown pool kind")
+	ErrPoolNotFound = errors.New("pool not loaded")
+)
+
+func keyOf(model, kind string) (poolKey, error) {
+	pooling, ok := kindPooling[kind]
+	if !ok {
+		return poolKey{}, fmt.Errorf("%w %q, expected %s, %s or %s", ErrUnknownKind, kind, KindEmbed, KindRerank, KindTokens)
+	}
+	return poolKey{model: model, pooling: pooling}, nil
+}
+
+func (k poolKey) kind() string {
+	for kind, pooling := range kindPooling {
+		if pooling == k.pooling {
+			return kind
+		}
+	}
+	return fmt.Sprintf("pooling-%d", k.pooling)
+}
+
 type Cache struct {
-	pools map[string]*worker.Pool
-	mu    sync.RWMutex
+	pools map[poolKey]*worker.Pool
+	// pinned pools are kept loaded when idle, such as the pools of warmed up models
+	pinned map[poolKey]bool
+	mu     sync.RWMutex
 }
 
 func NewCache() *Cache {
 	cache := &Cache{
-		pools: make(map[string]*worker.Pool),
+		pools:  make(map[poolKey]*worker.Pool),
+		pinned: make(map[poolKey]bool),
 	}
 	go cache.cleanupExpiredPools()
 	return cache
@@ -25,29 +73,154 @@ func (c *Cache) cleanupExpiredPools() {
 	defer ticker.Stop()
 
 	for range ticker.C {
+		var expired []*worker.Pool
 		c.mu.Lock()
-		for model, pool := range c.pools {
+		for key, pool := range c.pools {
+			if c.pinned[key] {
+				continue
+			}
 			if time.Since(pool.GetLastAccessed()) > 1*time.Minute {
-				pool.Close()
-				delete(c.pools, model)
+				expired = append(expired, pool)
+				delete(c.pools, key)
 			}
 		}
 		c.mu.Unlock()
+		// closing waits for the current jobs, which must not block the requests that need c.mu
+		for _, pool := range expired {
+			pool.Close()
+		}
 	}
 }
 
 func (c *Cache) GetOrCreateWorkerPool(model string, workers int) (*worker.Pool, error) {
+	return c.getOrCreate(poolKey{model: model, pooling: embedder.PoolingMean}, workers, false)
+}
+
+// GetOrCreatePinnedWorkerPool is like GetOrCreateWorkerPool but keeps the pool loaded when it is idle. The pool is
+// pinned as it is created, so the cleanup cannot close it while it loads.
+func (c *Cache) GetOrCreatePinnedWorkerPool(model string, workers int) (*worker.Pool, error) {
+	return c.getOrCreate(poolKey{model: model, pooling: embedder.PoolingMean}, workers, true)
+}
+
+// GetOrCreateRerankPool returns the pool of embedders loading model with rank pooling
+func (c *Cache) GetOrCreateRerankPool(model string, workers int) (*worker.Pool, error) {
+	return c.getOrCreate(poolKey{model: model, pooling: embedder.PoolingRank}, workers, false)
+}
+
+// GetOrCreateTokenPool returns the pool of embedders loading model without pooling, for token embeddings
+func (c *Cache) GetOrCreateTokenPool(model string, workers int) (*worker.Pool, error) {
+	return c.getOrCreate(poolKey{model: model, pooling: embedder.PoolingNone}, workers, false)
+}
+
+// getOrCreate returns the pool of key, creating it if needed, and pins it with pin
+func (c *Cache) getOrCreate(key poolKey, workers int, pin bool) (*worker.Pool, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if pool, found := c.pools[model]; found {
-		return pool, nil
+	pool, found := c.pools[key]
+	if !found {
+		var err error
+		pool, err = worker.NewPoolWithPooling(key.model, key.pooling, workers)
+		if err != nil {
+			return nil, err
+		}
+		c.pools[key] = pool
 	}
+	if pin {
+		c.pinned[key] = true
+	}
+	return pool, nil
+}
+
+// PoolInfo describes a loaded pool
+type PoolInfo struct {
+	Model   string `json:"model"`
+	Kind    string `json:"kind"`
+	Workers int    `json:"workers"`
+	// BusyWorkers are processing a job, QueueDepth jobs are waiting for a worker
+	BusyWorkers int `json:"busy_workers"`
+	QueueDepth  int `json:"queue_depth"`
+	// LastAccessed is when the last job was submitted, or the pool was created
+	LastAccessed *time.Time `json:"last_accessed,omitempty"`
+	// MemoryEstimateBytes is the memory held by the model weights, see worker.Pool.MemoryEstimate
+	MemoryEstimateBytes int64 `json:"memory_estimate_bytes"`
+	// Pinned pools are kept loaded when idle
+	Pinned bool `json:"pinned"`
+}
+
+func (c *Cache) info(key poolKey, pool *worker.Pool) PoolInfo {
+	info := PoolInfo{
+		Model:               key.model,
+		Kind:                key.kind(),
+		Workers:             pool.Workers(),
+		BusyWorkers:         pool.Busy(),
+		QueueDepth:          pool.QueueDepth(),
+		MemoryEstimateBytes: pool.MemoryEstimate(),
+		Pinned:              c.pinned[key],
+	}
+	if lastAccessed := pool.GetLastAccessed(); !lastAccessed.IsZero() {
+		info.LastAccessed = &lastAccessed
+	}
+	return info
+}
+
+// List describes the loaded pools, sorted by model and kind
+func (c *Cache) List() []PoolInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	pools := make([]PoolInfo, 0, len(c.pools))
+	for key, pool := range c.pools {
+		pools = append(pools, c.info(key, pool))
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		if pools[i].Model != pools[j].Model {
+			return pools[i].Model < pools[j].Model
+		}
+		return pools[i].Kind < pools[j].Kind
+	})
+	return pools
+}
 
-	pool, err := worker.NewPool(model, workers)
+// Get returns the loaded pool of the given kind of model
+func (c *Cache) Get(model, kind string) (*worker.Pool, PoolInfo, error) {
+	key, err := keyOf(model, kind)
+	if err != nil {
+		return nil, PoolInfo{}, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	pool, found := c.pools[key]
+	if !found {
+		return nil, PoolInfo{}, fmt.Errorf("%w: %s %s", ErrPoolNotFound, kind, model)
+	}
+	return pool, c.info(key, pool), nil
+}
+
+// Load returns the pool of the given kind of model, creating it with workers workers if it is not loaded, and pins it
+// so that it stays loaded until it is unloaded
+func (c *Cache) Load(model, kind string, workers int) (*worker.Pool, error) {
+	key, err := keyOf(model, kind)
 	if err != nil {
 		return nil, err
 	}
-	c.pools[model] = pool
-	return pool, nil
+	return c.getOrCreate(key, workers, true)
+}
+
+// Unload removes the pool of the given kind of model and closes it once its workers finished their current job.
+// Jobs still waiting for a worker fail with worker.ErrPoolClosed.
+func (c *Cache) Unload(model, kind string) error {
+	key, err := keyOf(model, kind)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	pool, found := c.pools[key]
+	delete(c.pools, key)
+	delete(c.pinned, key)
+	c.mu.Unlock()
+	if !found {
+		return fmt.Errorf("%w: %s %s", ErrPoolNotFound, kind, model)
+	}
+	pool.Close()
+	return nil
 }
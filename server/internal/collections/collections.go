@@ -0,0 +1,375 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/similarity"
+)
+
+var (
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrCollectionExists   = errors.New("collection already exists")
+	ErrInvalidName        = errors.New("invalid collection name")
+	ErrInvalidDocument    = errors.New("invalid document")
+	ErrModelChanged       = errors.New("model changed since the collection was created")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// EmbedFunc embeds texts with the given model
+type EmbedFunc func(ctx context.Context, model string, texts []string) ([][]float32, error)
+
+// IdentityFunc returns a string identifying the contents of the model file, see embcache.FileIdentity
+type IdentityFunc func(model string) (string, error)
+
+// Document is a text stored in a collection
+type Document struct {
+	ID       string         `json:"id"`
+	Text     string         `json:"text"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// Info describes a collection
+type Info struct {
+	Name      string    `json:"name"`
+	Model     string    `json:"model"`
+	Count     int       `json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// QueryResult is a document matching a query and its cosine similarity to the query
+type QueryResult struct {
+	Document
+	Score float32 `json:"score"`
+}
+
+type storedDocument struct {
+	Document
+	Embedding []float32 `json:"embedding"`
+}
+
+// file is the persisted form of a collection. ModelIdentity is empty in collections created before it was stored.
+type file struct {
+	Name          string            `json:"name"`
+	Model         string            `json:"model"`
+	ModelIdentity string            `json:"model_identity,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Documents     []*storedDocument `json:"documents"`
+}
+
+type collection struct {
+	name      string
+	model     string
+	identity  string
+	createdAt time.Time
+	documents []*storedDocument
+	positions map[string]int
+	// dropped is set when the collection is dropped while a document change is in progress
+	dropped bool
+	mu      sync.RWMutex
+}
+
+func (c *collection) info() Info {
+	return Info{Name: c.name, Model: c.model, Count: len(c.documents), CreatedAt: c.createdAt}
+}
+
+// Manager holds collections of embedded documents in memory. Every collection is persisted to its own JSON file under
+// the manager directory after each change, which suits collections of up to a few hundred thousand documents.
+type Manager struct {
+	dir         string
+	embed       EmbedFunc
+	identify    IdentityFunc
+	collections map[string]*collection
+	mu          sync.RWMutex
+}
+
+// NewManager creates a manager storing collections under dir and loads the collections already present there.
+// Files that cannot be loaded are renamed to <name>.json.corrupt and skipped so that they neither stop the server nor
+// get overwritten by a new collection of the same name.
+func NewManager(dir string, embed EmbedFunc, identify IdentityFunc) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create collections directory: %v", err)
+	}
+	m := &Manager{dir: dir, embed: embed, identify: identify, collections: make(map[string]*collection)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		c, err := load(path)
+		if err != nil {
+			log.Printf("Skipping collection: %v", err)
+			if err := os.Rename(path, path+".corrupt"); err != nil {
+				log.Printf("Could not quarantine %s: %v", path, err)
+			}
+			continue
+		}
+		m.collections[c.name] = c
+	}
+	return m, nil
+}
+
+func load(path string) (*collection, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("could not load collection %s: %v", path, err)
+	}
+	if f.Name+".json" != filepath.Base(path) {
+		return nil, fmt.Errorf("could not load collection %s: name %q does not match the file name", path, f.Name)
+	}
+	c := &collection{name: f.Name, model: f.Model, identity: f.ModelIdentity, createdAt: f.CreatedAt, positions: make(map[string]int)}
+	for _, doc := range f.Documents {
+		c.positions[doc.ID] = len(c.documents)
+		c.documents = append(c.documents, doc)
+	}
+	return c, nil
+}
+
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.dir, name+".json")
+}
+
+// save persists c. The caller must hold c.mu.
+func (m *Manager) save(c *collection) error {
+	data, err := json.Marshal(file{Name: c.name, Model: c.model, ModelIdentity: c.identity, CreatedAt: c.createdAt, Documents: c.documents})
+	if err != nil {
+		return err
+	}
+	path := m.path(c.name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (m *Manager) get(name string) (*collection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.collections[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	return c, nil
+}
+
+// checkModel fails when the model file of c was replaced since c was created, as the stored embeddings would not be
+// comparable to new ones
+func (m *Manager) checkModel(c *collection) error {
+	if c.identity == "" {
+		return nil
+	}
+	identity, err := m.identify(c.model)
+	if err != nil {
+		return err
+	}
+	if identity != c.identity {
+		return fmt.Errorf("%w: %s uses %s", ErrModelChanged, c.name, c.model)
+	}
+	return nil
+}
+
+// Create creates an empty collection whose documents are embedded with model. The identity of the model file is
+// stored with the collection.
+func (m *Manager) Create(name, model string) (Info, error) {
+	if !validName.MatchString(name) {
+		return Info{}, fmt.Errorf("%w: %q, use 1 to 64 letters, digits, '-' or '_'", ErrInvalidName, name)
+	}
+	identity, err := m.identify(model)
+	if err != nil {
+		return Info{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.collections[name]; ok {
+		return Info{}, fmt.Errorf("%w: %s", ErrCollectionExists, name)
+	}
+	c := &collection{name: name, model: model, identity: identity, createdAt: time.Now().UTC(), positions: make(map[string]int)}
+	if err := m.save(c); err != nil {
+		return Info{}, err
+	}
+	m.collections[name] = c
+	return c.info(), nil
+}
+
+// List returns the collections sorted by name
+func (m *Manager) List() []Info {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	infos := make([]Info, 0, len(m.collections))
+	for _, c := range m.collections {
+		c.mu.RLock()
+		infos = append(infos, c.info())
+		c.mu.RUnlock()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// Get returns the description of a collection
+func (m *Manager) Get(name string) (Info, error) {
+	c, err := m.get(name)
+	if err != nil {
+		return Info{}, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.info(), nil
+}
+
+// Drop deletes a collection and its documents
+func (m *Manager) Drop(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.collections[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Remove(m.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.dropped = true
+	delete(m.collections, name)
+	return nil
+}
+
+// Add embeds docs with the model of the collection and stores them. Documents with the id of a stored document
+// replace it.
+func (m *Manager) Add(ctx context.Context, name string, docs []Document) (Info, error) {
+	c, err := m.get(name)
+	if err != nil {
+		return Info{}, err
+	}
+	seen := make(map[string]bool, len(docs))
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		if strings.TrimSpace(doc.ID) == "" {
+			return Info{}, fmt.Errorf("%w: document %d has no id", ErrInvalidDocument, i)
+		}
+		if seen[doc.ID] {
+			return Info{}, fmt.Errorf("%w: duplicate id %s", ErrInvalidDocument, doc.ID)
+		}
+		seen[doc.ID] = true
+		texts[i] = doc.Text
+	}
+	if len(docs) == 0 {
+		return m.Get(name)
+	}
+	if err := m.checkModel(c); err != nil {
+		return Info{}, err
+	}
+	// the embedding is the slow part, do not block queries of the collection while it runs
+	embeddings, err := m.embed(ctx, c.model, texts)
+	if err != nil {
+		return Info{}, err
+	}
+	if len(embeddings) != len(docs) {
+		return Info{}, fmt.Errorf("expected %d embeddings, got %d", len(docs), len(embeddings))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped {
+		return Info{}, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	for i, doc := range docs {
+		stored := &storedDocument{Document: doc, Embedding: embeddings[i]}
+		if pos, ok := c.positions[doc.ID]; ok {
+			c.documents[pos] = stored
+		} else {
+			c.positions[doc.ID] = len(c.documents)
+			c.documents = append(c.documents, stored)
+		}
+	}
+	if err := m.save(c); err != nil {
+		return Info{}, err
+	}
+	return c.info(), nil
+}
+
+// Delete removes the documents with the given ids from a collection and returns the number of documents removed
+func (m *Manager) Delete(name string, ids []string) (int, error) {
+	c, err := m.get(name)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped {
+		return 0, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	deleted := 0
+	for _, id := range ids {
+		pos, ok := c.positions[id]
+		if !ok {
+			continue
+		}
+		last := len(c.documents) - 1
+		c.documents[pos] = c.documents[last]
+		c.positions[c.documents[pos].ID] = pos
+		c.documents = c.documents[:last]
+		delete(c.positions, id)
+		deleted++
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	return deleted, m.save(c)
+}
+
+// Query embeds text with the model of the collection and returns the topK most similar documents
+func (m *Manager) Query(ctx context.Context, name string, text string, topK int) ([]QueryResult, error) {
+	c, err := m.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkModel(c); err != nil {
+		return nil, err
+	}
+	embeddings, err := m.embed(ctx, c.model, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != 1 {
+		return nil, fmt.Errorf("expected 1 embedding, got %d", len(embeddings))
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	candidates := make([][]float32, len(c.documents))
+	for i, doc := range c.documents {
+		candidates[i] = doc.Embedding
+	}
+	ranked, err := similarity.Rank(similarity.Cosine, false, embeddings[0], candidates, topK)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]QueryResult, len(ranked))
+	for i, r := range ranked {
+		results[i] = QueryResult{Document: c.documents[r.Index].Document, Score: r.Score}
+	}
+	return results, nil
+}
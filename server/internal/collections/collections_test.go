@@ -0,0 +1,146 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// embedLetters embeds a text as the counts of the letters a, b and c
+func embedLetters(_ context.Context, _ string, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = []float32{float32(strings.Count(text, "a")), float32(strings.Count(text, "b")), float32(strings.Count(text, "c"))}
+	}
+	return embeddings, nil
+}
+
+// identities returns the identity of every model from a map that tests change to simulate replaced model files
+func identities(ids map[string]string) IdentityFunc {
+	return func(model string) (string, error) {
+		id, ok := ids[model]
+		if !ok {
+			return "", errors.New("model not found")
+		}
+		return id, nil
+	}
+}
+
+var sameModel = identities(map[string]string{"model.gguf": "model.gguf:1:1"})
+
+func TestCollections(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	m, err := NewManager(dir, embedLetters, sameModel)
+	require.NoError(t, err)
+
+	_, err = m.Create("../escape", "model.gguf")
+	require.ErrorIs(t, err, ErrInvalidName)
+	info, err := m.Create("docs", "model.gguf")
+	require.NoError(t, err)
+	require.Equal(t, "docs", info.Name)
+	_, err = m.Create("docs", "model.gguf")
+	require.ErrorIs(t, err, ErrCollectionExists)
+
+	_, err = m.Add(ctx, "docs", []Document{{ID: "1", Text: "a"}, {ID: "1", Text: "b"}})
+	require.ErrorIs(t, err, ErrInvalidDocument)
+	info, err = m.Add(ctx, "docs", []Document{
+		{ID: "a", Text: "aaa", Metadata: map[string]any{"source": "test"}},
+		{ID: "b", Text: "bbb"},
+		{ID: "c", Text: "ccc"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, info.Count)
+
+	results, err := m.Query(ctx, "docs", "bb a", 2)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, "b", results[0].ID)
+	require.Equal(t, "a", results[1].ID)
+	require.Equal(t, "test", results[1].Metadata["source"])
+
+	// replacing a document re-embeds it
+	_, err = m.Add(ctx, "docs", []Document{{ID: "c", Text: "bbbb"}})
+	require.NoError(t, err)
+	deleted, err := m.Delete("docs", []string{"b", "missing"})
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	// collections are reloaded from disk
+	reloaded, err := NewManager(dir, embedLetters, sameModel)
+	require.NoError(t, err)
+	info, err = reloaded.Get("docs")
+	require.NoError(t, err)
+	require.Equal(t, 2, info.Count)
+	require.Equal(t, "model.gguf", info.Model)
+	results, err = reloaded.Query(ctx, "docs", "b", 1)
+	require.NoError(t, err)
+	require.Equal(t, "c", results[0].ID)
+	require.Equal(t, "bbbb", results[0].Text)
+
+	require.NoError(t, reloaded.Drop("docs"))
+	require.ErrorIs(t, reloaded.Drop("docs"), ErrCollectionNotFound)
+	_, err = reloaded.Query(ctx, "docs", "a", 1)
+	require.ErrorIs(t, err, ErrCollectionNotFound)
+	reloaded, err = NewManager(dir, embedLetters, sameModel)
+	require.NoError(t, err)
+	require.Empty(t, reloaded.List())
+}
+
+func TestAddEmbeddingError(t *testing.T) {
+	m, err := NewManager(t.TempDir(), func(context.Context, string, []string) ([][]float32, error) {
+		return nil, errors.New("model failed")
+	}, sameModel)
+	require.NoError(t, err)
+	_, err = m.Create("docs", "model.gguf")
+	require.NoError(t, err)
+	_, err = m.Add(context.Background(), "docs", []Document{{ID: "1", Text: "a"}})
+	require.Error(t, err)
+	info, err := m.Get("docs")
+	require.NoError(t, err)
+	require.Equal(t, 0, info.Count)
+}
+
+func TestCorruptCollectionIsQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"name": "broken", "docu`), 0644))
+	m, err := NewManager(dir, embedLetters, sameModel)
+	require.NoError(t, err)
+	_, err = m.Get("broken")
+	require.ErrorIs(t, err, ErrCollectionNotFound)
+	require.FileExists(t, filepath.Join(dir, "broken.json.corrupt"))
+
+	// the name can be reused without overwriting the corrupt file
+	_, err = m.Create("broken", "model.gguf")
+	require.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(dir, "broken.json.corrupt"))
+	require.NoError(t, err)
+	require.Equal(t, `{"name": "broken", "docu`, string(content))
+}
+
+func TestModelChanged(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	ids := map[string]string{"model.gguf": "model.gguf:1:1"}
+	m, err := NewManager(dir, embedLetters, identities(ids))
+	require.NoError(t, err)
+	_, err = m.Create("docs", "missing.gguf")
+	require.Error(t, err)
+	_, err = m.Create("docs", "model.gguf")
+	require.NoError(t, err)
+	_, err = m.Add(ctx, "docs", []Document{{ID: "a", Text: "a"}})
+	require.NoError(t, err)
+
+	ids["model.gguf"] = "model.gguf:2:2"
+	reloaded, err := NewManager(dir, embedLetters, identities(ids))
+	require.NoError(t, err)
+	_, err = reloaded.Add(ctx, "docs", []Document{{ID: "b", Text: "b"}})
+	require.ErrorIs(t, err, ErrModelChanged)
+	_, err = reloaded.Query(ctx, "docs", "a", 1)
+	require.ErrorIs(t, err, ErrModelChanged)
+}
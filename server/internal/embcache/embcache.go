@@ -0,0 +1,279 @@
+package embcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Key is the content address of a single embedding.
+type Key [sha256.Size]byte
+
+func (k Key) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// NewKey derives the cache key for a text embedded by the model identified by modelIdentity
+// with the given pooling and normalization types. options identifies the other embedder settings
+// that change the embeddings, such as the context size.
+func NewKey(modelIdentity, options string, pooling, normalization int32, text string) Key {
+	h := sha256.New()
+	var lenBuf [8]byte
+	for _, s := range []string{modelIdentity, options} {
+		binary.LittleEndian.PutUint64(lenBuf[:], uint64(len(s)))
+		h.Write(lenBuf[:])
+		h.Write([]byte(s))
+	}
+	var params [8]byte
+	binary.LittleEndian.PutUint32(params[0:4], uint32(pooling))
+	binary.LittleEndian.PutUint32(params[4:8], uint32(normalization))
+	h.Write(params[:])
+	h.Write([]byte(text))
+	var k Key
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// FileIdentity returns a string identifying the contents of the model file at path without hashing the whole file.
+// A model that is replaced on disk (different size or modification time) gets a new identity.
+func FileIdentity(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("model path is a directory: %s", path)
+	}
+	return fmt.Sprintf("%s:%d:%d", info.Name(), info.Size(), info.ModTime().UnixNano()), nil
+}
+
+type entry struct {
+	key       Key
+	embedding []float32
+}
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits       uint64  `json:"hits"`
+	DiskHits   uint64  `json:"disk_hits"`
+	Misses     uint64  `json:"misses"`
+	HitRate    float64 `json:"hit_rate"`
+	Entries    int     `json:"entries"`
+	Bytes      int64   `json:"bytes"`
+	MaxBytes   int64   `json:"max_bytes"`
+	DiskTier   bool    `json:"disk_tier"`
+	DiskErrors uint64  `json:"disk_errors"`
+}
+
+// Cache is an in-memory LRU of embeddings bounded by bytes, optionally backed by an on-disk tier.
+type Cache struct {
+	maxBytes   int64
+	curBytes   int64
+	ll         *list.List
+	items      map[Key]*list.Element
+	diskDir    string
+	mu         sync.Mutex
+	hits       atomic.Uint64
+	diskHits   atomic.Uint64
+	misses     atomic.Uint64
+	diskErrors atomic.Uint64
+}
+
+// New creates a cache holding at most maxBytes of embeddings in memory.
+// If diskDir is not empty, entries are also written to and read back from files under diskDir.
+func New(maxBytes int64, diskDir string) (*Cache, error) {
+	if maxBytes < 0 {
+		return nil, fmt.Errorf("max bytes must be non-negative")
+	}
+	if diskDir != "" {
+		if err := os.MkdirAll(diskDir, 0755); err != nil {
+			return nil, fmt.Errorf("could not create embedding cache directory: %v", err)
+		}
+	}
+	return &Cache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[Key]*list.Element),
+		diskDir:  diskDir,
+	}, nil
+}
+
+const defaultMaxMB = 256
+
+// NewFromEnv creates a cache configured from the environment.
+// LLAMA_EMBEDDING_CACHE_MB sets the in-memory budget in megabytes (default 256, 0 disables the memory tier) and
+// LLAMA_EMBEDDING_CACHE_DISK=true enables the on-disk tier under cacheDir/embeddings.
+func NewFromEnv(cacheDir string) (*Cache, error) {
+	maxBytes := int64(defaultMaxMB) << 20
+	if v, exists := os.LookupEnv("LLAMA_EMBEDDING_CACHE_MB"); exists {
+		mb, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || mb < 0 {
+			return nil, fmt.Errorf("invalid LLAMA_EMBEDDING_CACHE_MB: %s", v)
+		}
+		maxBytes = mb << 20
+	}
+	var diskDir string
+	if v, exists := os.LookupEnv("LLAMA_EMBEDDING_CACHE_DISK"); exists {
+		enabled, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid LLAMA_EMBEDDING_CACHE_DISK: %s", v)
+		}
+		if enabled {
+			diskDir = filepath.Join(cacheDir, "embeddings")
+		}
+	}
+	return New(maxBytes, diskDir)
+}
+
+func entrySize(embedding []float32) int64 {
+	return int64(len(embedding))*4 + sha256.Size
+}
+
+// Get returns a copy of the cached embedding for k.
+func (c *Cache) Get(k Key) ([]float32, bool) {
+	c.mu.Lock()
+	if el, ok := c.items[k]; ok {
+		c.ll.MoveToFront(el)
+		embedding := el.Value.(*entry).embedding
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return append([]float32(nil), embedding...), true
+	}
+	c.mu.Unlock()
+
+	if c.diskDir != "" {
+		embedding, err := c.readDisk(k)
+		if err == nil {
+			c.hits.Add(1)
+			c.diskHits.Add(1)
+			c.putMemory(k, embedding)
+			return append([]float32(nil), embedding...), true
+		}
+		if !os.IsNotExist(err) {
+			c.diskErrors.Add(1)
+		}
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+// Put stores a copy of embedding under k.
+func (c *Cache) Put(k Key, embedding []float32) {
+	stored := append([]float32(nil), embedding...)
+	c.putMemory(k, stored)
+	if c.diskDir != "" {
+		if err := c.writeDisk(k, stored); err != nil {
+			c.diskErrors.Add(1)
+		}
+	}
+}
+
+func (c *Cache) putMemory(k Key, embedding []float32) {
+	size := entrySize(embedding)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size > c.maxBytes {
+		return
+	}
+	if el, ok := c.items[k]; ok {
+		old := el.Value.(*entry)
+		c.curBytes += size - entrySize(old.embedding)
+		old.embedding = embedding
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[k] = c.ll.PushFront(&entry{key: k, embedding: embedding})
+		c.curBytes += size
+	}
+	for c.curBytes > c.maxBytes {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		e := oldest.Value.(*entry)
+		c.ll.Remove(oldest)
+		delete(c.items, e.key)
+		c.curBytes -= entrySize(e.embedding)
+	}
+}
+
+// Stats returns the current cache counters.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	entries := c.ll.Len()
+	curBytes := c.curBytes
+	c.mu.Unlock()
+	hits := c.hits.Load()
+	misses := c.misses.Load()
+	var hitRate float64
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	return Stats{
+		Hits:       hits,
+		DiskHits:   c.diskHits.Load(),
+		Misses:     misses,
+		HitRate:    hitRate,
+		Entries:    entries,
+		Bytes:      curBytes,
+		MaxBytes:   c.maxBytes,
+		DiskTier:   c.diskDir != "",
+		DiskErrors: c.diskErrors.Load(),
+	}
+}
+
+func (c *Cache) diskPath(k Key) string {
+	name := k.String()
+	return filepath.Join(c.diskDir, name[:2], name+".bin")
+}
+
+func (c *Cache) readDisk(k Key) ([]float32, error) {
+	data, err := os.ReadFile(c.diskPath(k))
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("corrupt embedding cache entry %s", k)
+	}
+	embedding := make([]float32, len(data)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return embedding, nil
+}
+
+func (c *Cache) writeDisk(k Key, embedding []float32) error {
+	path := c.diskPath(k)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data := make([]byte, len(embedding)*4)
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,110 @@
+package embcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKey(t *testing.T) {
+	k := NewKey("model.gguf:1:1", "", 1, 2, "hello")
+	require.Equal(t, k, NewKey("model.gguf:1:1", "", 1, 2, "hello"))
+	require.NotEqual(t, k, NewKey("model.gguf:1:2", "", 1, 2, "hello"))
+	require.NotEqual(t, k, NewKey("model.gguf:1:1", "", 2, 2, "hello"))
+	require.NotEqual(t, k, NewKey("model.gguf:1:1", "", 1, -1, "hello"))
+	require.NotEqual(t, k, NewKey("model.gguf:1:1", "", 1, 2, "hello!"))
+	require.NotEqual(t, k, NewKey("model.gguf:1:1", "n_ctx=256", 1, 2, "hello"))
+}
+
+func TestFileIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	require.NoError(t, os.WriteFile(path, []byte("abc"), 0644))
+	identity, err := FileIdentity(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("abcd"), 0644))
+	changed, err := FileIdentity(path)
+	require.NoError(t, err)
+	require.NotEqual(t, identity, changed)
+
+	_, err = FileIdentity(filepath.Join(t.TempDir(), "missing.gguf"))
+	require.Error(t, err)
+}
+
+func TestCacheEviction(t *testing.T) {
+	embedding := []float32{1, 2, 3, 4}
+	// room for exactly two entries
+	c, err := New(2*entrySize(embedding), "")
+	require.NoError(t, err)
+	k1 := NewKey("m", "", 1, 2, "one")
+	k2 := NewKey("m", "", 1, 2, "two")
+	k3 := NewKey("m", "", 1, 2, "three")
+	c.Put(k1, embedding)
+	c.Put(k2, embedding)
+	_, found := c.Get(k1)
+	require.True(t, found)
+	c.Put(k3, embedding)
+
+	_, found = c.Get(k2)
+	require.False(t, found, "least recently used entry should be evicted")
+	got, found := c.Get(k1)
+	require.True(t, found)
+	require.Equal(t, embedding, got)
+
+	stats := c.Stats()
+	require.Equal(t, 2, stats.Entries)
+	require.Equal(t, 2*entrySize(embedding), stats.Bytes)
+	require.Equal(t, uint64(2), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+}
+
+func TestCacheReturnsCopies(t *testing.T) {
+	c, err := New(1<<20, "")
+	require.NoError(t, err)
+	k := NewKey("m", "", 1, 2, "text")
+	embedding := []float32{1, 2}
+	c.Put(k, embedding)
+	embedding[0] = 42
+	got, found := c.Get(k)
+	require.True(t, found)
+	require.Equal(t, []float32{1, 2}, got)
+	got[1] = 42
+	got, _ = c.Get(k)
+	require.Equal(t, []float32{1, 2}, got)
+}
+
+func TestCacheDiskTier(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(1<<20, dir)
+	require.NoError(t, err)
+	k := NewKey("m", "", 1, 2, "persisted")
+	c.Put(k, []float32{0.5, -0.25, 3})
+
+	reopened, err := New(1<<20, dir)
+	require.NoError(t, err)
+	got, found := reopened.Get(k)
+	require.True(t, found)
+	require.Equal(t, []float32{0.5, -0.25, 3}, got)
+	require.Equal(t, uint64(1), reopened.Stats().DiskHits)
+
+	// promoted to memory
+	_, found = reopened.Get(k)
+	require.True(t, found)
+	require.Equal(t, uint64(1), reopened.Stats().DiskHits)
+}
+
+func TestNewFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("LLAMA_EMBEDDING_CACHE_MB", "1")
+	t.Setenv("LLAMA_EMBEDDING_CACHE_DISK", "true")
+	c, err := NewFromEnv(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(1<<20), c.Stats().MaxBytes)
+	require.True(t, c.Stats().DiskTier)
+	require.DirExists(t, filepath.Join(dir, "embeddings"))
+
+	t.Setenv("LLAMA_EMBEDDING_CACHE_MB", "-1")
+	_, err = NewFromEnv(dir)
+	require.Error(t, err)
+}
@@ -15,12 +15,16 @@ package embedder
 */
 import "C"
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/modelsource"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
 )
 
@@ -37,6 +41,7 @@ const (
 	PoolingMean              PoolingType       = 1
 	PoolingCls               PoolingType       = 2
 	PoolingLast              PoolingType       = 3
+	PoolingRank              PoolingType       = 4
 )
 
 type LlamaEmbedder struct {
@@ -45,10 +50,22 @@ type LlamaEmbedder struct {
 	defaultPoolingType       PoolingType
 	hfRepo                   string
 	localCacheDir            string
+	contextParams            contextParams
 	embedder                 *C.llama_embedder
 	mu                       sync.RWMutex
 }
 
+// contextParams are the llama.cpp context parameters of the embedder. Zero values keep the llama.cpp defaults.
+type contextParams struct {
+	contextSize    uint32
+	batchSize      uint32
+	ubatchSize     uint32
+	threads        int32
+	useMmap        bool
+	useMlock       bool
+	flashAttention bool
+}
+
 type Option func(*LlamaEmbedder) error
 
 // WithNormalization sets the normalization type to use
@@ -61,7 +78,7 @@ func WithNormalization(norm NormalizationType) Option {
 }
 
 // WithPooling sets the pooling type to use
-// Possible values are PoolingNone, PoolingMean (default), PoolingCls, PoolingLast
+// Possible values are PoolingNone, PoolingMean (default), PoolingCls, PoolingLast, PoolingRank (reranker models)
 func WithPooling(pool PoolingType) Option {
 	return func(e *LlamaEmbedder) error {
 		e.defaultPoolingType = pool
@@ -69,6 +86,75 @@ func WithPooling(pool PoolingType) Option {
 	}
 }
 
+// WithContextSize sets the context size in tokens. By default the training context size of the model is used.
+// The batch size must be at least the context size.
+func WithContextSize(size uint32) Option {
+	return func(e *LlamaEmbedder) error {
+		if size == 0 {
+			return fmt.Errorf("context size must be positive")
+		}
+		e.contextParams.contextSize = size
+		return nil
+	}
+}
+
+// WithBatchSize sets the logical batch size, which is also the maximum number of tokens of a single text (2048 by default)
+func WithBatchSize(size uint32) Option {
+	return func(e *LlamaEmbedder) error {
+		if size == 0 {
+			return fmt.Errorf("batch size must be positive")
+		}
+		e.contextParams.batchSize = size
+		return nil
+	}
+}
+
+// WithUBatchSize sets the physical batch size. It defaults to the batch size, which non-causal (BERT-like) models require.
+func WithUBatchSize(size uint32) Option {
+	return func(e *LlamaEmbedder) error {
+		if size == 0 {
+			return fmt.Errorf("ubatch size must be positive")
+		}
+		e.contextParams.ubatchSize = size
+		return nil
+	}
+}
+
+// WithThreads sets the number of threads used to compute embeddings. By default llama.cpp uses the number of CPU cores.
+func WithThreads(threads int) Option {
+	return func(e *LlamaEmbedder) error {
+		if threads <= 0 {
+			return fmt.Errorf("threads must be positive")
+		}
+		e.contextParams.threads = int32(threads)
+		return nil
+	}
+}
+
+// WithMmap sets whether the model file is memory-mapped (default true)
+func WithMmap(enabled bool) Option {
+	return func(e *LlamaEmbedder) error {
+		e.contextParams.useMmap = enabled
+		return nil
+	}
+}
+
+// WithMlock sets whether the model is locked in RAM to prevent it from being swapped out (default false)
+func WithMlock(enabled bool) Option {
+	return func(e *LlamaEmbedder) error {
+		e.contextParams.useMlock = enabled
+		return nil
+	}
+}
+
+// WithFlashAttention enables flash attention (default false)
+func WithFlashAttention(enabled bool) Option {
+	return func(e *LlamaEmbedder) error {
+		e.contextParams.flashAttention = enabled
+		return nil
+	}
+}
+
 // WithHFRepo sets the Hugging Face repo to download the model from
 func WithHFRepo(repo string) Option {
 	return func(e *LlamaEmbedder) error {
@@ -105,6 +191,7 @@ func NewLlamaEmbedder(modelPath string, opts ...Option) (*LlamaEmbedder, func(),
 		modelPath:                modelPath,
 		defaultNormalizationType: NormalizationL2,
 		defaultPoolingType:       PoolingMean,
+		contextParams:            contextParams{useMmap: true},
 	}
 	for _, opt := range opts {
 		if err := opt(e); err != nil {
@@ -119,23 +206,36 @@ func NewLlamaEmbedder(modelPath string, opts ...Option) (*LlamaEmbedder, func(),
 		return nil, nil, err
 	}
 	if e.hfRepo != "" {
-		e.modelPath = filepath.Join(e.localCacheDir, filepath.Base(modelPath))
-		err := utils.DownloadHFModel(e.hfRepo, modelPath, e.modelPath, "")
+		src, err := modelsource.HuggingFace(e.hfRepo, modelPath, os.Getenv("HF_TOKEN"))
 		if err != nil {
 			return nil, nil, err
 		}
+		if e.modelPath, err = src.Fetch(context.Background(), e.localCacheDir); err != nil {
+			return nil, nil, err
+		}
 	} else {
 		if _, err := os.Stat(modelPath); os.IsNotExist(err) {
-			return nil, nil, err
+			return nil, nil, fmt.Errorf("%w: %s", ErrModelNotFound, modelPath)
 		}
 		e.modelPath = modelPath
 	}
-	cModelPath := C.CString(modelPath)
+	cModelPath := C.CString(e.modelPath)
 	defer C.free(unsafe.Pointer(cModelPath))
 	var embedder *C.llama_embedder
-	result := C.init_embedder_l(&embedder, cModelPath, C.uint32_t(uint32(e.defaultPoolingType)))
+	params := C.EmbedderParamsW{
+		pooling_type: C.uint32_t(uint32(e.defaultPoolingType)),
+		n_ctx:        C.uint32_t(e.contextParams.contextSize),
+		n_batch:      C.uint32_t(e.contextParams.batchSize),
+		n_ubatch:     C.uint32_t(e.contextParams.ubatchSize),
+		n_threads:    C.int32_t(e.contextParams.threads),
+		use_mmap:     C.bool(e.contextParams.useMmap),
+		use_mlock:    C.bool(e.contextParams.useMlock),
+		flash_attn:   C.bool(e.contextParams.flashAttention),
+	}
+	var cErr C.EmbedderErrorW
+	result := C.init_embedder_with_params_l(&embedder, cModelPath, &params, &cErr)
 	if result != 0 {
-		return nil, nil, fmt.Errorf("failed to initialize embedder")
+		return nil, nil, fmt.Errorf("failed to initialize embedder: %w", toError(&cErr))
 	}
 	e.embedder = embedder
 	return e, func() {
@@ -145,10 +245,27 @@ func NewLlamaEmbedder(modelPath string, opts ...Option) (*LlamaEmbedder, func(),
 
 type FloatMatrixW C.FloatMatrixW
 
+// toError converts the error reported by a native call to a Go error
+func toError(cErr *C.EmbedderErrorW) error {
+	return nativeError(int32(cErr.code), C.GoString(&cErr.message[0]))
+}
+
 // EmbedTexts embeds the given texts using the model
 func (e *LlamaEmbedder) EmbedTexts(texts []string) ([][]float32, error) {
+	return e.EmbedTextsContext(context.Background(), texts)
+}
+
+// EmbedTextsContext embeds the given texts and stops as soon as ctx is done, between the internal batches and within
+// a batch through the llama.cpp abort callback, returning ctx.Err()
+func (e *LlamaEmbedder) EmbedTextsContext(ctx context.Context, texts []string) ([][]float32, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("%w: no texts to embed", ErrEmptyInput)
+	}
 	cTexts := make([]*C.char, len(texts))
 	for i, t := range texts {
 		cTexts[i] = C.CString(t)
@@ -159,11 +276,23 @@ func (e *LlamaEmbedder) EmbedTexts(texts []string) ([][]float32, error) {
 			C.free(unsafe.Pointer(t))
 		}
 	}()
-	result := C.embed_texts(e.embedder, (**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), C.int32_t(int32(e.defaultNormalizationType)))
+	var cErr C.EmbedderErrorW
+	var result C.FloatMatrixW
+	if ctx.Done() == nil {
+		// the context can never be cancelled
+		result = C.embed_texts(e.embedder, (**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), C.int32_t(int32(e.defaultNormalizationType)), &cErr)
+	} else {
+		cancel, release := watchContext(ctx)
+		result = C.embed_texts_cancellable(e.embedder, (**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), C.int32_t(int32(e.defaultNormalizationType)), cancel, &cErr)
+		release()
+	}
 
 	defer C.free_float_matrixw((*C.FloatMatrixW)(unsafe.Pointer(&result)))
 	if result.data == nil {
-		return nil, fmt.Errorf("failed to embed text: %v", C.GoString(C.get_last_error()))
+		if cErr.code == statusCancelled && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to embed text: %w", toError(&cErr))
 	}
 
 	// Convert the result to a Go slice
@@ -178,6 +307,141 @@ func (e *LlamaEmbedder) EmbedTexts(texts []string) ([][]float32, error) {
 	return goResult, nil
 }
 
+// watchContext returns a flag in C memory that is set once ctx is done, for the native code to poll, and a function
+// that stops watching and frees the flag
+func watchContext(ctx context.Context) (*C.int32_t, func()) {
+	cancel := (*C.int32_t)(C.calloc(1, C.sizeof_int32_t))
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32((*int32)(unsafe.Pointer(cancel)), 1)
+		case <-done:
+		}
+	}()
+	return cancel, func() {
+		close(done)
+		<-stopped
+		C.free(unsafe.Pointer(cancel))
+	}
+}
+
+// Tokenize returns the token ids of each of the given texts
+func (e *LlamaEmbedder) Tokenize(texts []string) ([][]int32, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if len(texts) == 0 {
+		return [][]int32{}, nil
+	}
+	cTexts := make([]*C.char, len(texts))
+	for i, t := range texts {
+		cTexts[i] = C.CString(t)
+	}
+	defer func() {
+		for _, t := range cTexts {
+			C.free(unsafe.Pointer(t))
+		}
+	}()
+	var cErr C.EmbedderErrorW
+	result := C.tokenize_texts(e.embedder, (**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), &cErr)
+	defer C.free_int_ragged_matrixw(&result)
+	if result.data == nil {
+		return nil, fmt.Errorf("failed to tokenize text: %w", toError(&cErr))
+	}
+	lengths := unsafe.Slice((*C.size_t)(unsafe.Pointer(result.lengths)), int(result.rows))
+	var total int
+	for _, l := range lengths {
+		total += int(l)
+	}
+	data := unsafe.Slice((*int32)(unsafe.Pointer(result.data)), total)
+	tokens := make([][]int32, len(lengths))
+	offset := 0
+	for i, l := range lengths {
+		tokens[i] = append([]int32(nil), data[offset:offset+int(l)]...)
+		offset += int(l)
+	}
+	return tokens, nil
+}
+
+// EmbedTokens returns the embedding of every token of each text and the token ids. The embedder must use PoolingNone.
+func (e *LlamaEmbedder) EmbedTokens(texts []string) ([]types.TokenEmbeddings, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.defaultPoolingType != PoolingNone {
+		return nil, fmt.Errorf("%w: token embeddings require an embedder created with PoolingNone", ErrInvalidArgument)
+	}
+	if len(texts) == 0 {
+		return []types.TokenEmbeddings{}, nil
+	}
+	cTexts := make([]*C.char, len(texts))
+	for i, t := range texts {
+		cTexts[i] = C.CString(t)
+	}
+	defer func() {
+		for _, t := range cTexts {
+			C.free(unsafe.Pointer(t))
+		}
+	}()
+	var cErr C.EmbedderErrorW
+	result := C.embed_tokens_texts(e.embedder, (**C.char)(unsafe.Pointer(&cTexts[0])), C.size_t(len(texts)), C.int32_t(int32(e.defaultNormalizationType)), &cErr)
+	defer C.free_token_embeddingsw(&result)
+	if result.lengths == nil {
+		return nil, fmt.Errorf("failed to embed tokens: %w", toError(&cErr))
+	}
+	lengths := unsafe.Slice((*C.size_t)(unsafe.Pointer(result.lengths)), int(result.rows))
+	var total int
+	for _, l := range lengths {
+		total += int(l)
+	}
+	cols := int(result.cols)
+	tokens := unsafe.Slice((*int32)(unsafe.Pointer(result.tokens)), total)
+	data := unsafe.Slice((*float32)(unsafe.Pointer(result.data)), total*cols)
+	out := make([]types.TokenEmbeddings, len(lengths))
+	offset := 0
+	for i, l := range lengths {
+		n := int(l)
+		out[i].Tokens = append([]int32(nil), tokens[offset:offset+n]...)
+		out[i].Embeddings = make([][]float32, n)
+		for j := 0; j < n; j++ {
+			out[i].Embeddings[j] = append([]float32(nil), data[(offset+j)*cols:(offset+j+1)*cols]...)
+		}
+		offset += n
+	}
+	return out, nil
+}
+
+// Rerank returns the relevance score of each of docs for query. The embedder must use PoolingRank.
+func (e *LlamaEmbedder) Rerank(query string, docs []string) ([]float32, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.defaultPoolingType != PoolingRank {
+		return nil, fmt.Errorf("%w: rerank requires an embedder created with PoolingRank", ErrInvalidArgument)
+	}
+	if len(docs) == 0 {
+		return []float32{}, nil
+	}
+	cQuery := C.CString(query)
+	defer C.free(unsafe.Pointer(cQuery))
+	cDocs := make([]*C.char, len(docs))
+	for i, d := range docs {
+		cDocs[i] = C.CString(d)
+	}
+	defer func() {
+		for _, d := range cDocs {
+			C.free(unsafe.Pointer(d))
+		}
+	}()
+	var cErr C.EmbedderErrorW
+	result := C.rerank_texts(e.embedder, cQuery, (**C.char)(unsafe.Pointer(&cDocs[0])), C.size_t(len(docs)), &cErr)
+	defer C.free_float_matrixw(&result)
+	if result.data == nil {
+		return nil, fmt.Errorf("failed to rerank documents: %w", toError(&cErr))
+	}
+	return append([]float32(nil), unsafe.Slice((*float32)(unsafe.Pointer(result.data)), int(result.rows))...), nil
+}
+
 // Close closes the embedder and frees any resources
 func (e *LlamaEmbedder) Close() {
 	e.mu.RLock()
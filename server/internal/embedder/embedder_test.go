@@ -1,10 +1,12 @@
 package embedder
 
 import (
-	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+	"context"
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/modelsource"
 	"github.com/stretchr/testify/require"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -12,9 +14,9 @@ const defaultHFRepo = "leliuga/all-MiniLM-L6-v2-GGUF"
 const defaultModelFile = "all-MiniLM-L6-v2.Q4_0.gguf"
 
 func ensureModel(t *testing.T) string {
-	tempDir := t.TempDir()
-	targetLocation := filepath.Join(tempDir, "llama_cache", defaultModelFile)
-	err := utils.DownloadHFModel(defaultHFRepo, defaultModelFile, targetLocation, "")
+	src, err := modelsource.HuggingFace(defaultHFRepo, defaultModelFile, "")
+	require.NoError(t, err)
+	targetLocation, err := src.Fetch(context.Background(), filepath.Join(t.TempDir(), "llama_cache"))
 	require.NoErrorf(t, err, "Failed to download model: %v", err)
 	return targetLocation
 }
@@ -100,3 +102,24 @@ func TestEmbedTexts(t *testing.T) {
 		require.Len(t, embedding, 384)
 	}
 }
+
+func TestEmbedderErrors(t *testing.T) {
+	t.Run("ModelNotFound", func(t *testing.T) {
+		_, _, err := NewLlamaEmbedder("does-not-exist.gguf")
+		require.ErrorIs(t, err, ErrModelNotFound)
+	})
+	t.Run("EmptyInput", func(t *testing.T) {
+		embedder, cleanup, err := NewLlamaEmbedder(ensureModel(t))
+		require.NoError(t, err)
+		t.Cleanup(cleanup)
+		_, err = embedder.EmbedTexts(nil)
+		require.ErrorIs(t, err, ErrEmptyInput)
+	})
+	t.Run("InputTooLong", func(t *testing.T) {
+		embedder, cleanup, err := NewLlamaEmbedder(ensureModel(t), WithContextSize(32), WithBatchSize(32))
+		require.NoError(t, err)
+		t.Cleanup(cleanup)
+		_, err = embedder.EmbedTexts([]string{strings.Repeat("hello world ", 100)})
+		require.ErrorIs(t, err, ErrInputTooLong)
+	})
+}
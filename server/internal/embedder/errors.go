@@ -0,0 +1,56 @@
+package embedder
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the embedder, use errors.Is to check for them
+var (
+	ErrModelNotFound   = errors.New("model not found")
+	ErrModelLoad       = errors.New("failed to load model")
+	ErrInputTooLong    = errors.New("input too long")
+	ErrEmptyInput      = errors.New("empty input")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrNotSupported    = errors.New("not supported")
+	ErrDecode          = errors.New("failed to compute embeddings")
+	ErrOutOfMemory     = errors.New("out of memory")
+)
+
+// Status codes of the native errors, see llama_embedder_status in src/embedder.h
+const (
+	statusOK = iota
+	statusUnknown
+	statusLibraryLoad
+	statusModelNotFound
+	statusModelLoad
+	statusInputTooLong
+	statusEmptyInput
+	statusInvalidArgument
+	statusNotSupported
+	statusDecode
+	statusOutOfMemory
+	statusCancelled
+)
+
+var statusErrors = map[int32]error{
+	statusModelNotFound:   ErrModelNotFound,
+	statusModelLoad:       ErrModelLoad,
+	statusInputTooLong:    ErrInputTooLong,
+	statusEmptyInput:      ErrEmptyInput,
+	statusInvalidArgument: ErrInvalidArgument,
+	statusNotSupported:    ErrNotSupported,
+	statusDecode:          ErrDecode,
+	statusOutOfMemory:     ErrOutOfMemory,
+}
+
+// nativeError converts the status code and message of a native call to an error wrapping the matching sentinel error
+func nativeError(code int32, message string) error {
+	if code == statusOK {
+		return nil
+	}
+	if sentinel, ok := statusErrors[code]; ok {
+		return fmt.Errorf("%w: %s", sentinel, message)
+	}
+	return errors.New(message)
+}
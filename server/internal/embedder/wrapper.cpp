@@ -7,34 +7,50 @@
 #include "atomic"
 #include <thread>
 #include <string>
+#include <stdexcept>
 #include <stdint.h>
 
 #include "../../../src/embedder.h"
 #include "wrapper.h"
 
-static std::string last_error;
 static std::mutex embedder_mutex;
-extern "C" {
 
-const char* get_last_error() {
-    std::lock_guard<std::mutex> lock(embedder_mutex);
-    return last_error.c_str();
+// copy_error copies the error of a native call to the Go side
+static void copy_error(const llama_embedder_error &error, EmbedderErrorW *err) {
+    if (err == nullptr) {
+        return;
+    }
+    err->code = error.code;
+    std::memcpy(err->message, error.message, sizeof(err->message));
 }
 
-void set_last_error(const char* error_message) {
-    std::lock_guard<std::mutex> lock(embedder_mutex);
-    last_error = error_message;
+static void set_error(EmbedderErrorW *err, int32_t code, const char *message) {
+    if (err == nullptr) {
+        return;
+    }
+    err->code = code;
+    snprintf(err->message, sizeof(err->message), "%s", message);
+}
+
+extern "C" {
+
+int init_embedder_l(llama_embedder** out_embedder, const char* model_path, uint32_t pooling_type, EmbedderErrorW* err) {
+    llama_embedder_params params = llama_embedder_default_params();
+    params.pooling_type = pooling_type;
+    EmbedderParamsW paramsw = {params.pooling_type, params.n_ctx, params.n_batch, params.n_ubatch,
+                               params.n_threads, params.use_mmap, params.use_mlock, params.flash_attn};
+    return init_embedder_with_params_l(out_embedder, model_path, &paramsw, err);
 }
 
-int init_embedder_l(llama_embedder** out_embedder, const char* model_path, uint32_t pooling_type) {
+int init_embedder_with_params_l(llama_embedder** out_embedder, const char* model_path, const EmbedderParamsW* params,
+                                EmbedderErrorW* err) {
     std::lock_guard<std::mutex> lock(embedder_mutex);
-    try {
-        *out_embedder = init_embedder(model_path, pooling_type);
-        return 0;
-    } catch (const std::exception& e) {
-        fprintf(stderr, "Error: %s\n", e.what());
-        return -1;
-    }
+    llama_embedder_params inner = {params->pooling_type, params->n_ctx, params->n_batch, params->n_ubatch,
+                                   params->n_threads, params->use_mmap, params->use_mlock, params->flash_attn};
+    llama_embedder_error error;
+    *out_embedder = init_embedder_e(model_path, &inner, &error);
+    copy_error(error, err);
+    return *out_embedder == nullptr ? -1 : 0;
 }
 
 void free_embedder_l(llama_embedder *embedder) {
@@ -45,23 +61,27 @@ void free_embedder_l(llama_embedder *embedder) {
     free_embedder(embedder);
 }
 
-FloatMatrixW embed_texts(llama_embedder *embedder, const char ** texts, size_t text_count, int32_t norm){
+FloatMatrixW embed_texts(llama_embedder *embedder, const char ** texts, size_t text_count, int32_t norm, EmbedderErrorW* err){
         std::lock_guard<std::mutex> lock(embedder_mutex);
-        try {
-            FloatMatrix fm = embed_c(embedder, texts, text_count, norm);
-            FloatMatrixW fmw;
-            fmw.data = (float*)malloc(fm.rows * fm.cols * sizeof(float));
-            if (fmw.data != nullptr) {
-                std::memcpy(fmw.data, fm.data, fm.rows * fm.cols * sizeof(float));
-                fmw.rows = fm.rows;
-                fmw.cols = fm.cols;
-            }
-            free_float_matrix(&fm);
-            return fmw;
-        } catch (const std::exception &e) {
-            set_last_error(e.what());
-        }
-        return {nullptr, 0, 0};
+        llama_embed_options options = llama_embed_default_options();
+        options.embd_norm = norm;
+        llama_embedder_error error;
+        FloatMatrix fm = embed_e(embedder, texts, text_count, &options, &error);
+        copy_error(error, err);
+        // FloatMatrix and FloatMatrixW have the same layout, the buffer is released with free_float_matrixw
+        return {fm.data, fm.rows, fm.cols};
+}
+
+// embed_texts_cancellable stops once *cancel becomes non-zero, see embed_cancellable_e
+FloatMatrixW embed_texts_cancellable(llama_embedder *embedder, const char ** texts, size_t text_count, int32_t norm,
+                                     const volatile int32_t *cancel, EmbedderErrorW* err){
+        std::lock_guard<std::mutex> lock(embedder_mutex);
+        llama_embed_options options = llama_embed_default_options();
+        options.embd_norm = norm;
+        llama_embedder_error error;
+        FloatMatrix fm = embed_cancellable_e(embedder, texts, text_count, &options, cancel, &error);
+        copy_error(error, err);
+        return {fm.data, fm.rows, fm.cols};
 }
 
 void free_float_matrixw(FloatMatrixW * fm) {
@@ -72,4 +92,74 @@ void free_float_matrixw(FloatMatrixW * fm) {
         }
     }
 }
+IntRaggedMatrixW tokenize_texts(llama_embedder *embedder, const char ** texts, size_t text_count, EmbedderErrorW* err) {
+        std::lock_guard<std::mutex> lock(embedder_mutex);
+        set_error(err, LLAMA_EMBEDDER_OK, "");
+        try {
+            std::vector<std::string> texts_inner(texts, texts + text_count);
+            std::vector<llama_tokenizer_data> output;
+            tokenize(embedder, texts_inner, output);
+            size_t total = 0;
+            for (const auto &t : output) {
+                total += t.tokens.size();
+            }
+            IntRaggedMatrixW im;
+            im.rows = output.size();
+            im.lengths = (size_t*)malloc((im.rows > 0 ? im.rows : 1) * sizeof(size_t));
+            im.data = (int32_t*)malloc((total > 0 ? total : 1) * sizeof(int32_t));
+            if (im.lengths == nullptr || im.data == nullptr) {
+                free(im.lengths);
+                free(im.data);
+                throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_OUT_OF_MEMORY, "failed to allocate memory for tokens");
+            }
+            size_t offset = 0;
+            for (size_t i = 0; i < output.size(); i++) {
+                im.lengths[i] = output[i].tokens.size();
+                std::memcpy(im.data + offset, output[i].tokens.data(), output[i].tokens.size() * sizeof(int32_t));
+                offset += output[i].tokens.size();
+            }
+            return im;
+        } catch (const llama_embedder_exception &e) {
+            set_error(err, e.status, e.what());
+        } catch (const std::exception &e) {
+            set_error(err, LLAMA_EMBEDDER_ERROR_UNKNOWN, e.what());
+        }
+        return {nullptr, nullptr, 0};
+}
+
+TokenEmbeddingsW embed_tokens_texts(llama_embedder *embedder, const char ** texts, size_t text_count, int32_t norm, EmbedderErrorW* err) {
+        std::lock_guard<std::mutex> lock(embedder_mutex);
+        llama_embedder_error error;
+        TokenEmbeddings te = embed_tokens_e(embedder, texts, text_count, norm, &error);
+        copy_error(error, err);
+        // TokenEmbeddings and TokenEmbeddingsW have the same layout, the buffers are released with free_token_embeddingsw
+        return {te.data, te.tokens, te.lengths, te.rows, te.cols};
+}
+
+void free_token_embeddingsw(TokenEmbeddingsW * te) {
+    if (te != nullptr) {
+        TokenEmbeddings inner = {te->data, te->tokens, te->lengths, te->rows, te->cols};
+        free_token_embeddings(&inner);
+        te->data = nullptr;
+        te->tokens = nullptr;
+        te->lengths = nullptr;
+    }
+}
+
+FloatMatrixW rerank_texts(llama_embedder *embedder, const char * query, const char ** documents, size_t document_count, EmbedderErrorW* err) {
+        std::lock_guard<std::mutex> lock(embedder_mutex);
+        llama_embedder_error error;
+        FloatMatrix fm = rerank_e(embedder, query, documents, document_count, &error);
+        copy_error(error, err);
+        return {fm.data, fm.rows, fm.cols};
+}
+
+void free_int_ragged_matrixw(IntRaggedMatrixW * im) {
+    if (im != nullptr) {
+        free(im->data);
+        im->data = nullptr;
+        free(im->lengths);
+        im->lengths = nullptr;
+    }
+}
 }
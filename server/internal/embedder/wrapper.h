@@ -22,11 +22,49 @@ typedef struct {
     size_t cols;
 } FloatMatrixW;
 
-EXPORT_GO_WRAPPER int init_embedder_l(llama_embedder**, const char*, uint32_t);
+typedef struct {
+    int32_t *data;
+    size_t *lengths;
+    size_t rows;
+} IntRaggedMatrixW;
+
+typedef struct {
+    float *data;
+    int32_t *tokens;
+    size_t *lengths;
+    size_t rows;
+    size_t cols;
+} TokenEmbeddingsW;
+
+// EmbedderParamsW mirrors llama_embedder_params
+typedef struct {
+    uint32_t pooling_type;
+    uint32_t n_ctx;
+    uint32_t n_batch;
+    uint32_t n_ubatch;
+    int32_t n_threads;
+    bool use_mmap;
+    bool use_mlock;
+    bool flash_attn;
+} EmbedderParamsW;
+
+// EmbedderErrorW mirrors llama_embedder_error, code is one of the llama_embedder_status values
+typedef struct {
+    int32_t code;
+    char message[512];
+} EmbedderErrorW;
+
+EXPORT_GO_WRAPPER int init_embedder_l(llama_embedder**, const char*, uint32_t, EmbedderErrorW*);
+EXPORT_GO_WRAPPER int init_embedder_with_params_l(llama_embedder**, const char*, const EmbedderParamsW*, EmbedderErrorW*);
 EXPORT_GO_WRAPPER void free_embedder_l(llama_embedder *embedder);
-EXPORT_GO_WRAPPER FloatMatrixW embed_texts(llama_embedder *, const char **, size_t, int32_t);
+EXPORT_GO_WRAPPER FloatMatrixW embed_texts(llama_embedder *, const char **, size_t, int32_t, EmbedderErrorW*);
+EXPORT_GO_WRAPPER FloatMatrixW embed_texts_cancellable(llama_embedder *, const char **, size_t, int32_t, const volatile int32_t *, EmbedderErrorW*);
 EXPORT_GO_WRAPPER void free_float_matrixw(FloatMatrixW * fm);
-EXPORT_GO_WRAPPER const char* get_last_error();
+EXPORT_GO_WRAPPER IntRaggedMatrixW tokenize_texts(llama_embedder *, const char **, size_t, EmbedderErrorW*);
+EXPORT_GO_WRAPPER void free_int_ragged_matrixw(IntRaggedMatrixW * im);
+EXPORT_GO_WRAPPER TokenEmbeddingsW embed_tokens_texts(llama_embedder *, const char **, size_t, int32_t, EmbedderErrorW*);
+EXPORT_GO_WRAPPER void free_token_embeddingsw(TokenEmbeddingsW * te);
+EXPORT_GO_WRAPPER FloatMatrixW rerank_texts(llama_embedder *, const char *, const char **, size_t, EmbedderErrorW*);
 #ifdef __cplusplus
 }
 #endif
@@ -0,0 +1,128 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/quantize"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embedder"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/pb"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/worker"
+)
+
+type embedderServer struct {
+	pb.UnimplementedEmbedderServer
+	svc *service.Service
+}
+
+// NewServer creates a gRPC server exposing the Embedder service and the standard health service.
+// Embeddings are computed with the worker pools and embedding cache of svc.
+func NewServer(svc *service.Service, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	pb.RegisterEmbedderServer(s, &embedderServer{svc: svc})
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(pb.Embedder_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+	return s
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidModel), errors.Is(err, service.ErrInvalidDimensions),
+		errors.Is(err, service.ErrInvalidEncoding), errors.Is(err, embedder.ErrInputTooLong),
+		errors.Is(err, embedder.ErrEmptyInput), errors.Is(err, embedder.ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrModelNotFound), errors.Is(err, embedder.ErrModelNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, embedder.ErrModelLoad), errors.Is(err, embedder.ErrNotSupported):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, embedder.ErrOutOfMemory):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, worker.ErrPoolClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (s *embedderServer) embed(ctx context.Context, req *pb.EmbedRequest) (*pb.EmbedResponse, error) {
+	encoding, err := quantize.ParseEncoding(req.GetEncoding())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	embeddings, hits, err := s.svc.EmbedTexts(ctx, req.GetModel(), req.GetTexts())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if encoding != quantize.EncodingFloat {
+		quantized, err := service.Quantize(req.GetModel(), embeddings, encoding)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		return &pb.EmbedResponse{Encoding: string(encoding), QuantizedEmbeddings: quantized, CacheHits: uint32(hits)}, nil
+	}
+	resp := &pb.EmbedResponse{
+		Embeddings: make([]*pb.Embedding, len(embeddings)),
+		CacheHits:  uint32(hits),
+	}
+	for i, embedding := range embeddings {
+		resp.Embeddings[i] = &pb.Embedding{Values: embedding}
+	}
+	return resp, nil
+}
+
+func (s *embedderServer) Embed(ctx context.Context, req *pb.EmbedRequest) (*pb.EmbedResponse, error) {
+	return s.embed(ctx, req)
+}
+
+func (s *embedderServer) EmbedStream(stream pb.Embedder_EmbedStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp, err := s.embed(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *embedderServer) Tokenize(ctx context.Context, req *pb.TokenizeRequest) (*pb.TokenizeResponse, error) {
+	tokens, err := s.svc.Tokenize(ctx, req.GetModel(), req.GetTexts())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pb.TokenizeResponse{Tokens: make([]*pb.Tokens, len(tokens))}
+	for i, ids := range tokens {
+		resp.Tokens[i] = &pb.Tokens{Ids: ids}
+	}
+	return resp, nil
+}
+
+func (s *embedderServer) ListModels(_ context.Context, _ *pb.ListModelsRequest) (*pb.ListModelsResponse, error) {
+	models, err := service.ListModels()
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to read cache directory")
+	}
+	return &pb.ListModelsResponse{Models: models}, nil
+}
@@ -0,0 +1,66 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	cache2 "github.com/amikos-tech/llamacpp-embedder/server/internal/cache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/pb"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
+)
+
+func newTestClient(t *testing.T) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer(&service.Service{Pools: cache2.NewCache()})
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	t.Cleanup(s.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func TestHealth(t *testing.T) {
+	conn := newTestClient(t)
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: pb.Embedder_ServiceDesc.ServiceName})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+}
+
+func TestListModels(t *testing.T) {
+	conn := newTestClient(t)
+	_, err := pb.NewEmbedderClient(conn).ListModels(context.Background(), &pb.ListModelsRequest{})
+	require.NoError(t, err)
+}
+
+func TestEmbedInvalidModel(t *testing.T) {
+	conn := newTestClient(t)
+	_, err := pb.NewEmbedderClient(conn).Embed(context.Background(), &pb.EmbedRequest{Model: "../model.gguf", Texts: []string{"hello"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = pb.NewEmbedderClient(conn).Embed(context.Background(), &pb.EmbedRequest{Model: "missing-model.gguf", Texts: []string{"hello"}})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestEmbedInvalidEncoding(t *testing.T) {
+	conn := newTestClient(t)
+	_, err := pb.NewEmbedderClient(conn).Embed(context.Background(), &pb.EmbedRequest{Model: "missing-model.gguf", Texts: []string{"hello"}, Encoding: "int4"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,502 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+const (
+	inputFile  = "input.jsonl"
+	outputFile = "output.jsonl"
+	statusFile = "status.json"
+
+	defaultBatchSize = 64
+)
+
+var (
+	ErrJobNotFound     = errors.New("job not found")
+	ErrJobFinished     = errors.New("job already finished")
+	ErrJobNotCompleted = errors.New("job not completed")
+	ErrInvalidInput    = errors.New("invalid input")
+)
+
+// Status is the persisted state and progress of a job
+type Status struct {
+	ID        string    `json:"id"`
+	Model     string    `json:"model"`
+	State     State     `json:"state"`
+	Total     int       `json:"total"`
+	Processed int       `json:"processed"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (s Status) finished() bool {
+	return s.State == StateCompleted || s.State == StateFailed || s.State == StateCancelled
+}
+
+// Record is a single input line of a job
+type Record struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// Result is a single output line of a job
+type Result struct {
+	ID        string    `json:"id"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// EmbedFunc embeds texts with the given model
+type EmbedFunc func(ctx context.Context, model string, texts []string) ([][]float32, error)
+
+// Manager runs embedding jobs one at a time. The input, progress and output of every job are stored in its own
+// directory so that queued and interrupted jobs are resumed when the manager is started again.
+type Manager struct {
+	dir       string
+	embed     EmbedFunc
+	batchSize int
+	statuses  map[string]*Status
+	queue     []string
+	cancel    context.CancelFunc
+	running   string
+	closing   bool
+	wake      chan struct{}
+	close     chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+}
+
+// NewManager creates a manager storing jobs under dir and loads the jobs already present there
+func NewManager(dir string, embed EmbedFunc) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create jobs directory: %v", err)
+	}
+	m := &Manager{
+		dir:       dir,
+		embed:     embed,
+		batchSize: defaultBatchSize,
+		statuses:  make(map[string]*Status),
+		wake:      make(chan struct{}, 1),
+		close:     make(chan struct{}),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name(), statusFile))
+		if err != nil {
+			continue
+		}
+		var status Status
+		if err := json.Unmarshal(data, &status); err != nil || status.ID != entry.Name() {
+			continue
+		}
+		m.statuses[status.ID] = &status
+	}
+	return m, nil
+}
+
+// Start queues the unfinished jobs in creation order and starts processing them
+func (m *Manager) Start() {
+	m.mu.Lock()
+	var pending []*Status
+	for _, status := range m.statuses {
+		if !status.finished() {
+			pending = append(pending, status)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	for _, status := range pending {
+		m.queue = append(m.queue, status.ID)
+	}
+	m.mu.Unlock()
+	m.notify()
+
+	m.wg.Add(1)
+	go m.runner()
+}
+
+// Close stops processing. The running job is interrupted and resumed by the next Start.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closing = true
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.mu.Unlock()
+	close(m.close)
+	m.wg.Wait()
+}
+
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) jobDir(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// saveStatus persists status. The caller must hold m.mu.
+func (m *Manager) saveStatus(status *Status) error {
+	status.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(m.jobDir(status.ID), statusFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Submit stores the JSONL input of {"id", "text"} records and queues a job embedding it with model
+func (m *Manager) Submit(model string, input io.Reader) (Status, error) {
+	id, err := newID()
+	if err != nil {
+		return Status{}, err
+	}
+	dir := m.jobDir(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Status{}, err
+	}
+	total, err := writeInput(filepath.Join(dir, inputFile), input)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return Status{}, err
+	}
+	now := time.Now().UTC()
+	status := &Status{
+		ID:        id,
+		Model:     model,
+		State:     StateQueued,
+		Total:     total,
+		CreatedAt: now,
+	}
+	m.mu.Lock()
+	if err := m.saveStatus(status); err != nil {
+		m.mu.Unlock()
+		_ = os.RemoveAll(dir)
+		return Status{}, err
+	}
+	m.statuses[id] = status
+	m.queue = append(m.queue, id)
+	result := *status
+	m.mu.Unlock()
+	m.notify()
+	return result, nil
+}
+
+// writeInput validates the records read from input and writes them to path, one per line
+func writeInput(path string, input io.Reader) (int, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	dec := json.NewDecoder(input)
+	enc := json.NewEncoder(w)
+	total := 0
+	for {
+		var record Record
+		err := dec.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%w: record %d: %v", ErrInvalidInput, total+1, err)
+		}
+		if record.Text == "" {
+			return 0, fmt.Errorf("%w: record %d: missing text", ErrInvalidInput, total+1)
+		}
+		if err := enc.Encode(record); err != nil {
+			return 0, err
+		}
+		total++
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("%w: no records", ErrInvalidInput)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return total, f.Sync()
+}
+
+// Get returns the status of the job with the given id
+func (m *Manager) Get(id string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.statuses[id]
+	if !ok {
+		return Status{}, ErrJobNotFound
+	}
+	return *status, nil
+}
+
+// List returns the status of all jobs, newest first
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]Status, 0, len(m.statuses))
+	for _, status := range m.statuses {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].CreatedAt.After(statuses[j].CreatedAt)
+	})
+	return statuses
+}
+
+// Cancel cancels a queued or running job. The results embedded so far are kept.
+func (m *Manager) Cancel(id string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.statuses[id]
+	if !ok {
+		return Status{}, ErrJobNotFound
+	}
+	if status.finished() {
+		return *status, ErrJobFinished
+	}
+	status.State = StateCancelled
+	if err := m.saveStatus(status); err != nil {
+		return *status, err
+	}
+	if m.running == id && m.cancel != nil {
+		m.cancel()
+	}
+	return *status, nil
+}
+
+// OpenResults opens the JSONL results of a completed job
+func (m *Manager) OpenResults(id string) (io.ReadCloser, error) {
+	status, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if status.State != StateCompleted {
+		return nil, fmt.Errorf("%w: job is %s", ErrJobNotCompleted, status.State)
+	}
+	return os.Open(filepath.Join(m.jobDir(id), outputFile))
+}
+
+func (m *Manager) runner() {
+	defer m.wg.Done()
+	for {
+		m.mu.Lock()
+		var id string
+		if len(m.queue) > 0 {
+			id = m.queue[0]
+			m.queue = m.queue[1:]
+		}
+		m.mu.Unlock()
+		if id == "" {
+			select {
+			case <-m.wake:
+				continue
+			case <-m.close:
+				return
+			}
+		}
+		m.run(id)
+		select {
+		case <-m.close:
+			return
+		default:
+		}
+	}
+}
+
+func (m *Manager) run(id string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.mu.Lock()
+	status, ok := m.statuses[id]
+	if !ok || status.finished() || m.closing {
+		m.mu.Unlock()
+		return
+	}
+	status.State = StateRunning
+	_ = m.saveStatus(status)
+	m.running = id
+	m.cancel = cancel
+	model := status.Model
+	m.mu.Unlock()
+
+	err := m.process(ctx, id, model)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running = ""
+	m.cancel = nil
+	switch {
+	case status.State == StateCancelled:
+	case err != nil && m.closing:
+		// interrupted by Close, stays running and is resumed on the next Start
+	case err != nil:
+		status.State = StateFailed
+		status.Error = err.Error()
+		_ = m.saveStatus(status)
+	default:
+		status.State = StateCompleted
+		_ = m.saveStatus(status)
+	}
+}
+
+// process embeds the records of the job that are not yet in its output file
+func (m *Manager) process(ctx context.Context, id, model string) error {
+	dir := m.jobDir(id)
+	out, err := os.OpenFile(filepath.Join(dir, outputFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	done, err := recoverOutput(out)
+	if err != nil {
+		return err
+	}
+	m.setProcessed(id, done)
+
+	in, err := os.Open(filepath.Join(dir, inputFile))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	dec := json.NewDecoder(bufio.NewReader(in))
+	for i := 0; i < done; i++ {
+		var record Record
+		if err := dec.Decode(&record); err != nil {
+			return fmt.Errorf("failed to skip processed records: %v", err)
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch := make([]Record, 0, m.batchSize)
+		for len(batch) < m.batchSize {
+			var record Record
+			err := dec.Decode(&record)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read input: %v", err)
+			}
+			batch = append(batch, record)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		texts := make([]string, len(batch))
+		for i, record := range batch {
+			texts[i] = record.Text
+		}
+		embeddings, err := m.embed(ctx, model, texts)
+		if err != nil {
+			return err
+		}
+		if len(embeddings) != len(batch) {
+			return fmt.Errorf("expected %d embeddings, got %d", len(batch), len(embeddings))
+		}
+		for i, record := range batch {
+			if err := enc.Encode(Result{ID: record.ID, Embedding: embeddings[i]}); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := out.Sync(); err != nil {
+			return err
+		}
+		done += len(batch)
+		m.setProcessed(id, done)
+	}
+}
+
+func (m *Manager) setProcessed(id string, processed int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if status, ok := m.statuses[id]; ok {
+		status.Processed = processed
+		_ = m.saveStatus(status)
+	}
+}
+
+// recoverOutput counts the complete lines of the output file, drops a partially written last line and positions
+// the file for appending
+func recoverOutput(out *os.File) (int, error) {
+	lines := 0
+	var end, offset int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := out.Read(buf)
+		for i := 0; i < n; i++ {
+			if buf[i] == '\n' {
+				lines++
+				end = offset + int64(i) + 1
+			}
+		}
+		offset += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := out.Truncate(end); err != nil {
+		return 0, err
+	}
+	if _, err := out.Seek(end, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return lines, nil
+}
@@ -0,0 +1,184 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fakeEmbed(_ context.Context, _ string, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = []float32{float32(len(text))}
+	}
+	return embeddings, nil
+}
+
+func input(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		record, _ := json.Marshal(Record{ID: "id-" + strings.Repeat("x", i), Text: strings.Repeat("a", i+1)})
+		sb.Write(record)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func waitForState(t *testing.T, m *Manager, id string, state State) Status {
+	var status Status
+	require.Eventually(t, func() bool {
+		var err error
+		status, err = m.Get(id)
+		require.NoError(t, err)
+		return status.State == state
+	}, 5*time.Second, 10*time.Millisecond)
+	return status
+}
+
+func readResults(t *testing.T, m *Manager, id string) []Result {
+	rc, err := m.OpenResults(id)
+	require.NoError(t, err)
+	defer rc.Close()
+	dec := json.NewDecoder(rc)
+	var results []Result
+	for dec.More() {
+		var result Result
+		require.NoError(t, dec.Decode(&result))
+		results = append(results, result)
+	}
+	return results
+}
+
+func TestJobCompletes(t *testing.T) {
+	m, err := NewManager(t.TempDir(), fakeEmbed)
+	require.NoError(t, err)
+	m.batchSize = 3
+	m.Start()
+	t.Cleanup(m.Close)
+
+	status, err := m.Submit("model.gguf", strings.NewReader(input(10)))
+	require.NoError(t, err)
+	require.Equal(t, 10, status.Total)
+	require.Equal(t, StateQueued, status.State)
+
+	status = waitForState(t, m, status.ID, StateCompleted)
+	require.Equal(t, 10, status.Processed)
+	results := readResults(t, m, status.ID)
+	require.Len(t, results, 10)
+	for i, result := range results {
+		require.Equal(t, []float32{float32(i + 1)}, result.Embedding)
+	}
+	require.Len(t, m.List(), 1)
+}
+
+func TestSubmitInvalidInput(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir, fakeEmbed)
+	require.NoError(t, err)
+	_, err = m.Submit("model.gguf", strings.NewReader("{\"id\": \"1\", \"text\": \"a\"}\n{broken"))
+	require.ErrorIs(t, err, ErrInvalidInput)
+	_, err = m.Submit("model.gguf", strings.NewReader("{\"id\": \"1\"}\n"))
+	require.ErrorIs(t, err, ErrInvalidInput)
+	_, err = m.Submit("model.gguf", strings.NewReader(""))
+	require.ErrorIs(t, err, ErrInvalidInput)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries, "rejected jobs should not leave files behind")
+}
+
+func TestJobFails(t *testing.T) {
+	m, err := NewManager(t.TempDir(), func(context.Context, string, []string) ([][]float32, error) {
+		return nil, errors.New("boom")
+	})
+	require.NoError(t, err)
+	m.Start()
+	t.Cleanup(m.Close)
+	status, err := m.Submit("model.gguf", strings.NewReader(input(2)))
+	require.NoError(t, err)
+	status = waitForState(t, m, status.ID, StateFailed)
+	require.Equal(t, "boom", status.Error)
+	_, err = m.OpenResults(status.ID)
+	require.ErrorIs(t, err, ErrJobNotCompleted)
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	started := make(chan struct{}, 1)
+	m, err := NewManager(t.TempDir(), func(ctx context.Context, _ string, _ []string) ([][]float32, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	require.NoError(t, err)
+	m.Start()
+	t.Cleanup(m.Close)
+	status, err := m.Submit("model.gguf", strings.NewReader(input(2)))
+	require.NoError(t, err)
+	<-started
+	status, err = m.Cancel(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateCancelled, status.State)
+	waitForState(t, m, status.ID, StateCancelled)
+	_, err = m.Cancel(status.ID)
+	require.ErrorIs(t, err, ErrJobFinished)
+	_, err = m.Cancel("missing")
+	require.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestJobResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	var calls atomic.Int32
+	blocked := make(chan struct{})
+	first, err := NewManager(dir, func(ctx context.Context, model string, texts []string) ([][]float32, error) {
+		if calls.Add(1) > 1 {
+			close(blocked)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return fakeEmbed(ctx, model, texts)
+	})
+	require.NoError(t, err)
+	first.batchSize = 2
+	first.Start()
+	status, err := first.Submit("model.gguf", strings.NewReader(input(5)))
+	require.NoError(t, err)
+	<-blocked
+	first.Close()
+
+	interrupted, err := first.Get(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateRunning, interrupted.State)
+	require.Equal(t, 2, interrupted.Processed)
+
+	// simulate a crash in the middle of writing a result line
+	out, err := os.OpenFile(filepath.Join(dir, status.ID, outputFile), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = io.WriteString(out, "{\"id\": \"partial")
+	require.NoError(t, err)
+	require.NoError(t, out.Close())
+
+	var embedded atomic.Int32
+	second, err := NewManager(dir, func(ctx context.Context, model string, texts []string) ([][]float32, error) {
+		embedded.Add(int32(len(texts)))
+		return fakeEmbed(ctx, model, texts)
+	})
+	require.NoError(t, err)
+	second.batchSize = 2
+	second.Start()
+	t.Cleanup(second.Close)
+	waitForState(t, second, status.ID, StateCompleted)
+	require.Equal(t, int32(3), embedded.Load(), "only the remaining records should be embedded")
+	results := readResults(t, second, status.ID)
+	require.Len(t, results, 5)
+	for i, result := range results {
+		require.Equal(t, []float32{float32(i + 1)}, result.Embedding)
+	}
+}
@@ -3,22 +3,53 @@ package middleware
 import (
 	"context"
 	cache2 "github.com/amikos-tech/llamacpp-embedder/server/internal/cache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/collections"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embcache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/jobs"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/service"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/warmup"
 	"net/http"
 )
 
 var cache *cache2.Cache
+var embeddingCache *embcache.Cache
+var svc *service.Service
+var jobManager *jobs.Manager
+var collectionManager *collections.Manager
+var warmupTracker = warmup.NewTracker()
 
 type contextKey string
 
 const CacheKey contextKey = "cache"
+const EmbeddingCacheKey contextKey = "embedding_cache"
+const ServiceKey contextKey = "service"
+const JobsKey contextKey = "jobs"
+const CollectionsKey contextKey = "collections"
+const WarmupKey contextKey = "warmup"
 
-func init() {
-	cache = cache2.NewCache()
+// Configure sets the service and managers CachingMiddleware adds to the request context. They are built by main once
+// the cache directory exists, CachingMiddleware must not serve requests before.
+func Configure(s *service.Service, j *jobs.Manager, c *collections.Manager) {
+	cache = s.Pools
+	embeddingCache = s.EmbeddingCache
+	svc = s
+	jobManager = j
+	collectionManager = c
+}
+
+// GetWarmup returns the tracker of the models warmed up at startup
+func GetWarmup() *warmup.Tracker {
+	return warmupTracker
 }
 
 func CachingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := context.WithValue(r.Context(), CacheKey, cache)
+		ctx = context.WithValue(ctx, EmbeddingCacheKey, embeddingCache)
+		ctx = context.WithValue(ctx, ServiceKey, svc)
+		ctx = context.WithValue(ctx, JobsKey, jobManager)
+		ctx = context.WithValue(ctx, CollectionsKey, collectionManager)
+		ctx = context.WithValue(ctx, WarmupKey, warmupTracker)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -0,0 +1,242 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.0
+// source: embedder.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Embedder_Embed_FullMethodName       = "/embedder.v1.Embedder/Embed"
+	Embedder_EmbedStream_FullMethodName = "/embedder.v1.Embedder/EmbedStream"
+	Embedder_Tokenize_FullMethodName    = "/embedder.v1.Embedder/Tokenize"
+	Embedder_ListModels_FullMethodName  = "/embedder.v1.Embedder/ListModels"
+)
+
+// EmbedderClient is the client API for Embedder service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Embedder serves the same models, worker pools and embedding cache as the HTTP API.
+type EmbedderClient interface {
+	// Embed embeds a batch of texts.
+	Embed(ctx context.Context, in *EmbedRequest, opts ...grpc.CallOption) (*EmbedResponse, error)
+	// EmbedStream embeds each request on the stream and answers with one response per request, in order.
+	EmbedStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EmbedRequest, EmbedResponse], error)
+	// Tokenize returns the token ids of each text.
+	Tokenize(ctx context.Context, in *TokenizeRequest, opts ...grpc.CallOption) (*TokenizeResponse, error)
+	// ListModels lists the models available in the model cache directory.
+	ListModels(ctx context.Context, in *ListModelsRequest, opts ...grpc.CallOption) (*ListModelsResponse, error)
+}
+
+type embedderClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEmbedderClient(cc grpc.ClientConnInterface) EmbedderClient {
+	return &embedderClient{cc}
+}
+
+func (c *embedderClient) Embed(ctx context.Context, in *EmbedRequest, opts ...grpc.CallOption) (*EmbedResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EmbedResponse)
+	err := c.cc.Invoke(ctx, Embedder_Embed_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *embedderClient) EmbedStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EmbedRequest, EmbedResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Embedder_ServiceDesc.Streams[0], Embedder_EmbedStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[EmbedRequest, EmbedResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Embedder_EmbedStreamClient = grpc.BidiStreamingClient[EmbedRequest, EmbedResponse]
+
+func (c *embedderClient) Tokenize(ctx context.Context, in *TokenizeRequest, opts ...grpc.CallOption) (*TokenizeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TokenizeResponse)
+	err := c.cc.Invoke(ctx, Embedder_Tokenize_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *embedderClient) ListModels(ctx context.Context, in *ListModelsRequest, opts ...grpc.CallOption) (*ListModelsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListModelsResponse)
+	err := c.cc.Invoke(ctx, Embedder_ListModels_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EmbedderServer is the server API for Embedder service.
+// All implementations must embed UnimplementedEmbedderServer
+// for forward compatibility.
+//
+// Embedder serves the same models, worker pools and embedding cache as the HTTP API.
+type EmbedderServer interface {
+	// Embed embeds a batch of texts.
+	Embed(context.Context, *EmbedRequest) (*EmbedResponse, error)
+	// EmbedStream embeds each request on the stream and answers with one response per request, in order.
+	EmbedStream(grpc.BidiStreamingServer[EmbedRequest, EmbedResponse]) error
+	// Tokenize returns the token ids of each text.
+	Tokenize(context.Context, *TokenizeRequest) (*TokenizeResponse, error)
+	// ListModels lists the models available in the model cache directory.
+	ListModels(context.Context, *ListModelsRequest) (*ListModelsResponse, error)
+	mustEmbedUnimplementedEmbedderServer()
+}
+
+// UnimplementedEmbedderServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedEmbedderServer struct{}
+
+func (UnimplementedEmbedderServer) Embed(context.Context, *EmbedRequest) (*EmbedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Embed not implemented")
+}
+func (UnimplementedEmbedderServer) EmbedStream(grpc.BidiStreamingServer[EmbedRequest, EmbedResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method EmbedStream not implemented")
+}
+func (UnimplementedEmbedderServer) Tokenize(context.Context, *TokenizeRequest) (*TokenizeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Tokenize not implemented")
+}
+func (UnimplementedEmbedderServer) ListModels(context.Context, *ListModelsRequest) (*ListModelsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListModels not implemented")
+}
+func (UnimplementedEmbedderServer) mustEmbedUnimplementedEmbedderServer() {}
+func (UnimplementedEmbedderServer) testEmbeddedByValue()                  {}
+
+// UnsafeEmbedderServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EmbedderServer will
+// result in compilation errors.
+type UnsafeEmbedderServer interface {
+	mustEmbedUnimplementedEmbedderServer()
+}
+
+func RegisterEmbedderServer(s grpc.ServiceRegistrar, srv EmbedderServer) {
+	// If the following call pancis, it indicates UnimplementedEmbedderServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Embedder_ServiceDesc, srv)
+}
+
+func _Embedder_Embed_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmbedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EmbedderServer).Embed(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Embedder_Embed_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EmbedderServer).Embed(ctx, req.(*EmbedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Embedder_EmbedStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EmbedderServer).EmbedStream(&grpc.GenericServerStream[EmbedRequest, EmbedResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Embedder_EmbedStreamServer = grpc.BidiStreamingServer[EmbedRequest, EmbedResponse]
+
+func _Embedder_Tokenize_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokenizeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EmbedderServer).Tokenize(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Embedder_Tokenize_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EmbedderServer).Tokenize(ctx, req.(*TokenizeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Embedder_ListModels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListModelsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EmbedderServer).ListModels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Embedder_ListModels_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EmbedderServer).ListModels(ctx, req.(*ListModelsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Embedder_ServiceDesc is the grpc.ServiceDesc for Embedder service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Embedder_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "embedder.v1.Embedder",
+	HandlerType: (*EmbedderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Embed",
+			Handler:    _Embedder_Embed_Handler,
+		},
+		{
+			MethodName: "Tokenize",
+			Handler:    _Embedder_Tokenize_Handler,
+		},
+		{
+			MethodName: "ListModels",
+			Handler:    _Embedder_ListModels_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "EmbedStream",
+			Handler:       _Embedder_EmbedStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "embedder.proto",
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sync"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/gguf"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embedder"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+)
+
+var ErrInvalidDimensions = errors.New("invalid dimensions")
+
+// embeddingLengths caches the embedding length read from the metadata of the models, by model file identity
+var embeddingLengths sync.Map
+
+// ValidateDimensions checks dimensions against the embedding length in the metadata of model, so that an invalid value
+// is rejected before any text is embedded. Models without an embedding length in their metadata are checked by
+// TruncateEmbeddings once embedded.
+func ValidateDimensions(model string, dimensions int) error {
+	if dimensions < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidDimensions, dimensions)
+	}
+	if dimensions == 0 {
+		return nil
+	}
+	identity, err := modelIdentity(model)
+	if err != nil {
+		return err
+	}
+	length, found := embeddingLengths.Load(identity)
+	if !found {
+		n, err := gguf.EmbeddingLength(filepath.Join(utils.GetModelCacheDir(), model))
+		if err != nil {
+			return nil
+		}
+		length, _ = embeddingLengths.LoadOrStore(identity, n)
+	}
+	if dimensions > length.(int) {
+		return fmt.Errorf("%w: %d exceeds the embedding length %d of the model", ErrInvalidDimensions, dimensions, length)
+	}
+	return nil
+}
+
+// TruncateEmbeddings shortens Matryoshka embeddings to their first dimensions values and normalizes them again.
+// The pools embed with L2 normalization, which does not depend on the scale of the input, so truncating a normalized
+// vector and normalizing it again gives the same result as normalizing the truncated raw vector.
+// A dimensions of 0 leaves the embeddings unchanged.
+func TruncateEmbeddings(embeddings [][]float32, dimensions int) ([][]float32, error) {
+	if dimensions < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidDimensions, dimensions)
+	}
+	if dimensions == 0 {
+		return embeddings, nil
+	}
+	truncated := make([][]float32, len(embeddings))
+	for i, embedding := range embeddings {
+		if dimensions > len(embedding) {
+			return nil, fmt.Errorf("%w: %d exceeds the embedding length %d of the model", ErrInvalidDimensions, dimensions, len(embedding))
+		}
+		truncated[i] = append([]float32(nil), embedding[:dimensions]...)
+		normalize(truncated[i], Normalization)
+	}
+	return truncated, nil
+}
+
+// normalize scales v in place the same way llama.cpp's llama_embd_normalize does
+func normalize(v []float32, norm embedder.NormalizationType) {
+	var sum float64
+	switch norm {
+	case embedder.NormalizationNone:
+		return
+	case embedder.NormalizationMaxAbsInt16:
+		for _, x := range v {
+			sum = math.Max(sum, math.Abs(float64(x)))
+		}
+		sum /= 32760.0 // int16 range
+	case embedder.NormalizationL2:
+		for _, x := range v {
+			sum += float64(x) * float64(x)
+		}
+		sum = math.Sqrt(sum)
+	default: // p-norm, NormalizationTaxicab is p=1
+		p := float64(norm)
+		for _, x := range v {
+			sum += math.Pow(math.Abs(float64(x)), p)
+		}
+		sum = math.Pow(sum, 1.0/p)
+	}
+	var scale float32
+	if sum > 0 {
+		scale = float32(1.0 / sum)
+	}
+	for i := range v {
+		v[i] *= scale
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateEmbeddings(t *testing.T) {
+	embeddings, err := TruncateEmbeddings([][]float32{{0.6, 0.0, 0.8}}, 2)
+	require.NoError(t, err)
+	require.InDeltaSlice(t, []float32{1, 0}, embeddings[0], 1e-6)
+
+	unchanged, err := TruncateEmbeddings([][]float32{{0.6, 0.8}}, 0)
+	require.NoError(t, err)
+	require.Equal(t, []float32{0.6, 0.8}, unchanged[0])
+
+	_, err = TruncateEmbeddings([][]float32{{0.6, 0.8}}, 3)
+	require.ErrorIs(t, err, ErrInvalidDimensions)
+	_, err = TruncateEmbeddings([][]float32{{0.6, 0.8}}, -1)
+	require.ErrorIs(t, err, ErrInvalidDimensions)
+}
+
+// writeModelHeader writes a GGUF file holding only the metadata of a bert model with the given embedding length
+func writeModelHeader(t *testing.T, name string, embeddingLength uint32) {
+	var b bytes.Buffer
+	writeString := func(s string) {
+		_ = binary.Write(&b, binary.LittleEndian, uint64(len(s)))
+		b.WriteString(s)
+	}
+	b.WriteString("GGUF")
+	_ = binary.Write(&b, binary.LittleEndian, []uint32{3})
+	_ = binary.Write(&b, binary.LittleEndian, []uint64{0, 2})
+	writeString("general.architecture")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(8)) // string
+	writeString("bert")
+	writeString("bert.embedding_length")
+	_ = binary.Write(&b, binary.LittleEndian, []uint32{4, embeddingLength}) // uint32
+	path := filepath.Join(utils.GetModelCacheDir(), name)
+	require.NoError(t, os.WriteFile(path, b.Bytes(), 0644))
+	t.Cleanup(func() { _ = os.Remove(path) })
+}
+
+func TestValidateDimensions(t *testing.T) {
+	writeModelHeader(t, "dimensions-test.gguf", 384)
+
+	require.NoError(t, ValidateDimensions("dimensions-test.gguf", 0))
+	require.NoError(t, ValidateDimensions("dimensions-test.gguf", 384))
+	require.NoError(t, ValidateDimensions("dimensions-test.gguf", 256))
+	require.ErrorIs(t, ValidateDimensions("dimensions-test.gguf", 512), ErrInvalidDimensions)
+	require.ErrorIs(t, ValidateDimensions("dimensions-test.gguf", -1), ErrInvalidDimensions)
+	require.ErrorIs(t, ValidateDimensions("missing.gguf", 256), ErrModelNotFound)
+}
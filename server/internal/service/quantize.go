@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/quantize"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+)
+
+// ErrInvalidEncoding is the error of Quantize for encodings that are not quantized
+var ErrInvalidEncoding = errors.New("invalid encoding")
+
+// Quantize encodes embeddings of model with encoding, each as its packed bytes: one two's complement int8 per
+// dimension (int8), or the sign bits packed into bytes (binary as two's complement int8, ubinary unsigned). int8
+// quantization uses the calibration file of the model when present and the [-1, 1] range of L2 normalized embeddings
+// otherwise.
+func Quantize(model string, embeddings [][]float32, encoding quantize.Encoding) ([][]byte, error) {
+	switch encoding {
+	case quantize.EncodingInt8:
+		if len(embeddings) == 0 {
+			return [][]byte{}, nil
+		}
+		calibration, err := loadCalibration(model, len(embeddings[0]))
+		if err != nil {
+			return nil, err
+		}
+		quantized, err := quantize.QuantizeInt8(embeddings, calibration)
+		if err != nil {
+			return nil, err
+		}
+		return toBytes(quantized), nil
+	case quantize.EncodingBinary:
+		return toBytes(quantize.QuantizeBinary(embeddings)), nil
+	case quantize.EncodingUbinary:
+		return quantize.QuantizeUbinary(embeddings), nil
+	default:
+		return nil, fmt.Errorf("%w: %s is not quantized", ErrInvalidEncoding, encoding)
+	}
+}
+
+func loadCalibration(model string, dims int) (*quantize.Calibration, error) {
+	calibration, err := quantize.LoadCalibration(quantize.CalibrationPath(filepath.Join(utils.GetModelCacheDir(), model)))
+	if os.IsNotExist(err) {
+		return quantize.UnitCalibration(dims), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Matryoshka embeddings truncated with dimensions use the ranges of their leading dimensions
+	if len(calibration.Min) > dims {
+		calibration.Min = calibration.Min[:dims]
+		calibration.Max = calibration.Max[:dims]
+	}
+	return calibration, nil
+}
+
+// toBytes reinterprets int8 vectors as their two's complement bytes
+func toBytes(vectors [][]int8) [][]byte {
+	out := make([][]byte, len(vectors))
+	for i, v := range vectors {
+		out[i] = make([]byte, len(v))
+		for j, x := range v {
+			out[i][j] = byte(x)
+		}
+	}
+	return out
+}
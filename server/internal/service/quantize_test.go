@@ -0,0 +1,46 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/quantize"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+)
+
+func TestQuantize(t *testing.T) {
+	embeddings := [][]float32{{1, -1, 0.5, -0.5, 0, 0, 0, 0.1}}
+
+	int8s, err := Quantize("missing-calibration.gguf", embeddings, quantize.EncodingInt8)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{127, 0x80, 63, 0xc0, 0, 0, 0, 12}}, int8s, "int8 values are their two's complement bytes")
+
+	ubinary, err := Quantize("missing-calibration.gguf", embeddings, quantize.EncodingUbinary)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0b10100001}}, ubinary)
+
+	binary, err := Quantize("missing-calibration.gguf", embeddings, quantize.EncodingBinary)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0b00100001}}, binary)
+
+	_, err = Quantize("missing-calibration.gguf", embeddings, quantize.EncodingFloat)
+	require.ErrorIs(t, err, ErrInvalidEncoding)
+}
+
+func TestQuantizeTruncatedWithCalibration(t *testing.T) {
+	path := quantize.CalibrationPath(filepath.Join(utils.GetModelCacheDir(), "calibrated-test.gguf"))
+	calibration := &quantize.Calibration{
+		Min: []float32{-2, -2, -2, -2, -1, -1, -1, -1},
+		Max: []float32{2, 2, 2, 2, 1, 1, 1, 1},
+	}
+	require.NoError(t, calibration.Save(path))
+	t.Cleanup(func() { _ = os.Remove(path) })
+
+	// embeddings truncated with dimensions are quantized with the ranges of their leading dimensions
+	int8s, err := Quantize("calibrated-test.gguf", [][]float32{{2, -2, 0.5, 1}}, quantize.EncodingInt8)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{127, 0x80, 31, 63}}, int8s)
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cache2 "github.com/amikos-tech/llamacpp-embedder/server/internal/cache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embcache"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embedder"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/worker"
+)
+
+const DefaultPoolWorkers = 5
+
+// Pooling and Normalization are the settings the worker pools embed with
+const (
+	Pooling       = embedder.PoolingMean
+	Normalization = embedder.NormalizationL2
+)
+
+var (
+	ErrInvalidModel  = errors.New("invalid model")
+	ErrModelNotFound = errors.New("model not found")
+)
+
+// Service embeds texts using the worker pools and the embedding cache shared by the HTTP and gRPC APIs
+type Service struct {
+	Pools          *cache2.Cache
+	EmbeddingCache *embcache.Cache
+	// EmbedderOptions identifies the settings the worker pools create their embedders with. It is part of the
+	// embedding cache key, so that embeddings cached with other settings are not served.
+	EmbedderOptions string
+}
+
+// IsValidModelName checks that model is a bare .gguf file name inside the model cache directory
+func IsValidModelName(model string) bool {
+	return strings.HasSuffix(strings.ToLower(model), ".gguf") && !strings.Contains(model, "/") && !strings.Contains(model, "\\") && !strings.Contains(model, "..")
+}
+
+// ListModels lists the .gguf files in the model cache directory
+func ListModels() ([]string, error) {
+	files, err := os.ReadDir(utils.GetModelCacheDir())
+	if err != nil {
+		return nil, err
+	}
+	var ggufFiles []string
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".gguf" {
+			ggufFiles = append(ggufFiles, file.Name())
+		}
+	}
+	return ggufFiles, nil
+}
+
+// responseError returns the error of a worker response, if any
+func responseError(resp *types.EmbedResponse) error {
+	if resp.Err != nil {
+		return resp.Err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// submit submits job to pool with ctx and waits for its response, or until ctx is done
+func submit(ctx context.Context, pool *worker.Pool, job worker.Job) *types.EmbedResponse {
+	// buffered so that a worker finishing the job after ctx is done does not block on the response
+	job.Response = make(chan *types.EmbedResponse, 1)
+	job.Context = ctx
+	pool.Submit(job)
+	select {
+	case resp := <-job.Response:
+		return resp
+	case <-ctx.Done():
+		return &types.EmbedResponse{Error: ctx.Err().Error(), Err: ctx.Err()}
+	}
+}
+
+func (s *Service) pool(model string) (*worker.Pool, error) {
+	if s.Pools == nil {
+		return nil, fmt.Errorf("cache not found")
+	}
+	pool, err := s.Pools.GetOrCreateWorkerPool(model, DefaultPoolWorkers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get or create worker pool: %w", err)
+	}
+	return pool, nil
+}
+
+// warmupText is embedded by Warmup to run a first batch through a freshly loaded pool
+const warmupText = "warmup"
+
+// Warmup loads the worker pool of model, waits until every worker has loaded the model and embeds a test text. The
+// pool of a warmed up model is kept loaded when idle; a pool that fails to load is unloaded, so that the next request
+// loads it again.
+func (s *Service) Warmup(ctx context.Context, model string) error {
+	if err := ValidateModel(model); err != nil {
+		return err
+	}
+	if s.Pools == nil {
+		return fmt.Errorf("cache not found")
+	}
+	pool, err := s.Pools.GetOrCreatePinnedWorkerPool(model, DefaultPoolWorkers)
+	if err != nil {
+		return fmt.Errorf("failed to get or create worker pool: %w", err)
+	}
+	if err := pool.WaitLoaded(ctx); err != nil {
+		_ = s.Pools.Unload(model, cache2.KindEmbed)
+		return err
+	}
+	return responseError(submit(ctx, pool, worker.Job{
+		Request: &types.EmbedRequest{Model: model, Texts: []string{warmupText}},
+	}))
+}
+
+// ValidateModel checks that model is a valid model name present in the model cache directory
+func ValidateModel(model string) error {
+	_, err := modelIdentity(model)
+	return err
+}
+
+// ModelIdentity returns the identity of the model file in the model cache directory, see embcache.FileIdentity
+func ModelIdentity(model string) (string, error) {
+	return modelIdentity(model)
+}
+
+func modelIdentity(model string) (string, error) {
+	if !IsValidModelName(model) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidModel, model)
+	}
+	identity, err := embcache.FileIdentity(filepath.Join(utils.GetModelCacheDir(), model))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrModelNotFound, model)
+	}
+	return identity, nil
+}
+
+// EmbedTexts embeds texts with the given model. Embeddings found in the embedding cache are served without
+// submitting a job to the worker pool; only the misses are embedded. It returns the number of cache hits.
+func (s *Service) EmbedTexts(ctx context.Context, model string, texts []string) ([][]float32, int, error) {
+	return s.EmbedTextsWithPriority(ctx, model, texts, worker.PriorityNormal)
+}
+
+// EmbedTextsWithPriority is like EmbedTexts but submits the cache misses to the worker pool with the given priority
+func (s *Service) EmbedTextsWithPriority(ctx context.Context, model string, texts []string, priority worker.Priority) ([][]float32, int, error) {
+	identity, err := modelIdentity(model)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	embeddings := make([][]float32, len(texts))
+	keys := make([]embcache.Key, len(texts))
+	var misses []int
+	for i, text := range texts {
+		if s.EmbeddingCache == nil {
+			misses = append(misses, i)
+			continue
+		}
+		keys[i] = embcache.NewKey(identity, s.EmbedderOptions, int32(Pooling), int32(Normalization), text)
+		if embedding, found := s.EmbeddingCache.Get(keys[i]); found {
+			embeddings[i] = embedding
+		} else {
+			misses = append(misses, i)
+		}
+	}
+	hits := len(texts) - len(misses)
+	if len(misses) == 0 {
+		return embeddings, hits, nil
+	}
+
+	pool, err := s.pool(model)
+	if err != nil {
+		return nil, hits, err
+	}
+	missTexts := make([]string, len(misses))
+	for j, i := range misses {
+		missTexts[j] = texts[i]
+	}
+	resp := submit(ctx, pool, worker.Job{
+		Request:  &types.EmbedRequest{Model: model, Texts: missTexts},
+		Priority: priority,
+	})
+	if err := responseError(resp); err != nil {
+		return nil, hits, err
+	}
+	if len(resp.Embeddings) != len(missTexts) {
+		return nil, hits, fmt.Errorf("expected %d embeddings, got %d", len(missTexts), len(resp.Embeddings))
+	}
+	for j, i := range misses {
+		embeddings[i] = resp.Embeddings[j]
+		if s.EmbeddingCache != nil {
+			s.EmbeddingCache.Put(keys[i], resp.Embeddings[j])
+		}
+	}
+	return embeddings, hits, nil
+}
+
+// Tokenize returns the token ids of texts using the given model
+func (s *Service) Tokenize(ctx context.Context, model string, texts []string) ([][]int32, error) {
+	if _, err := modelIdentity(model); err != nil {
+		return nil, err
+	}
+	if len(texts) == 0 {
+		return [][]int32{}, nil
+	}
+	pool, err := s.pool(model)
+	if err != nil {
+		return nil, err
+	}
+	resp := submit(ctx, pool, worker.Job{
+		Request: &types.EmbedRequest{Model: model, Texts: texts},
+		Type:    worker.JobTokenize,
+	})
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	return resp.Tokens, nil
+}
+
+// Rerank returns the relevance score of each of docs for query, in the order of docs, using a reranker model loaded
+// with rank pooling
+func (s *Service) Rerank(ctx context.Context, model string, query string, docs []string) ([]float32, error) {
+	if _, err := modelIdentity(model); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return []float32{}, nil
+	}
+	if s.Pools == nil {
+		return nil, fmt.Errorf("cache not found")
+	}
+	pool, err := s.Pools.GetOrCreateRerankPool(model, DefaultPoolWorkers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get or create worker pool: %w", err)
+	}
+	resp := submit(ctx, pool, worker.Job{
+		Request: &types.EmbedRequest{Model: model, Texts: docs},
+		Type:    worker.JobRerank,
+		Query:   query,
+	})
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Scores) != len(docs) {
+		return nil, fmt.Errorf("expected %d scores, got %d", len(docs), len(resp.Scores))
+	}
+	return resp.Scores, nil
+}
+
+// EmbedTokens returns the embedding of every token of each text and the token ids, using the model loaded without
+// pooling. Token embeddings are not cached.
+func (s *Service) EmbedTokens(ctx context.Context, model string, texts []string) ([]types.TokenEmbeddings, error) {
+	if _, err := modelIdentity(model); err != nil {
+		return nil, err
+	}
+	if len(texts) == 0 {
+		return []types.TokenEmbeddings{}, nil
+	}
+	if s.Pools == nil {
+		return nil, fmt.Errorf("cache not found")
+	}
+	pool, err := s.Pools.GetOrCreateTokenPool(model, DefaultPoolWorkers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get or create worker pool: %w", err)
+	}
+	resp := submit(ctx, pool, worker.Job{
+		Request: &types.EmbedRequest{Model: model, Texts: texts},
+		Type:    worker.JobEmbedTokens,
+	})
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	if len(resp.TokenEmbeddings) != len(texts) {
+		return nil, fmt.Errorf("expected token embeddings of %d texts, got %d", len(texts), len(resp.TokenEmbeddings))
+	}
+	return resp.TokenEmbeddings, nil
+}
@@ -0,0 +1,130 @@
+package similarity
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Metric is a vector similarity or distance measure
+type Metric string
+
+const (
+	Cosine    Metric = "cosine"
+	Dot       Metric = "dot"
+	Euclidean Metric = "euclidean"
+)
+
+// ParseMetric parses a metric name. An empty name selects Cosine.
+func ParseMetric(name string) (Metric, error) {
+	switch Metric(strings.ToLower(name)) {
+	case "", Cosine:
+		return Cosine, nil
+	case Dot:
+		return Dot, nil
+	case Euclidean:
+		return Euclidean, nil
+	default:
+		return "", fmt.Errorf("unknown metric %q, must be one of cosine, dot, euclidean", name)
+	}
+}
+
+// HigherIsBetter reports whether larger scores mean more similar vectors. Euclidean scores are distances.
+func (m Metric) HigherIsBetter() bool {
+	return m != Euclidean
+}
+
+// Scorer computes the metric between two vectors of the same length
+type Scorer func(a, b []float32) float32
+
+// Scorer returns the scoring function of the metric. When the vectors are known to be L2 normalized, cosine
+// similarity is computed as a plain dot product, which makes cosine and dot scores identical.
+func (m Metric) Scorer(l2Normalized bool) Scorer {
+	switch m {
+	case Dot:
+		return dot
+	case Euclidean:
+		return euclidean
+	default:
+		if l2Normalized {
+			return dot
+		}
+		return cosine
+	}
+}
+
+func dot(a, b []float32) float32 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return float32(sum)
+}
+
+func cosine(a, b []float32) float32 {
+	var sum, normA, normB float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(sum / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
+
+func euclidean(a, b []float32) float32 {
+	var sum float64
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+	return float32(math.Sqrt(sum))
+}
+
+// Ranked is the score of a candidate and its position in the candidate list
+type Ranked struct {
+	Index int     `json:"index"`
+	Score float32 `json:"score"`
+}
+
+// Rank scores query against every candidate and returns the candidates from the most to the least similar.
+// If topK is positive, only the topK most similar candidates are returned.
+func Rank(metric Metric, l2Normalized bool, query []float32, candidates [][]float32, topK int) ([]Ranked, error) {
+	score := metric.Scorer(l2Normalized)
+	ranked := make([]Ranked, len(candidates))
+	for i, candidate := range candidates {
+		if len(candidate) != len(query) {
+			return nil, fmt.Errorf("dimension mismatch: query has %d dimensions, candidate %d has %d", len(query), i, len(candidate))
+		}
+		ranked[i] = Ranked{Index: i, Score: score(query, candidate)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if metric.HigherIsBetter() {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Score < ranked[j].Score
+	})
+	if topK > 0 && topK < len(ranked) {
+		ranked = ranked[:topK]
+	}
+	return ranked, nil
+}
+
+// Matrix computes the metric between every vector of a (rows) and every vector of b (columns)
+func Matrix(metric Metric, l2Normalized bool, a, b [][]float32) ([][]float32, error) {
+	score := metric.Scorer(l2Normalized)
+	scores := make([][]float32, len(a))
+	for i, va := range a {
+		scores[i] = make([]float32, len(b))
+		for j, vb := range b {
+			if len(va) != len(vb) {
+				return nil, fmt.Errorf("dimension mismatch: vector %d has %d dimensions, vector %d has %d", i, len(va), j, len(vb))
+			}
+			scores[i][j] = score(va, vb)
+		}
+	}
+	return scores, nil
+}
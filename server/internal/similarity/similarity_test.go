@@ -0,0 +1,67 @@
+package similarity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMetric(t *testing.T) {
+	m, err := ParseMetric("")
+	require.NoError(t, err)
+	require.Equal(t, Cosine, m)
+	m, err = ParseMetric("Euclidean")
+	require.NoError(t, err)
+	require.Equal(t, Euclidean, m)
+	_, err = ParseMetric("manhattan")
+	require.Error(t, err)
+}
+
+func TestScorers(t *testing.T) {
+	a := []float32{3, 4}
+	b := []float32{4, 3}
+	require.InDelta(t, 24, Dot.Scorer(false)(a, b), 1e-6)
+	require.InDelta(t, 0.96, Cosine.Scorer(false)(a, b), 1e-6)
+	require.InDelta(t, 1.4142135, Euclidean.Scorer(false)(a, b), 1e-6)
+	require.Equal(t, float32(0), Cosine.Scorer(false)(a, []float32{0, 0}))
+
+	// on L2 normalized vectors cosine and dot agree
+	na := []float32{0.6, 0.8}
+	nb := []float32{0.8, 0.6}
+	require.InDelta(t, Cosine.Scorer(false)(na, nb), Cosine.Scorer(true)(na, nb), 1e-6)
+	require.InDelta(t, Dot.Scorer(true)(na, nb), Cosine.Scorer(true)(na, nb), 1e-6)
+}
+
+func TestRank(t *testing.T) {
+	query := []float32{1, 0}
+	candidates := [][]float32{{0, 1}, {1, 0}, {0.7, 0.7}}
+	ranked, err := Rank(Cosine, false, query, candidates, 0)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 0}, indexes(ranked))
+
+	ranked, err = Rank(Euclidean, false, query, candidates, 2)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, indexes(ranked))
+	require.InDelta(t, 0, ranked[0].Score, 1e-6)
+
+	_, err = Rank(Dot, false, query, [][]float32{{1, 2, 3}}, 0)
+	require.Error(t, err)
+}
+
+func TestMatrix(t *testing.T) {
+	vectors := [][]float32{{1, 0}, {0, 1}}
+	scores, err := Matrix(Dot, true, vectors, vectors)
+	require.NoError(t, err)
+	require.Equal(t, [][]float32{{1, 0}, {0, 1}}, scores)
+
+	_, err = Matrix(Dot, true, vectors, [][]float32{{1}})
+	require.Error(t, err)
+}
+
+func indexes(ranked []Ranked) []int {
+	idx := make([]int, len(ranked))
+	for i, r := range ranked {
+		idx[i] = r.Index
+	}
+	return idx
+}
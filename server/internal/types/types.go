@@ -10,9 +10,136 @@ type EmbedModelListResponse struct {
 type EmbedRequest struct {
 	Model string   `json:"model"`
 	Texts []string `json:"texts"`
+	// Dimensions truncates Matryoshka embeddings to their first Dimensions values before normalization. 0 keeps the
+	// full embedding length of the model.
+	Dimensions int `json:"dimensions,omitempty"`
+	// Encoding is float (default), int8, binary or ubinary. Quantized encodings are returned in QuantizedEmbeddings.
+	Encoding string `json:"encoding,omitempty"`
+	// TokenEmbeddings returns the embedding of every token of each text in TokenEmbeddings instead of one pooled
+	// embedding per text
+	TokenEmbeddings bool `json:"token_embeddings,omitempty"`
+}
+
+// TokenEmbeddings are the embeddings of every token of a text
+type TokenEmbeddings struct {
+	Tokens     []int32     `json:"tokens"`
+	Embeddings [][]float32 `json:"embeddings"`
 }
 
 type EmbedResponse struct {
 	Embeddings [][]float32 `json:"embeddings"`
-	Error      string      `json:"error"`
+	Tokens     [][]int32   `json:"tokens,omitempty"`
+	Scores     []float32   `json:"scores,omitempty"`
+	// Encoding and QuantizedEmbeddings are set instead of Embeddings when a quantized encoding is requested. Each
+	// quantized embedding holds its packed bytes, base64 encoded in JSON.
+	Encoding            string            `json:"encoding,omitempty"`
+	QuantizedEmbeddings [][]byte          `json:"quantized_embeddings,omitempty"`
+	TokenEmbeddings     []TokenEmbeddings `json:"token_embeddings,omitempty"`
+	Error               string            `json:"error"`
+	// Err is the error behind Error, kept for in-process callers so that errors.Is works across the worker pool
+	Err error `json:"-"`
+}
+
+// StreamEmbedInput is a single line of a streaming embedding request. A line may also be a bare JSON string.
+type StreamEmbedInput struct {
+	ID   string `json:"id,omitempty"`
+	Text string `json:"text"`
+}
+
+// StreamEmbedOutput is a single line of a streaming embedding response
+type StreamEmbedOutput struct {
+	Index     int       `json:"index"`
+	ID        string    `json:"id,omitempty"`
+	Embedding []float32 `json:"embedding,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// SimilarityRequest scores a query against candidate texts
+type SimilarityRequest struct {
+	Model      string   `json:"model"`
+	Query      string   `json:"query"`
+	Candidates []string `json:"candidates"`
+	Metric     string   `json:"metric,omitempty"`
+	TopK       int      `json:"top_k,omitempty"`
+}
+
+type SimilarityScore struct {
+	Index int     `json:"index"`
+	Text  string  `json:"text"`
+	Score float32 `json:"score"`
+}
+
+type SimilarityResponse struct {
+	Metric string            `json:"metric"`
+	Scores []SimilarityScore `json:"scores"`
+	Error  string            `json:"error"`
+}
+
+// PairwiseSimilarityRequest scores every text against every other text. If Others is empty, Texts is compared with
+// itself.
+type PairwiseSimilarityRequest struct {
+	Model  string   `json:"model"`
+	Texts  []string `json:"texts"`
+	Others []string `json:"others,omitempty"`
+	Metric string   `json:"metric,omitempty"`
+}
+
+type PairwiseSimilarityResponse struct {
+	Metric string      `json:"metric"`
+	Scores [][]float32 `json:"scores"`
+	Error  string      `json:"error"`
+}
+
+// RerankRequest scores documents against a query with a reranker model
+type RerankRequest struct {
+	Model     string   `json:"model"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopK      int      `json:"top_k,omitempty"`
+}
+
+type RerankResult struct {
+	Index          int     `json:"index"`
+	Document       string  `json:"document"`
+	RelevanceScore float32 `json:"relevance_score"`
+}
+
+type RerankResponse struct {
+	Results []RerankResult `json:"results"`
+	Error   string         `json:"error"`
+}
+
+// CreateCollectionRequest creates a collection whose documents are embedded with Model
+type CreateCollectionRequest struct {
+	Name  string `json:"name"`
+	Model string `json:"model"`
+}
+
+type CollectionDocument struct {
+	ID       string         `json:"id"`
+	Text     string         `json:"text"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// AddDocumentsRequest adds documents to a collection, replacing stored documents with the same ids
+type AddDocumentsRequest struct {
+	Documents []CollectionDocument `json:"documents"`
+}
+
+// QueryCollectionRequest searches a collection for the TopK documents most similar to Query
+type QueryCollectionRequest struct {
+	Query string `json:"query"`
+	TopK  int    `json:"top_k,omitempty"`
+}
+
+// LoadPoolRequest loads the pool of Kind (embed, rerank or tokens, default embed) of Model with Workers workers
+type LoadPoolRequest struct {
+	Model   string `json:"model"`
+	Kind    string `json:"kind,omitempty"`
+	Workers int    `json:"workers,omitempty"`
+}
+
+// ResizePoolRequest changes the number of workers of a pool
+type ResizePoolRequest struct {
+	Workers int `json:"workers"`
 }
@@ -4,25 +4,29 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/cachedir"
 )
 
-var defaultCacheDir = filepath.Join(os.Getenv("HOME"), ".cache/llama_cache")
+// the cache root is resolved like in the Go bindings: LLAMA_CACHE_DIR, XDG_CACHE_HOME, then ~/.cache/llama_cache
+var defaultCacheDir = cachedir.DefaultRoot()
 var defaultModelCacheDir = filepath.Join(defaultCacheDir, "models")
 
 func EnsureCacheDir() error {
-	if _, err := os.Stat(defaultCacheDir); os.IsNotExist(err) {
-		err := os.MkdirAll(defaultCacheDir, 0755)
-		if err != nil {
-			return fmt.Errorf("could not create cache directory: %v", err)
-		}
-		err = os.MkdirAll(defaultModelCacheDir, 0755)
-		if err != nil {
-			return fmt.Errorf("could not create model cache directory: %v", err)
-		}
+	if err := cachedir.New(defaultCacheDir).Ensure(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(defaultModelCacheDir, 0755); err != nil {
+		return fmt.Errorf("could not create model cache directory: %v", err)
 	}
 	return nil
 }
 
+// CacheManager returns the manager of the cache directory, to list and prune cached models
+func CacheManager() *cachedir.Manager {
+	return cachedir.New(defaultCacheDir)
+}
+
 func GetCacheDir() string {
 	return defaultCacheDir
 }
@@ -32,10 +36,6 @@ func GetModelCacheDir() string {
 }
 
 func init() {
-	if envProvidedCacheDir, exists := os.LookupEnv("LLAMA_CACHE_DIR"); exists {
-		defaultCacheDir = envProvidedCacheDir
-		defaultModelCacheDir = filepath.Join(defaultCacheDir, "models")
-	}
 	err := EnsureCacheDir()
 	if err != nil {
 		panic(fmt.Sprintf("Error creating cache directory: %v", err))
@@ -1,112 +1,79 @@
 package utils
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
-)
-
-// downloadHFModel downloads a model from Hugging Face and saves it to the specified target location.
-func DownloadHFModel(hfRepo, hfFile, targetLocation, hfToken string) error {
-	if hfFile == "" || hfRepo == "" {
-		return fmt.Errorf("hfRepo and hfFile are required")
-	}
-	if !strings.HasSuffix(strings.ToLower(hfFile), ".gguf") {
-		return fmt.Errorf("model file must be a .gguf file")
-	}
 
-	if strings.Contains(targetLocation, "\\") || strings.Contains(targetLocation, "..") {
-		return fmt.Errorf("invalid target location")
-	}
+	"github.com/amikos-tech/llamacpp-embedder/bindings/go/modelsource"
+)
 
-	// Validate and sanitize the filename
-	filename := sanitizeFileName(filepath.Base(hfFile))
-	if filename == "" {
-		return fmt.Errorf("invalid filename")
+// EnsureModels ensures that the models are downloaded and available in the cache directory. A model is either
+// <owner>/<repo>/<file>.gguf on Hugging Face or a source URI such as s3://bucket/model.gguf, see modelsource.Parse.
+func EnsureModels(models string) error {
+	for _, model := range strings.Split(models, ";") {
+		src, err := parseModel(model)
+		if err != nil {
+			return err
+		}
+		if err := ensureSourceModel(src); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Construct and validate the URL
-	url := fmt.Sprintf("https://huggingface.co/%s/resolve/main/%s", sanitizeURLPath(hfRepo), sanitizeURLPath(hfFile))
-	if !isValidHuggingFaceURL(url) {
-		return fmt.Errorf("invalid Hugging Face URL")
+// ModelNames returns the names the models of an EnsureModels list are cached under, i.e. the names they are requested by
+func ModelNames(models string) ([]string, error) {
+	var names []string
+	for _, model := range strings.Split(models, ";") {
+		src, err := parseModel(model)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, src.FileName())
 	}
+	return names, nil
+}
 
-	// Determine the output path
-	outputPath, err := determineOutputPath(targetLocation, filename)
-	if err != nil {
-		return err
+// parseModel returns the source of a model of an EnsureModels list. <owner>/<repo>/<file>.gguf is short for
+// hf://<owner>/<repo>/<file>.gguf.
+func parseModel(model string) (modelsource.Source, error) {
+	if modelsource.IsURI(model) {
+		return modelsource.Parse(model)
 	}
-	if outputPath == "" || strings.Contains(outputPath, "\\") || strings.Contains(outputPath, "..") {
-		return fmt.Errorf("invalid output path")
+	segments := strings.Split(model, "/")
+	if len(segments) < 3 {
+		return nil, fmt.Errorf("invalid model format: %s", model)
 	}
+	return modelsource.HuggingFace(segments[0]+"/"+segments[1], strings.Join(segments[2:], "/"), os.Getenv("HF_TOKEN"))
+}
 
-	// Check if the file already exists
-	if _, err := os.Stat(outputPath); err == nil {
-		return nil // File already exists, no need to download
+// ensureSourceModel fetches the model of src into the cache. Models on a local or network file system are linked into
+// the cache instead of copied, so the server finds them by name.
+func ensureSourceModel(src modelsource.Source) error {
+	if !isValidFileName(src.FileName()) {
+		return fmt.Errorf("invalid file name: %s", src.FileName())
 	}
-
-	// Create the HTTP client and request
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+	fmt.Printf("Fetching model %s from %s\n", src.FileName(), src)
+	path, err := src.Fetch(context.Background(), GetModelCacheDir())
 	if err != nil {
-		return err
+		return fmt.Errorf("Error fetching model %s: %v\n", src, err)
 	}
-
-	if hfToken != "" {
-		req.Header.Set("Authorization", "Bearer "+hfToken)
+	target := filepath.Join(GetModelCacheDir(), src.FileName())
+	if path == target {
+		return nil
 	}
-
-	// Execute the request
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP error: %s", resp.Status)
+	if existing, err := os.Readlink(target); err == nil && existing == path {
+		return nil
 	}
-
-	// Create the output file
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		return err
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("model %s is already cached from another source", src.FileName())
 	}
-	defer outFile.Close()
-
-	// Write response body to file
-	_, err = io.Copy(outFile, resp.Body)
-	return err
-}
-
-// EnsureModels ensures that the models are downloaded and available in the cache directory.
-func EnsureModels(models string) error {
-	modelList := strings.Split(models, ";")
-	for _, model := range modelList {
-		segments := strings.Split(model, "/")
-		if len(segments) < 3 {
-			return fmt.Errorf("invalid model format: %s", model)
-		}
-		hfRepo := fmt.Sprintf("%s/%s", segments[0], segments[1])
-		hfFile := strings.Join(segments[2:], "/")
-		if !strings.HasSuffix(strings.ToLower(hfFile), ".gguf") {
-			return fmt.Errorf("model file must be a .gguf file")
-		}
-		// Validate the file name
-		if !isValidFileName(hfFile) {
-			return fmt.Errorf("invalid file name: %s", hfFile)
-		}
-
-		targetLocation := filepath.Join(GetModelCacheDir(), sanitizeFileName(hfFile))
-		fmt.Printf("Downloading model %s from %s to %s\n", hfFile, hfRepo, targetLocation)
-		err := DownloadHFModel(hfRepo, hfFile, targetLocation, "")
-		if err != nil {
-			return fmt.Errorf("Error downloading model %s: %v\n", hfFile, err)
-		}
+	if err := os.Symlink(path, target); err != nil {
+		return fmt.Errorf("failed to link model %s into the cache: %v", path, err)
 	}
 	return nil
 }
@@ -127,49 +94,3 @@ func isValidFileName(name string) bool {
 
 	return true
 }
-
-// sanitizeFileName removes any potentially dangerous characters from the file name
-func sanitizeFileName(name string) string {
-	// Remove any characters that aren't alphanumeric, dash, underscore, or dot
-	return regexp.MustCompile(`[^a-zA-Z0-9\-_.]`).ReplaceAllString(name, "")
-}
-
-// sanitizeURLPath sanitizes the path component of a URL
-func sanitizeURLPath(path string) string {
-	// Remove any characters that aren't alphanumeric, dash, underscore, dot, or forward slash
-	return regexp.MustCompile(`[^a-zA-Z0-9\-_./]`).ReplaceAllString(path, "")
-}
-
-// isValidHuggingFaceURL checks if the given URL is a valid Hugging Face URL
-func isValidHuggingFaceURL(url string) bool {
-	return strings.HasPrefix(url, "https://huggingface.co/") && strings.Contains(url, "/resolve/main/")
-}
-
-// determineOutputPath determines the final output path for the downloaded file
-func determineOutputPath(targetLocation, filename string) (string, error) {
-	if targetLocation == "" {
-		return filename, nil
-	}
-	if strings.Contains(targetLocation, "\\") || strings.Contains(targetLocation, "..") {
-		return "", fmt.Errorf("invalid target location")
-	}
-
-	info, err := os.Stat(targetLocation)
-	if err == nil && info.IsDir() {
-		return filepath.Join(targetLocation, filename), nil
-	}
-
-	if os.IsNotExist(err) && strings.HasSuffix(targetLocation, string(os.PathSeparator)) {
-		if err := os.MkdirAll(targetLocation, os.ModePerm); err != nil {
-			return "", fmt.Errorf("failed to create directory: %v", err)
-		}
-		return filepath.Join(targetLocation, filename), nil
-	}
-
-	// targetLocation is a file path
-	outputDir := filepath.Dir(targetLocation)
-	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-		return "", fmt.Errorf("failed to create directory: %v", err)
-	}
-	return targetLocation, nil
-}
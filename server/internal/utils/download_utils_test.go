@@ -3,6 +3,8 @@ package utils
 import (
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -11,21 +13,6 @@ import (
 const defaultHFRepo = "leliuga/all-MiniLM-L6-v2-GGUF"
 const defaultModelFile = "all-MiniLM-L6-v2.Q4_0.gguf"
 
-func TestDownloadModel(t *testing.T) {
-	tempDir := t.TempDir()
-	targetLocation := filepath.Join(tempDir, "llama_cache", defaultModelFile)
-	err := DownloadHFModel(defaultHFRepo, defaultModelFile, targetLocation, "")
-	require.NoError(t, err, "Failed to download model")
-	_, err = os.Stat(targetLocation)
-	require.NoError(t, err, "Failed to download model")
-	t.Cleanup(func() {
-		err := os.RemoveAll(targetLocation)
-		if err != nil {
-			fmt.Printf("Error removing file: %v", err)
-		}
-	})
-}
-
 func TestEnsureSingleModel(t *testing.T) {
 	tempDir := t.TempDir()
 	models := fmt.Sprintf("%s/%s", defaultHFRepo, defaultModelFile)
@@ -64,3 +51,62 @@ func TestEnsureMultipleModels(t *testing.T) {
 		}
 	})
 }
+
+func TestEnsureSourceModels(t *testing.T) {
+	tempDir := t.TempDir()
+	originalModelCacheDir := defaultModelCacheDir
+	defaultModelCacheDir = filepath.Join(tempDir, "llama_cache", "models")
+	require.NoError(t, os.MkdirAll(defaultModelCacheDir, 0755))
+	t.Cleanup(func() {
+		defaultModelCacheDir = originalModelCacheDir
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("gguf"))
+	}))
+	t.Cleanup(srv.Close)
+	shared := filepath.Join(tempDir, "nfs", "shared.gguf")
+	require.NoError(t, os.MkdirAll(filepath.Dir(shared), 0755))
+	require.NoError(t, os.WriteFile(shared, []byte("gguf"), 0644))
+
+	models := srv.URL + "/models/remote.gguf;file://" + filepath.ToSlash(shared)
+	require.NoError(t, EnsureModels(models))
+	require.FileExists(t, filepath.Join(defaultModelCacheDir, "remote.gguf"))
+	link, err := os.Readlink(filepath.Join(defaultModelCacheDir, "shared.gguf"))
+	require.NoError(t, err, "file sources are linked into the cache")
+	require.Equal(t, shared, link)
+
+	require.NoError(t, EnsureModels(models), "models that are already cached are reused")
+	require.Error(t, EnsureModels("ftp://host/model.gguf"))
+}
+
+func TestEnsureOllamaModel(t *testing.T) {
+	tempDir := t.TempDir()
+	originalModelCacheDir := defaultModelCacheDir
+	defaultModelCacheDir = filepath.Join(tempDir, "llama_cache", "models")
+	require.NoError(t, os.MkdirAll(defaultModelCacheDir, 0755))
+	t.Cleanup(func() {
+		defaultModelCacheDir = originalModelCacheDir
+	})
+	store := filepath.Join(tempDir, "ollama")
+	manifest := filepath.Join(store, "manifests", "registry.ollama.ai", "library", "nomic-embed-text", "latest")
+	require.NoError(t, os.MkdirAll(filepath.Dir(manifest), 0755))
+	require.NoError(t, os.WriteFile(manifest, []byte(`{"layers":[{"mediaType":"application/vnd.ollama.image.model","digest":"sha256:abc"}]}`), 0644))
+	blob := filepath.Join(store, "blobs", "sha256-abc")
+	require.NoError(t, os.MkdirAll(filepath.Dir(blob), 0755))
+	require.NoError(t, os.WriteFile(blob, []byte("GGUF"), 0644))
+	t.Setenv("OLLAMA_MODELS", store)
+
+	require.NoError(t, EnsureModels("ollama:nomic-embed-text"))
+	link, err := os.Readlink(filepath.Join(defaultModelCacheDir, "nomic-embed-text.gguf"))
+	require.NoError(t, err, "Ollama models are linked into the cache")
+	require.Equal(t, blob, link)
+}
+
+func TestModelNames(t *testing.T) {
+	names, err := ModelNames(defaultHFRepo + "/" + defaultModelFile + ";hf://owner/repo/sub/model.gguf;ollama:mxbai-embed-large:v1")
+	require.NoError(t, err)
+	require.Equal(t, []string{defaultModelFile, "model.gguf", "mxbai-embed-large-v1.gguf"}, names)
+
+	_, err = ModelNames("model.gguf")
+	require.Error(t, err)
+}
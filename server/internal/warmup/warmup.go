@@ -0,0 +1,108 @@
+// Package warmup loads the configured models at startup and tracks their load state for the readiness endpoint
+package warmup
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StatePending State = "pending"
+	StateLoading State = "loading"
+	StateReady   State = "ready"
+	StateFailed  State = "failed"
+)
+
+// ModelStatus is the load state of a warmed up model
+type ModelStatus struct {
+	Model string `json:"model"`
+	State State  `json:"state"`
+	Error string `json:"error,omitempty"`
+	// LoadTimeMs is how long loading the model and the test embedding took, once it is ready or failed
+	LoadTimeMs int64 `json:"load_time_ms,omitempty"`
+}
+
+// Status is the readiness of the server. It is ready once every model is warmed up; a model that failed to load keeps
+// it not ready.
+type Status struct {
+	Ready  bool          `json:"ready"`
+	Models []ModelStatus `json:"models"`
+}
+
+// WarmFunc loads a model and runs a test embedding with it
+type WarmFunc func(ctx context.Context, model string) error
+
+// Tracker warms up models and records their state
+type Tracker struct {
+	mu      sync.Mutex
+	models  []ModelStatus
+	started bool
+	done    bool
+}
+
+// NewTracker creates a tracker that is ready until Start is called with models to warm up
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+// Start warms up the models one after the other in the background. The tracker is not ready until all of them are.
+func (t *Tracker) Start(ctx context.Context, models []string, warm WarmFunc) {
+	t.mu.Lock()
+	t.started = true
+	t.models = make([]ModelStatus, len(models))
+	for i, model := range models {
+		t.models[i] = ModelStatus{Model: model, State: StatePending}
+	}
+	t.mu.Unlock()
+	go t.run(ctx, models, warm)
+}
+
+func (t *Tracker) run(ctx context.Context, models []string, warm WarmFunc) {
+	for i, model := range models {
+		t.update(i, func(s *ModelStatus) { s.State = StateLoading })
+		log.Printf("Warming up model %s", model)
+		start := time.Now()
+		err := warm(ctx, model)
+		elapsed := time.Since(start)
+		t.update(i, func(s *ModelStatus) {
+			s.LoadTimeMs = elapsed.Milliseconds()
+			if err != nil {
+				s.State = StateFailed
+				s.Error = err.Error()
+			} else {
+				s.State = StateReady
+			}
+		})
+		if err != nil {
+			log.Printf("Warming up model %s failed: %v", model, err)
+		} else {
+			log.Printf("Model %s warmed up in %v", model, elapsed)
+		}
+	}
+	t.mu.Lock()
+	t.done = true
+	t.mu.Unlock()
+}
+
+func (t *Tracker) update(i int, f func(*ModelStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f(&t.models[i])
+}
+
+// Status returns the readiness of the server and the state of each model
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := Status{Ready: !t.started || t.done, Models: append([]ModelStatus{}, t.models...)}
+	for _, model := range t.models {
+		if model.State != StateReady {
+			status.Ready = false
+		}
+	}
+	return status
+}
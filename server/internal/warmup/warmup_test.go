@@ -0,0 +1,63 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackerWithoutModelsIsReady(t *testing.T) {
+	tracker := NewTracker()
+	require.True(t, tracker.Status().Ready)
+
+	tracker.Start(context.Background(), nil, func(context.Context, string) error { return nil })
+	require.Eventually(t, func() bool { return tracker.Status().Ready }, time.Second, 10*time.Millisecond)
+	require.Empty(t, tracker.Status().Models)
+}
+
+func TestTracker(t *testing.T) {
+	release := make(chan struct{})
+	tracker := NewTracker()
+	tracker.Start(context.Background(), []string{"a.gguf", "b.gguf"}, func(_ context.Context, model string) error {
+		<-release
+		return nil
+	})
+
+	require.Eventually(t, func() bool {
+		return tracker.Status().Models[0].State == StateLoading
+	}, time.Second, 10*time.Millisecond)
+	status := tracker.Status()
+	require.False(t, status.Ready)
+	require.Equal(t, StatePending, status.Models[1].State)
+
+	close(release)
+	require.Eventually(t, func() bool { return tracker.Status().Ready }, time.Second, 10*time.Millisecond)
+	for _, model := range tracker.Status().Models {
+		require.Equal(t, StateReady, model.State)
+		require.Empty(t, model.Error)
+	}
+}
+
+func TestTrackerFailedModel(t *testing.T) {
+	var order []string
+	tracker := NewTracker()
+	tracker.Start(context.Background(), []string{"broken.gguf", "ok.gguf"}, func(_ context.Context, model string) error {
+		order = append(order, model)
+		if model == "broken.gguf" {
+			return errors.New("failed to create embedder")
+		}
+		return nil
+	})
+
+	require.Eventually(t, func() bool {
+		return tracker.Status().Models[1].State == StateReady
+	}, time.Second, 10*time.Millisecond)
+	status := tracker.Status()
+	require.False(t, status.Ready, "a model that failed to load keeps the server not ready")
+	require.Equal(t, StateFailed, status.Models[0].State)
+	require.Equal(t, "failed to create embedder", status.Models[0].Error)
+	require.Equal(t, []string{"broken.gguf", "ok.gguf"}, order, "models are warmed up one after the other")
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/amikos-tech/llamacpp-embedder/server/internal/embedder"
+)
+
+// embedderOptionsFromEnv returns the llama.cpp context options of the pool embedders set in the environment:
+// LLAMA_CONTEXT_SIZE, LLAMA_BATCH_SIZE, LLAMA_THREADS, LLAMA_MMAP, LLAMA_MLOCK and LLAMA_FLASH_ATTENTION
+func embedderOptionsFromEnv() ([]embedder.Option, error) {
+	var opts []embedder.Option
+	for _, v := range []struct {
+		name   string
+		option func(uint32) embedder.Option
+	}{
+		{"LLAMA_CONTEXT_SIZE", embedder.WithContextSize},
+		{"LLAMA_BATCH_SIZE", embedder.WithBatchSize},
+	} {
+		if value, exists := os.LookupEnv(v.name); exists {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", v.name, value)
+			}
+			opts = append(opts, v.option(uint32(n)))
+		}
+	}
+	if value, exists := os.LookupEnv("LLAMA_THREADS"); exists {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LLAMA_THREADS: %s", value)
+		}
+		opts = append(opts, embedder.WithThreads(n))
+	}
+	for _, v := range []struct {
+		name   string
+		option func(bool) embedder.Option
+	}{
+		{"LLAMA_MMAP", embedder.WithMmap},
+		{"LLAMA_MLOCK", embedder.WithMlock},
+		{"LLAMA_FLASH_ATTENTION", embedder.WithFlashAttention},
+	} {
+		if value, exists := os.LookupEnv(v.name); exists {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", v.name, value)
+			}
+			opts = append(opts, v.option(enabled))
+		}
+	}
+	return opts, nil
+}
+
+// embedderOptionsEnv lists the variables read by embedderOptionsFromEnv
+var embedderOptionsEnv = []string{"LLAMA_CONTEXT_SIZE", "LLAMA_BATCH_SIZE", "LLAMA_THREADS", "LLAMA_MMAP", "LLAMA_MLOCK",
+	"LLAMA_FLASH_ATTENTION"}
+
+// OptionsFingerprint identifies the embedder options set in the environment. The context and batch sizes decide how
+// texts are truncated, so embeddings computed with other options are not interchangeable.
+func OptionsFingerprint() string {
+	var b strings.Builder
+	for _, name := range embedderOptionsEnv {
+		if value, exists := os.LookupEnv(name); exists {
+			fmt.Fprintf(&b, "%s=%s;", name, strings.TrimSpace(value))
+		}
+	}
+	return b.String()
+}
+
+// mmapEnabled reports whether the embedders memory-map the model, which LLAMA_MMAP disables
+func mmapEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("LLAMA_MMAP"))
+	return err != nil || enabled
+}
@@ -1,37 +1,99 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/embedder"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/types"
 	"github.com/amikos-tech/llamacpp-embedder/server/internal/utils"
+	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrPoolClosed is the error of jobs submitted to a pool that was closed, e.g. unloaded while the job waited
+var ErrPoolClosed = errors.New("worker pool closed")
+
+// Priority selects the queue a job is submitted to. Workers always take normal priority jobs first.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityLow
+)
+
+// JobType selects what a worker does with the texts of a job
+type JobType int
+
+const (
+	// JobEmbed returns the embeddings of the texts in Response.Embeddings
+	JobEmbed JobType = iota
+	// JobTokenize returns the token ids of the texts in Response.Tokens
+	JobTokenize
+	// JobRerank returns the relevance score of each text for Job.Query in Response.Scores.
+	// It must be submitted to a pool created with PoolingRank.
+	JobRerank
+	// JobEmbedTokens returns the embedding of every token of the texts in Response.TokenEmbeddings.
+	// It must be submitted to a pool created with PoolingNone.
+	JobEmbedTokens
+)
+
 type Job struct {
 	Request  *types.EmbedRequest
 	Response chan *types.EmbedResponse
+	Type     JobType
+	// Query is the query the texts are scored against by JobRerank jobs
+	Query    string
+	Priority Priority
+	// Context cancels the job while it is queued and a JobEmbed job while it is computed, nil never cancels it
+	Context context.Context
 }
 
 type Pool struct {
 	jobs         chan Job
+	lowJobs      chan Job
 	workers      int
 	model        string
+	pooling      embedder.PoolingType
 	close        chan struct{}
 	wg           sync.WaitGroup
 	lastAccessed time.Time
 	mu           sync.Mutex
+	// closed is set by Close, Resize adds no workers once Close waits for them
+	closed bool
+	// loaded is closed once every worker has loaded its embedder or one of them failed to, loadErr is the failure
+	loaded     chan struct{}
+	loadedOnce sync.Once
+	loading    int
+	loadErr    error
+	// shrink stops one idle worker per value, see Resize
+	shrink   chan struct{}
+	resizeMu sync.Mutex
+	// queued jobs wait for a worker, busy workers process one
+	queued atomic.Int64
+	busy   atomic.Int64
 }
 
 func NewPool(model string, workers int) (*Pool, error) {
+	return NewPoolWithPooling(model, embedder.PoolingMean, workers)
+}
 
+// NewPoolWithPooling creates a pool whose embedders use the given pooling type, e.g. PoolingRank for reranker models
+func NewPoolWithPooling(model string, pooling embedder.PoolingType, workers int) (*Pool, error) {
 	pool := &Pool{
 		jobs:    make(chan Job),
+		lowJobs: make(chan Job),
 		workers: workers,
 		model:   model,
+		pooling: pooling,
 		close:   make(chan struct{}),
+		shrink:  make(chan struct{}),
+		loaded:  make(chan struct{}),
+		// a new pool counts as accessed, so that it is not expired before its first job is submitted
+		lastAccessed: time.Now(),
 	}
 	err := pool.Start()
 	if err != nil {
@@ -41,45 +103,171 @@ func NewPool(model string, workers int) (*Pool, error) {
 }
 
 func (p *Pool) Start() (err error) {
+	p.mu.Lock()
+	p.loading = p.workers
+	p.mu.Unlock()
 	for i := 0; i < p.workers; i++ {
+		p.startWorker()
+	}
+	return nil
+}
+
+func (p *Pool) startWorker() {
+	p.wg.Add(1)
+	go p.runWorker()
+}
+
+func (p *Pool) runWorker() {
+	defer p.wg.Done()
+	if err := p.worker(); err != nil {
+		fmt.Printf("worker error: %v", err)
+	}
+}
+
+// Resize changes the number of workers of the pool. Added workers load the model in the background. Removed workers
+// finish their current job first; Resize waits for them until ctx is done.
+func (p *Pool) Resize(ctx context.Context, workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("a pool needs at least one worker, got %d", workers)
+	}
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+	for p.Workers() < workers {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return ErrPoolClosed
+		}
+		p.workers++
+		p.loading++
 		p.wg.Add(1)
-		go func() {
-			defer p.wg.Done()
-			err = p.worker()
-			if err != nil {
-				fmt.Printf("worker error: %v", err)
-				return
-			}
-		}()
-		if err != nil {
-			return
+		p.mu.Unlock()
+		go p.runWorker()
+	}
+	for p.Workers() > workers {
+		select {
+		case p.shrink <- struct{}{}:
+			p.mu.Lock()
+			p.workers--
+			p.mu.Unlock()
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.close:
+			return ErrPoolClosed
 		}
 	}
 	return nil
 }
 
 func (p *Pool) worker() error {
-	emb, closeEmbedder, err := embedder.NewLlamaEmbedder(filepath.Join(utils.GetModelCacheDir(), p.model))
+	opts, err := embedderOptionsFromEnv()
 	if err != nil {
-		return fmt.Errorf("failed to create embedder: %v", err)
+		p.workerLoaded(err)
+		return err
 	}
+	emb, closeEmbedder, err := embedder.NewLlamaEmbedder(filepath.Join(utils.GetModelCacheDir(), p.model), append(opts, embedder.WithPooling(p.pooling))...)
+	if err != nil {
+		err = fmt.Errorf("failed to create embedder: %w", err)
+		p.workerLoaded(err)
+		return err
+	}
+	p.workerLoaded(nil)
 	defer closeEmbedder()
 	for {
+		// prefer normal priority jobs and only wait on the low priority queue when there are none
+		select {
+		case job := <-p.jobs:
+			p.process(emb, job)
+			continue
+		default:
+		}
 		select {
 		case job := <-p.jobs:
-			p.updateLastAccessed()
-			embeddings, err := emb.EmbedTexts(job.Request.Texts)
-			if err != nil {
-				job.Response <- &types.EmbedResponse{Error: err.Error()}
-			} else {
-				job.Response <- &types.EmbedResponse{Embeddings: embeddings}
-			}
+			p.process(emb, job)
+		case job := <-p.lowJobs:
+			p.process(emb, job)
+		case <-p.shrink:
+			return nil
 		case <-p.close:
 			return nil
 		}
 	}
 }
 
+func (p *Pool) process(emb *embedder.LlamaEmbedder, job Job) {
+	p.queued.Add(-1)
+	p.busy.Add(1)
+	defer p.busy.Add(-1)
+	p.updateLastAccessed()
+	// skip the jobs cancelled while they were queued
+	if job.Context != nil && job.Context.Err() != nil {
+		job.Response <- &types.EmbedResponse{Error: job.Context.Err().Error(), Err: job.Context.Err()}
+		return
+	}
+	switch job.Type {
+	case JobTokenize:
+		tokens, err := emb.Tokenize(job.Request.Texts)
+		if err != nil {
+			job.Response <- &types.EmbedResponse{Error: err.Error(), Err: err}
+		} else {
+			job.Response <- &types.EmbedResponse{Tokens: tokens}
+		}
+		return
+	case JobEmbedTokens:
+		tokenEmbeddings, err := emb.EmbedTokens(job.Request.Texts)
+		if err != nil {
+			job.Response <- &types.EmbedResponse{Error: err.Error(), Err: err}
+		} else {
+			job.Response <- &types.EmbedResponse{TokenEmbeddings: tokenEmbeddings}
+		}
+		return
+	case JobRerank:
+		scores, err := emb.Rerank(job.Query, job.Request.Texts)
+		if err != nil {
+			job.Response <- &types.EmbedResponse{Error: err.Error(), Err: err}
+		} else {
+			job.Response <- &types.EmbedResponse{Scores: scores}
+		}
+		return
+	}
+	ctx := job.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	embeddings, err := emb.EmbedTextsContext(ctx, job.Request.Texts)
+	if err != nil {
+		job.Response <- &types.EmbedResponse{Error: err.Error(), Err: err}
+	} else {
+		job.Response <- &types.EmbedResponse{Embeddings: embeddings}
+	}
+}
+
+// workerLoaded records that a worker loaded its embedder, or failed to
+func (p *Pool) workerLoaded(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loading--
+	if err != nil && p.loadErr == nil {
+		p.loadErr = err
+	}
+	if p.loading <= 0 || err != nil {
+		p.loadedOnce.Do(func() { close(p.loaded) })
+	}
+}
+
+// WaitLoaded waits until every worker has loaded the model and returns the error of the first worker that failed to.
+// Jobs submitted to a pool whose workers all failed are never processed and wait until their context is done.
+func (p *Pool) WaitLoaded(ctx context.Context) error {
+	select {
+	case <-p.loaded:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.loadErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (p *Pool) updateLastAccessed() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -92,13 +280,82 @@ func (p *Pool) GetLastAccessed() time.Time {
 	return p.lastAccessed
 }
 
+// Model returns the file name of the model loaded by the workers
+func (p *Pool) Model() string {
+	return p.model
+}
+
+// Pooling returns the pooling type the workers embed with
+func (p *Pool) Pooling() embedder.PoolingType {
+	return p.pooling
+}
+
+// Workers returns the number of workers of the pool
+func (p *Pool) Workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.workers
+}
+
+// QueueDepth returns the number of submitted jobs waiting for a worker
+func (p *Pool) QueueDepth() int {
+	return int(p.queued.Load())
+}
+
+// Busy returns the number of workers processing a job
+func (p *Pool) Busy() int {
+	return int(p.busy.Load())
+}
+
+// MemoryEstimate estimates the memory held by the model weights of the workers from the size of the model file.
+// Memory-mapped weights are shared by the workers and counted once. The contexts of the workers are not included.
+func (p *Pool) MemoryEstimate() int64 {
+	info, err := os.Stat(filepath.Join(utils.GetModelCacheDir(), p.model))
+	if err != nil {
+		return 0
+	}
+	if mmapEnabled() {
+		return info.Size()
+	}
+	return info.Size() * int64(p.Workers())
+}
+
+// Submit queues the job for a worker. If the pool is closed before a worker takes it, the job fails with ErrPoolClosed,
+// and if its context is done first, with the error of the context.
 func (p *Pool) Submit(job Job) {
 	p.updateLastAccessed()
-	p.jobs <- job
+	p.queued.Add(1)
+	jobs := p.jobs
+	if job.Priority == PriorityLow {
+		jobs = p.lowJobs
+	}
+	var done <-chan struct{}
+	if job.Context != nil {
+		done = job.Context.Done()
+	}
+	select {
+	case jobs <- job:
+	case <-p.close:
+		p.queued.Add(-1)
+		reply(job, ErrPoolClosed)
+	case <-done:
+		p.queued.Add(-1)
+		reply(job, job.Context.Err())
+	}
+}
+
+// reply fails a job that was not queued. Callers read the response after Submit returns.
+func reply(job Job, err error) {
+	go func() {
+		job.Response <- &types.EmbedResponse{Error: err.Error(), Err: err}
+	}()
 }
 
+// Close stops the workers once they finished their current job and unloads the model
 func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
 	close(p.close)
 	p.wg.Wait()
-	close(p.jobs)
 }
@@ -2,6 +2,8 @@
 #include "llama.h"
 #include "embedder.h"
 #include <ctime>
+#include <cstring>
+#include <fstream>
 
 #if defined(_MSC_VER)
 #pragma warning(disable: 4244 4267) // possible loss of data
@@ -38,17 +40,24 @@ static void batch_decode(llama_context *ctx, llama_batch &batch, float *output,
     llama_kv_cache_clear(ctx);
 
     // run model
+    int32_t ret = 0;
     if (llama_model_has_encoder(model) && !llama_model_has_decoder(model)) {
         // encoder-only model - BERT-like models.
-        if (llama_encode(ctx, batch) < 0) {
-            fprintf(stderr, "%s : failed to encode\n", __func__);
+        ret = llama_encode(ctx, batch);
+        if (ret < 0) {
+            throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_DECODE, "error: failed to encode batch");
         }
     } else if (!llama_model_has_encoder(model) && llama_model_has_decoder(model)) {
         // decoder-only model
-        if (llama_decode(ctx, batch) < 0) {
-            fprintf(stderr, "%s : failed to decode\n", __func__);
+        ret = llama_decode(ctx, batch);
+        if (ret < 0) {
+            throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_DECODE, "error: failed to decode batch");
         }
     }
+    if (ret == 2) {
+        // the abort callback stopped the computation, the outputs are incomplete
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_CANCELLED, "error: embedding cancelled");
+    }
 
     for (int i = 0; i < batch.n_tokens; i++) {
         if (!batch.logits[i]) {
@@ -70,11 +79,40 @@ static void batch_decode(llama_context *ctx, llama_batch &batch, float *output,
             GGML_ASSERT(embd != NULL && "failed to get sequence embeddings");
         }
 
+        if (pooling_type == LLAMA_POOLING_TYPE_RANK) {
+            // rank pooling produces a single relevance score per sequence, which is not normalized
+            output[embd_pos] = embd[0];
+            continue;
+        }
+
         float *out = output + embd_pos * n_embd;
         llama_embd_normalize(embd, out, n_embd, embd_norm);
     }
 }
 
+// Aborts the llama.cpp computation once the cancellation flag of the running call is set
+static bool abort_callback(void *data) {
+    const auto *embedder = static_cast<const llama_embedder *>(data);
+    return embedder->cancel != nullptr && *embedder->cancel != 0;
+}
+
+static void check_cancelled(const llama_embedder *embedder) {
+    if (embedder->cancel != nullptr && *embedder->cancel != 0) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_CANCELLED, "error: embedding cancelled");
+    }
+}
+
+// Model of an embedder and its clones, freed together with the last of their contexts
+struct llama_embedder_model {
+    llama_model *model;
+    llama_context_params context_params;
+
+    ~llama_embedder_model() {
+        llama_free_model(model);
+        llama_backend_free();
+    }
+};
+
 void my_log_callback(enum ggml_log_level level, const char *text, void *user_data) {
     // Do nothing, effectively silencing the log
 }
@@ -118,21 +156,75 @@ enum llama_pooling_type from_uint(const uint32_t pooling_type){
             return LLAMA_POOLING_TYPE_CLS;
         case 3:
             return LLAMA_POOLING_TYPE_LAST;
+        case 4:
+            return LLAMA_POOLING_TYPE_RANK;
         default:
-            throw std::runtime_error("error: invalid pooling type");
+            throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "error: invalid pooling type");
     }
 }
 
+#ifndef LLAMA_EMBEDDER_VERSION
+#define LLAMA_EMBEDDER_VERSION "dev"
+#endif
+
+const char *llama_embedder_version() noexcept {
+    return LLAMA_EMBEDDER_VERSION;
+}
+
+int32_t llama_embedder_abi_version() noexcept {
+    return LLAMA_EMBEDDER_ABI_VERSION;
+}
+
+llama_embedder_params llama_embedder_default_params() {
+    llama_embedder_params embedder_params;
+    embedder_params.pooling_type = 1; // mean
+    embedder_params.n_ctx = 0;
+    embedder_params.n_batch = 0;
+    embedder_params.n_ubatch = 0;
+    embedder_params.n_threads = 0;
+    embedder_params.use_mmap = true;
+    embedder_params.use_mlock = false;
+    embedder_params.flash_attn = false;
+    return embedder_params;
+}
+
 llama_embedder *init_embedder(const char *embedding_model, const uint32_t pooling_type) {
+    llama_embedder_params embedder_params = llama_embedder_default_params();
+    embedder_params.pooling_type = pooling_type;
+    return init_embedder_with_params(embedding_model, &embedder_params);
+}
+
+llama_embedder *init_embedder_with_params(const char *embedding_model, const llama_embedder_params *embedder_params) {
+    if (embedder_params == nullptr) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT,
+                                       "error: null params passed to init_embedder_with_params");
+    }
+    if (embedding_model == nullptr || !std::ifstream(embedding_model).good()) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_MODEL_NOT_FOUND,
+                                       "error: model file not found: " + std::string(embedding_model ? embedding_model : ""));
+    }
     gpt_params params;
 
     log_disable();
 
     params.model = embedding_model;
     params.embedding = true;
+    if (embedder_params->n_ctx > 0) {
+        params.n_ctx = (int32_t) embedder_params->n_ctx;
+    }
+    if (embedder_params->n_batch > 0) {
+        params.n_batch = (int32_t) embedder_params->n_batch;
+    }
     // For non-causal models, batch size must be equal to ubatch size
-    params.n_ubatch = params.n_batch;
-    params.pooling_type = from_uint(pooling_type);
+    params.n_ubatch = embedder_params->n_ubatch > 0 ? (int32_t) embedder_params->n_ubatch : params.n_batch;
+    if (embedder_params->n_threads > 0) {
+        params.cpuparams.n_threads = embedder_params->n_threads;
+        params.cpuparams_batch.n_threads = embedder_params->n_threads;
+    }
+    params.use_mmap = embedder_params->use_mmap;
+    params.use_mlock = embedder_params->use_mlock;
+    params.flash_attn = embedder_params->flash_attn;
+    params.pooling_type = from_uint(embedder_params->pooling_type);
 
 
     if (params.seed == LLAMA_DEFAULT_SEED) {
@@ -153,15 +245,24 @@ llama_embedder *init_embedder(const char *embedding_model, const uint32_t poolin
     llama_model *model = llama_init.model;
     llama_context *ctx = llama_init.context;
     if (model == nullptr) {
-        fprintf(stderr, "%s: error: unable to load model\n", __func__);
-        throw std::runtime_error("error: unable to load model");
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_MODEL_LOAD, "error: unable to load model");
     }
 
     const int32_t n_ctx_train = llama_n_ctx_train(model);
     const uint32_t n_ctx = llama_n_ctx(ctx);
 
     if (llama_model_has_encoder(model) && llama_model_has_decoder(model)) {
-        throw std::runtime_error("error: computing embeddings in encoder-decoder models is not supported");
+        llama_free(ctx);
+        llama_free_model(model);
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED,
+                                       "error: computing embeddings in encoder-decoder models is not supported");
+    }
+
+    if (llama_n_batch(ctx) < n_ctx) {
+        llama_free(ctx);
+        llama_free_model(model);
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT,
+                                       "error: batch size must be at least the context size, increase n_batch or decrease n_ctx");
     }
 
     if (n_ctx > n_ctx_train) {
@@ -183,12 +284,33 @@ llama_embedder *init_embedder(const char *embedding_model, const uint32_t poolin
     embedder->context = ctx;
     embedder->model = model;
     embedder->model_metadata = model_metadata;
+    embedder->shared_model = std::shared_ptr<llama_embedder_model>(
+            new llama_embedder_model{model, llama_context_params_from_gpt_params(params)});
+    llama_set_abort_callback(ctx, abort_callback, embedder);
+    return embedder;
+}
+
+// Creates an embedder with a new context over the model of base
+static llama_embedder *clone_embedder(llama_embedder *base) {
+    if (!base || !base->shared_model) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "Error: Null pointer passed to clone_embedder function");
+    }
+    llama_context *ctx = llama_new_context_with_model(base->model, base->shared_model->context_params);
+    if (ctx == nullptr) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_OUT_OF_MEMORY, "error: failed to create a context for the model");
+    }
+    auto *embedder = new llama_embedder;
+    embedder->context = ctx;
+    embedder->model = base->model;
+    embedder->model_metadata = base->model_metadata;
+    embedder->shared_model = base->shared_model;
+    llama_set_abort_callback(ctx, abort_callback, embedder);
     return embedder;
 }
 
 void tokenize(llama_embedder *embedder, const std::vector<std::string>& texts, std::vector<llama_tokenizer_data> &output,const bool add_special_tokens, const bool parse_special, const bool enable_padding) {
     if (!embedder) {
-        throw std::runtime_error("Error: Null pointer passed to tokenize function");
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "Error: Null pointer passed to tokenize function");
     }
     if (texts.empty()){
         fprintf(stderr, "Warn: empty texts.\n");
@@ -199,7 +321,8 @@ void tokenize(llama_embedder *embedder, const std::vector<std::string>& texts, s
     size_t vmodel_arch_size = sizeof(model_arch);
     llama_model_meta_val_str(embedder->model, "general.architecture",model_arch, vmodel_arch_size);
     if (strcmp(model_arch, "bert") != 0) {
-        throw std::runtime_error("error: tokenize function is only supported for BERT-like models");
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED,
+                                       "error: tokenize function is only supported for BERT-like models");
     }
 
     for (const auto &text: texts) {
@@ -266,16 +389,37 @@ void free_embedder(llama_embedder *embedder) noexcept {
     if (!embedder) {
         return;
     }
-    if (embedder->model) {
-        llama_free_model(embedder->model);
-    }
     if (embedder->context) {
         llama_free(embedder->context);
     }
-    llama_backend_free();
+    // the model is freed once no other clone uses it
     delete embedder;
 }
 
+FloatMatrix embed_with_options_c(llama_embedder *embedder, const char **texts, size_t text_len,
+                                 const llama_embed_options *options) {
+    if (options == nullptr) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "error: null options passed to embed_with_options_c");
+    }
+    std::vector<std::string> texts_inner(texts, texts + text_len);
+    std::vector<std::vector<float>> output;
+    FloatMatrix floatMatrix = {nullptr, 0, 0};
+    embed_with_options(embedder, texts_inner, output, *options);
+    if (output.empty()) {
+        return floatMatrix;
+    }
+    floatMatrix.rows = output.size();
+    floatMatrix.cols = output[0].size();
+    floatMatrix.data = (float *)malloc(floatMatrix.rows * floatMatrix.cols * sizeof(float));
+    if (floatMatrix.data == nullptr) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_OUT_OF_MEMORY, "failed to allocate memory for embeddings");
+    }
+    for (size_t i = 0; i < floatMatrix.rows; i++) {
+        std::memcpy(floatMatrix.data + i * floatMatrix.cols, output[i].data(), floatMatrix.cols * sizeof(float));
+    }
+    return floatMatrix;
+}
+
 FloatMatrix embed_c(llama_embedder * embedder, const char  ** texts,size_t  text_len, int32_t embd_norm){
     std::vector<std::string> texts_inner;
     texts_inner.reserve(text_len);
@@ -284,15 +428,7 @@ FloatMatrix embed_c(llama_embedder * embedder, const char  ** texts,size_t  text
     }
     std::vector<std::vector<float>> output;
     FloatMatrix floatMatrix;
-    try{
-        embed(embedder, texts_inner, output, embd_norm);
-    } catch (const std::exception &e) {
-        fprintf(stderr, "Error: %s\n", e.what());
-        floatMatrix.rows = 0;
-        floatMatrix.cols = 0;
-        floatMatrix.data = nullptr;
-        return floatMatrix;
-    }
+    embed(embedder, texts_inner, output, embd_norm);
     if (output.empty()) {
         floatMatrix.rows = 0;
         floatMatrix.cols = 0;
@@ -302,6 +438,9 @@ FloatMatrix embed_c(llama_embedder * embedder, const char  ** texts,size_t  text
     floatMatrix.rows = output.size();
     floatMatrix.cols = output[0].size();
     floatMatrix.data = (float *)malloc(floatMatrix.rows * floatMatrix.cols * sizeof(float));
+    if (floatMatrix.data == nullptr) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_OUT_OF_MEMORY, "failed to allocate memory for embeddings");
+    }
     for (size_t i = 0; i < floatMatrix.rows; i++) {
         for (size_t j = 0; j < floatMatrix.cols; j++) {
             floatMatrix.data[i * floatMatrix.cols + j] = output[i][j];
@@ -320,40 +459,32 @@ void free_float_matrix(FloatMatrix * floatMatrix) {
 
 }
 
-// Creates embeddings from list of strings
-void embed(llama_embedder *embedder, const std::vector<std::string> & texts, std::vector<std::vector<float>> & output,
-           int32_t embd_norm) {
-    if (!embedder) {
-        throw std::runtime_error("Error: Null pointer passed to embed function");
-    }
-    if (texts.empty()){
-        fprintf(stderr, "Warn: empty prompts.\n");
-        return;
-    }
-    if (!output.empty()){
-        fprintf(stderr, "Warn: output is not empty.\n");
-        return;
-    }
+// Tokenizes texts for embedding, checking that every text fits into a batch. With truncate, texts that do not fit
+// keep their first tokens and their last (separator) token.
+static void tokenize_inputs(llama_embedder *embedder, const std::vector<std::string> &texts,
+                            std::vector<std::vector<int32_t>> &inputs, bool truncate = false) {
     llama_context *ctx = embedder->context;
     llama_model *model = embedder->model;
-    const enum llama_pooling_type pooling_type = llama_pooling_type(ctx);
-
 
     // max batch size
     const uint32_t n_batch = llama_n_batch(ctx);//params.n_batch;
     GGML_ASSERT(llama_n_batch(ctx) >= llama_n_ctx(ctx));
 
     // tokenize the prompts and trim
-    std::vector<std::vector<int32_t>> inputs;
     std::vector<llama_tokenizer_data> output_token_data;
     ::tokenize(embedder, texts, output_token_data);
     for (const auto &tokenizer_data : output_token_data) {
         auto inp = tokenizer_data.tokens;
+        if (inp.size() > n_batch && truncate) {
+            const int32_t last = inp.back();
+            inp.resize(n_batch - 1);
+            inp.push_back(last);
+        }
         if (inp.size() > n_batch) {
-            fprintf(stderr,
-                    "%s: error: number of tokens in input line (%lld) exceeds batch size (%lld), increase batch size and re-run\n",
-                    __func__, (long long int) inp.size(), (long long int) n_batch);
-            throw std::runtime_error("error: number of tokens in input line exceeds batch size");
+            throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INPUT_TOO_LONG,
+                                           "error: number of tokens in input line (" + std::to_string(inp.size()) +
+                                           ") exceeds batch size (" + std::to_string(n_batch) +
+                                           "), increase batch size or enable truncation");
         }
         inputs.push_back(inp);
     }
@@ -367,32 +498,178 @@ void embed(llama_embedder *embedder, const std::vector<std::string> & texts, std
                     __func__);
         }
     }
+}
 
-    // initialize batch
-    const size_t n_prompts = texts.size();
-    struct llama_batch batch = llama_batch_init( (int32_t )n_batch, 0, 1);
+static void decode_inputs(llama_embedder *embedder, const std::vector<std::vector<int32_t>> &inputs,
+                          std::vector<std::vector<float>> &output, int32_t embd_norm);
+static void output_shape(llama_embedder *embedder, const std::vector<std::vector<int32_t>> &inputs, size_t &rows,
+                         size_t &cols);
+static void decode_inputs_into(llama_embedder *embedder, const std::vector<std::vector<int32_t>> &inputs, float *emb,
+                               int32_t embd_norm);
+
+llama_embed_options llama_embed_default_options() {
+    llama_embed_options options;
+    options.embd_norm = 2; // euclidean
+    options.truncate = false;
+    return options;
+}
 
-    // count number of embeddings
-    size_t n_embd_count = 0;
+// Creates embeddings from list of strings
+void embed(llama_embedder *embedder, const std::vector<std::string> & texts, std::vector<std::vector<float>> & output,
+           int32_t embd_norm) {
+    llama_embed_options options = llama_embed_default_options();
+    options.embd_norm = embd_norm;
+    embed_with_options(embedder, texts, output, options);
+}
+
+// Creates embeddings from list of strings with per-call options
+void embed_with_options(llama_embedder *embedder, const std::vector<std::string> &texts,
+                        std::vector<std::vector<float>> &output, const llama_embed_options &options) {
+    if (!embedder) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "Error: Null pointer passed to embed function");
+    }
+    if (texts.empty()){
+        fprintf(stderr, "Warn: empty prompts.\n");
+        return;
+    }
+    if (!output.empty()){
+        fprintf(stderr, "Warn: output is not empty.\n");
+        return;
+    }
+    std::vector<std::vector<int32_t>> inputs;
+    tokenize_inputs(embedder, texts, inputs, options.truncate);
+    decode_inputs(embedder, inputs, output, options.embd_norm);
+}
+
+// Creates embeddings of texts directly in out, a caller-owned row-major buffer of out_len floats. rows and cols are set
+// to the shape of the result; if out_len is too small nothing is computed and an error is thrown.
+void embed_into(llama_embedder *embedder, const std::vector<std::string> &texts, float *out, size_t out_len,
+                size_t &rows, size_t &cols, const llama_embed_options &options) {
+    if (!embedder) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "Error: Null pointer passed to embed_into function");
+    }
+    if (texts.empty()) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_EMPTY_INPUT, "error: no texts to embed");
+    }
+    std::vector<std::vector<int32_t>> inputs;
+    tokenize_inputs(embedder, texts, inputs, options.truncate);
+    output_shape(embedder, inputs, rows, cols);
+    if (out == nullptr || out_len < rows * cols) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT,
+                                       "error: output buffer of " + std::to_string(out_len) + " values is too small for " +
+                                       std::to_string(rows) + "x" + std::to_string(cols) + " embeddings");
+    }
+    decode_inputs_into(embedder, inputs, out, options.embd_norm);
+}
+
+// Creates one embedding per token of each text, together with the token ids. Requires an embedder without pooling.
+void embed_tokens(llama_embedder *embedder, const std::vector<std::string> &texts,
+                  std::vector<llama_token_embeddings> &output, int32_t embd_norm) {
+    if (!embedder) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "Error: Null pointer passed to embed_tokens function");
+    }
+    if (llama_pooling_type(embedder->context) != LLAMA_POOLING_TYPE_NONE) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT,
+                                       "error: token embeddings require an embedder initialized without pooling");
+    }
+    output.clear();
+    if (texts.empty()) {
+        return;
+    }
+    std::vector<std::vector<int32_t>> inputs;
+    tokenize_inputs(embedder, texts, inputs);
+    std::vector<std::vector<float>> rows;
+    decode_inputs(embedder, inputs, rows, embd_norm);
+
+    // rows holds the token embeddings of all texts one after the other
+    output.resize(inputs.size());
+    size_t row = 0;
+    for (size_t k = 0; k < inputs.size(); k++) {
+        output[k].tokens = inputs[k];
+        output[k].embeddings.assign(rows.begin() + row, rows.begin() + row + inputs[k].size());
+        row += inputs[k].size();
+    }
+}
+
+TokenEmbeddings embed_tokens_c(llama_embedder *embedder, const char **texts, size_t text_len, int32_t embd_norm) {
+    std::vector<std::string> texts_inner(texts, texts + text_len);
+    std::vector<llama_token_embeddings> output;
+    embed_tokens(embedder, texts_inner, output, embd_norm);
+
+    TokenEmbeddings te = {nullptr, nullptr, nullptr, 0, 0};
+    size_t total = 0;
+    for (const auto &t : output) {
+        total += t.tokens.size();
+        if (te.cols == 0 && !t.embeddings.empty()) {
+            te.cols = t.embeddings[0].size();
+        }
+    }
+    te.rows = output.size();
+    te.lengths = (size_t *)malloc((te.rows > 0 ? te.rows : 1) * sizeof(size_t));
+    te.tokens = (int32_t *)malloc((total > 0 ? total : 1) * sizeof(int32_t));
+    te.data = (float *)malloc((total * te.cols > 0 ? total * te.cols : 1) * sizeof(float));
+    if (te.lengths == nullptr || te.tokens == nullptr || te.data == nullptr) {
+        free_token_embeddings(&te);
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_OUT_OF_MEMORY, "failed to allocate memory for token embeddings");
+    }
+    size_t offset = 0;
+    for (size_t k = 0; k < output.size(); k++) {
+        te.lengths[k] = output[k].tokens.size();
+        for (size_t t = 0; t < output[k].tokens.size(); t++) {
+            te.tokens[offset + t] = output[k].tokens[t];
+            std::memcpy(te.data + (offset + t) * te.cols, output[k].embeddings[t].data(), te.cols * sizeof(float));
+        }
+        offset += output[k].tokens.size();
+    }
+    return te;
+}
+
+void free_token_embeddings(TokenEmbeddings *te) {
+    if (te != nullptr) {
+        free(te->data);
+        te->data = nullptr;
+        free(te->tokens);
+        te->tokens = nullptr;
+        free(te->lengths);
+        te->lengths = nullptr;
+    }
+}
+
+// Returns the shape of the output of decode_inputs. Without pooling there is one output row per token of every input,
+// with rank pooling each output row holds a single score.
+static void output_shape(llama_embedder *embedder, const std::vector<std::vector<int32_t>> &inputs, size_t &rows,
+                         size_t &cols) {
+    const enum llama_pooling_type pooling_type = llama_pooling_type(embedder->context);
+    rows = 0;
     if (pooling_type == LLAMA_POOLING_TYPE_NONE) {
-        for (int k = 0; k < n_prompts; k++) {
-            n_embd_count += inputs[k].size();
+        for (const auto &inp : inputs) {
+            rows += inp.size();
         }
     } else {
-        n_embd_count = n_prompts;
+        rows = inputs.size();
     }
+    cols = pooling_type == LLAMA_POOLING_TYPE_RANK ? 1 : llama_n_embd(embedder->model);
+}
 
-    // allocate output
-    const int n_embd = llama_n_embd(model);
-    std::vector<float> embeddings(n_embd_count * n_embd, 0);
-    float *emb = embeddings.data();
-    // Resize the outer vector to have n_prompts rows
-    output.resize(n_prompts);
+// Runs the tokenized inputs through the model in batches, writing the output rows (see output_shape) into emb
+static void decode_inputs_into(llama_embedder *embedder, const std::vector<std::vector<int32_t>> &inputs, float *emb,
+                               int32_t embd_norm) {
+    llama_context *ctx = embedder->context;
+    const enum llama_pooling_type pooling_type = llama_pooling_type(ctx);
+    const uint32_t n_batch = llama_n_batch(ctx);
+    size_t n_embd_count = 0;
+    size_t n_cols = 0;
+    output_shape(embedder, inputs, n_embd_count, n_cols);
+    const int n_embd = (int) n_cols;
 
-    // Resize each inner vector to have n_embd columns
-    for (int i = 0; i < n_prompts; ++i) {
-        output[i].resize(n_embd);
-    }
+    // initialize batch
+    const size_t n_prompts = inputs.size();
+    struct llama_batch batch = llama_batch_init( (int32_t )n_batch, 0, 1);
+    // free the batch also when decoding throws
+    struct batch_guard {
+        llama_batch &batch;
+        ~batch_guard() { llama_batch_free(batch); }
+    } guard{batch};
 
     // break into batches
     int e = 0; // number of embeddings already stored
@@ -405,6 +682,7 @@ void embed(llama_embedder *embedder, const std::vector<std::string> & texts, std
 
         // encode if at capacity
         if (batch.n_tokens + n_toks > n_batch) {
+            check_cancelled(embedder);
             float *out = emb + e * n_embd;
             batch_decode(ctx, batch, out, s, n_embd, embd_norm);
             e += pooling_type == LLAMA_POOLING_TYPE_NONE ? batch.n_tokens : s;
@@ -418,22 +696,217 @@ void embed(llama_embedder *embedder, const std::vector<std::string> & texts, std
     }
 
     // final batch
+    check_cancelled(embedder);
     float *out = emb + e * n_embd;
     batch_decode(ctx, batch, out, s, n_embd, embd_norm);
+}
+
+// Runs the tokenized inputs through the model, one output row per prompt, or per token of every prompt when pooling is
+// disabled
+static void decode_inputs(llama_embedder *embedder, const std::vector<std::vector<int32_t>> &inputs,
+                          std::vector<std::vector<float>> &output, int32_t embd_norm) {
+    size_t n_embd_count = 0;
+    size_t n_embd = 0;
+    output_shape(embedder, inputs, n_embd_count, n_embd);
+    std::vector<float> embeddings(n_embd_count * n_embd, 0);
+    decode_inputs_into(embedder, inputs, embeddings.data(), embd_norm);
 
+    output.resize(n_embd_count);
+    for (size_t j = 0; j < n_embd_count; j++) {
+        output[j].assign(embeddings.begin() + j * n_embd, embeddings.begin() + (j + 1) * n_embd);
+    }
+}
 
-    if (pooling_type == LLAMA_POOLING_TYPE_NONE) {
-        for (int j = 0; j < n_embd_count; j++) {
-            for (int i = 0; i < n_embd; i++) {
-                output[j][i] = emb[j * n_embd + i];
-            }
+// Scores each document against the query with a cross-encoder (reranker) model loaded with rank pooling
+void rerank(llama_embedder *embedder, const std::string &query, const std::vector<std::string> &documents,
+            std::vector<float> &output) {
+    if (!embedder) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "Error: Null pointer passed to rerank function");
+    }
+    if (llama_pooling_type(embedder->context) != LLAMA_POOLING_TYPE_RANK) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT,
+                                       "error: rerank requires an embedder initialized with rank pooling");
+    }
+    output.clear();
+    if (documents.empty()) {
+        return;
+    }
+    llama_context *ctx = embedder->context;
+    llama_model *model = embedder->model;
+    const uint32_t n_batch = llama_n_batch(ctx);
+
+    // [BOS]query[EOS][SEP]document[EOS], the input format expected by llama.cpp rerankers
+    const std::vector<llama_token> query_tokens = ::llama_tokenize(ctx, query, false, false);
+    std::vector<std::vector<int32_t>> inputs;
+    inputs.reserve(documents.size());
+    for (const auto &document : documents) {
+        std::vector<int32_t> inp;
+        inp.push_back(llama_token_bos(model));
+        inp.insert(inp.end(), query_tokens.begin(), query_tokens.end());
+        inp.push_back(llama_token_eos(model));
+        inp.push_back(llama_token_sep(model));
+        const std::vector<llama_token> doc_tokens = ::llama_tokenize(ctx, document, false, false);
+        inp.insert(inp.end(), doc_tokens.begin(), doc_tokens.end());
+        inp.push_back(llama_token_eos(model));
+        if (inp.size() > n_batch) {
+            throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INPUT_TOO_LONG,
+                                           "error: number of tokens in query and document exceeds batch size");
         }
-    } else {
-        for (int j = 0; j < n_prompts; j++) {
-            for (int i = 0; i < n_embd; i++) {
-                output[j][i] = emb[j * n_embd + i];
-            }
+        inputs.push_back(inp);
+    }
+
+    std::vector<std::vector<float>> scores;
+    decode_inputs(embedder, inputs, scores, -1);
+    output.reserve(scores.size());
+    for (const auto &score : scores) {
+        output.push_back(score[0]);
+    }
+}
+
+FloatMatrix rerank_c(llama_embedder *embedder, const char *query, const char **documents, size_t document_len) {
+    std::vector<std::string> documents_inner;
+    documents_inner.reserve(document_len);
+    for (size_t i = 0; i < document_len; i++) {
+        documents_inner.emplace_back(documents[i]);
+    }
+    std::vector<float> output;
+    FloatMatrix floatMatrix = {nullptr, 0, 0};
+    rerank(embedder, query, documents_inner, output);
+    if (output.empty()) {
+        return floatMatrix;
+    }
+    floatMatrix.rows = output.size();
+    floatMatrix.cols = 1;
+    floatMatrix.data = (float *)malloc(output.size() * sizeof(float));
+    if (floatMatrix.data == nullptr) {
+        throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_OUT_OF_MEMORY, "failed to allocate memory for scores");
+    }
+    std::memcpy(floatMatrix.data, output.data(), output.size() * sizeof(float));
+    return floatMatrix;
+}
+
+// Reports the exception currently being handled through error
+static void set_error(llama_embedder_error *error) noexcept {
+    if (error == nullptr) {
+        return;
+    }
+    llama_embedder_status status = LLAMA_EMBEDDER_ERROR_UNKNOWN;
+    const char *message = "unknown error";
+    try {
+        throw;
+    } catch (const llama_embedder_exception &e) {
+        status = e.status;
+        message = e.what();
+    } catch (const std::bad_alloc &e) {
+        status = LLAMA_EMBEDDER_ERROR_OUT_OF_MEMORY;
+        message = e.what();
+    } catch (const std::exception &e) {
+        message = e.what();
+    } catch (...) {
+    }
+    error->code = status;
+    snprintf(error->message, sizeof(error->message), "%s", message);
+}
+
+static void clear_error(llama_embedder_error *error) noexcept {
+    if (error != nullptr) {
+        error->code = LLAMA_EMBEDDER_OK;
+        error->message[0] = '\0';
+    }
+}
+
+llama_embedder *init_embedder_e(const char *embedding_model, const llama_embedder_params *embedder_params,
+                                llama_embedder_error *error) noexcept {
+    clear_error(error);
+    try {
+        return init_embedder_with_params(embedding_model, embedder_params);
+    } catch (...) {
+        set_error(error);
+    }
+    return nullptr;
+}
+
+llama_embedder *clone_embedder_e(llama_embedder *base, llama_embedder_error *error) noexcept {
+    clear_error(error);
+    try {
+        return clone_embedder(base);
+    } catch (...) {
+        set_error(error);
+    }
+    return nullptr;
+}
+
+FloatMatrix embed_e(llama_embedder *embedder, const char **texts, size_t text_len, const llama_embed_options *options,
+                    llama_embedder_error *error) noexcept {
+    clear_error(error);
+    try {
+        if (text_len == 0) {
+            throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_EMPTY_INPUT, "error: no texts to embed");
+        }
+        return embed_with_options_c(embedder, texts, text_len, options);
+    } catch (...) {
+        set_error(error);
+    }
+    return {nullptr, 0, 0};
+}
+
+TokenEmbeddings embed_tokens_e(llama_embedder *embedder, const char **texts, size_t text_len, int32_t embd_norm,
+                               llama_embedder_error *error) noexcept {
+    clear_error(error);
+    try {
+        if (text_len == 0) {
+            throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_EMPTY_INPUT, "error: no texts to embed");
+        }
+        return embed_tokens_c(embedder, texts, text_len, embd_norm);
+    } catch (...) {
+        set_error(error);
+    }
+    return {nullptr, nullptr, nullptr, 0, 0};
+}
+
+FloatMatrix rerank_e(llama_embedder *embedder, const char *query, const char **documents, size_t document_len,
+                     llama_embedder_error *error) noexcept {
+    clear_error(error);
+    try {
+        if (document_len == 0) {
+            throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_EMPTY_INPUT, "error: no documents to rerank");
         }
+        return rerank_c(embedder, query, documents, document_len);
+    } catch (...) {
+        set_error(error);
     }
-    llama_batch_free(batch);
-}
\ No newline at end of file
+    return {nullptr, 0, 0};
+}
+
+FloatMatrix embed_cancellable_e(llama_embedder *embedder, const char **texts, size_t text_len,
+                                const llama_embed_options *options, const volatile int32_t *cancel,
+                                llama_embedder_error *error) noexcept {
+    if (embedder == nullptr) {
+        return embed_e(embedder, texts, text_len, options, error);
+    }
+    // the flag is only observed for the duration of this call
+    struct cancel_scope {
+        llama_embedder *embedder;
+        ~cancel_scope() { embedder->cancel = nullptr; }
+    } scope{embedder};
+    embedder->cancel = cancel;
+    return embed_e(embedder, texts, text_len, options, error);
+}
+
+int32_t embed_into_e(llama_embedder *embedder, const char **texts, size_t text_len, const llama_embed_options *options,
+                     float *out, size_t out_len, size_t *rows, size_t *cols, llama_embedder_error *error) noexcept {
+    clear_error(error);
+    try {
+        if (options == nullptr || rows == nullptr || cols == nullptr) {
+            throw llama_embedder_exception(LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT, "error: null argument passed to embed_into_e");
+        }
+        *rows = 0;
+        *cols = 0;
+        std::vector<std::string> texts_inner(texts, texts + text_len);
+        embed_into(embedder, texts_inner, out, out_len, *rows, *cols, *options);
+        return LLAMA_EMBEDDER_OK;
+    } catch (...) {
+        set_error(error);
+    }
+    return error != nullptr ? error->code : LLAMA_EMBEDDER_ERROR_UNKNOWN;
+}
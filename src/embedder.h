@@ -2,7 +2,10 @@
 // Created by Trayan Azarov on 28.08.24.
 //
 #include <vector>
+#include <memory>
 #include <unordered_map>
+#include <stdexcept>
+#include <string>
 
 #ifndef LLAMA_CPP_EMBEDDING_H
 #define LLAMA_CPP_EMBEDDING_H
@@ -21,10 +24,18 @@
 #endif
 
 
+// ABI version of the C functions and structs below. It changes whenever a binding built against an older header can
+// no longer use the library, bindings compare it with the version they were built against.
+#define LLAMA_EMBEDDER_ABI_VERSION 1
+
+struct llama_embedder_model;
+
 struct llama_embedder {
     struct llama_model   * model   = nullptr;
     struct llama_context * context = nullptr;
     std::unordered_map<std::string, std::string> model_metadata;
+    std::shared_ptr<llama_embedder_model> shared_model; // owns model, shared with the clones of the embedder
+    const volatile int32_t * cancel = nullptr; // cancellation flag of the running call, see embed_cancellable_e
 };
 
 struct llama_tokenizer_data {
@@ -32,6 +43,11 @@ struct llama_tokenizer_data {
     std::vector<int32_t> attention_mask;
 };
 
+struct llama_token_embeddings {
+    std::vector<int32_t> tokens;
+    std::vector<std::vector<float>> embeddings;
+};
+
 extern "C" {
 typedef struct {
     float *data;
@@ -39,18 +55,106 @@ typedef struct {
     size_t cols;
 } FloatMatrix;
 
+// Context parameters of an embedder. Zero values keep the llama.cpp defaults, see llama_embedder_default_params.
+typedef struct {
+    uint32_t pooling_type;
+    uint32_t n_ctx;     // context size, 0 uses the training context size of the model
+    uint32_t n_batch;   // logical batch size, the maximum number of tokens of a text
+    uint32_t n_ubatch;  // physical batch size, 0 uses n_batch as required by non-causal models
+    int32_t n_threads;  // threads used for generation and batch processing, 0 uses the number of CPU cores
+    bool use_mmap;
+    bool use_mlock;
+    bool flash_attn;
+} llama_embedder_params;
+
+// Per-call embedding options, see llama_embed_default_options
+typedef struct {
+    int32_t embd_norm;  // normalization type, -1 none, 0 max absolute int16, 1 taxicab, 2 euclidean, >2 p-norm
+    bool truncate;      // truncate texts longer than the batch size instead of failing
+} llama_embed_options;
+
+// Token embeddings of rows texts. Text i has lengths[i] tokens; the ids of all tokens follow each other in tokens and
+// their embeddings of cols values in data.
+typedef struct {
+    float *data;
+    int32_t *tokens;
+    size_t *lengths;
+    size_t rows;
+    size_t cols;
+} TokenEmbeddings;
+
 typedef struct {
     const char* key;
     const char* value;
 } MetadataPair;
 
+// Status codes of llama_embedder_error
+typedef enum {
+    LLAMA_EMBEDDER_OK = 0,
+    LLAMA_EMBEDDER_ERROR_UNKNOWN = 1,
+    LLAMA_EMBEDDER_ERROR_LIBRARY_LOAD = 2,     // reserved for bindings that load the shared library dynamically
+    LLAMA_EMBEDDER_ERROR_MODEL_NOT_FOUND = 3,
+    LLAMA_EMBEDDER_ERROR_MODEL_LOAD = 4,
+    LLAMA_EMBEDDER_ERROR_INPUT_TOO_LONG = 5,
+    LLAMA_EMBEDDER_ERROR_EMPTY_INPUT = 6,
+    LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT = 7,
+    LLAMA_EMBEDDER_ERROR_NOT_SUPPORTED = 8,
+    LLAMA_EMBEDDER_ERROR_DECODE = 9,
+    LLAMA_EMBEDDER_ERROR_OUT_OF_MEMORY = 10,
+    LLAMA_EMBEDDER_ERROR_CANCELLED = 11,
+} llama_embedder_status;
+
+// Error of a single call of the *_e functions. code is LLAMA_EMBEDDER_OK on success.
+typedef struct {
+    int32_t code;
+    char message[512];
+} llama_embedder_error;
+
+// Version of the library set by the build, e.g. v0.0.9, or dev for local builds
+EXPORT_SYMBOL const char * llama_embedder_version() noexcept;
+// LLAMA_EMBEDDER_ABI_VERSION of the library
+EXPORT_SYMBOL int32_t llama_embedder_abi_version() noexcept;
 EXPORT_SYMBOL llama_embedder * init_embedder(const char * embedding_model, uint32_t pooling_type) noexcept(false);
+EXPORT_SYMBOL llama_embedder_params llama_embedder_default_params();
+EXPORT_SYMBOL llama_embedder * init_embedder_with_params(const char * embedding_model, const llama_embedder_params * embedder_params) noexcept(false);
 EXPORT_SYMBOL void free_embedder(llama_embedder *embedder) noexcept;
 EXPORT_SYMBOL void embed(llama_embedder * embedder, const std::vector<std::string> & texts, std::vector<std::vector<float>> & output, int32_t embd_norm) noexcept(false);
+EXPORT_SYMBOL llama_embed_options llama_embed_default_options();
+EXPORT_SYMBOL void embed_with_options(llama_embedder * embedder, const std::vector<std::string> & texts, std::vector<std::vector<float>> & output, const llama_embed_options & options) noexcept(false);
+EXPORT_SYMBOL void embed_into(llama_embedder * embedder, const std::vector<std::string> & texts, float * out, size_t out_len, size_t & rows, size_t & cols, const llama_embed_options & options) noexcept(false);
+EXPORT_SYMBOL FloatMatrix embed_with_options_c(llama_embedder * embedder, const char ** texts, size_t text_len, const llama_embed_options * options) noexcept(false);
 EXPORT_SYMBOL FloatMatrix embed_c(llama_embedder * embedder, const char  ** texts,size_t  text_len, int32_t embd_norm) noexcept(false);
 EXPORT_SYMBOL void free_float_matrix(FloatMatrix * floatMatrix);
 EXPORT_SYMBOL void get_metadata(llama_embedder * embedder, std::unordered_map<std::string, std::string> &output) noexcept(false);
 EXPORT_SYMBOL int get_metadata_c(llama_embedder * embedder,MetadataPair** pairs, size_t* count) noexcept(false);
 EXPORT_SYMBOL void free_metadata_c(MetadataPair* metadata_array, size_t size);
+EXPORT_SYMBOL void embed_tokens(llama_embedder * embedder, const std::vector<std::string> & texts, std::vector<llama_token_embeddings> & output, int32_t embd_norm) noexcept(false);
+EXPORT_SYMBOL TokenEmbeddings embed_tokens_c(llama_embedder * embedder, const char ** texts, size_t text_len, int32_t embd_norm) noexcept(false);
+EXPORT_SYMBOL void free_token_embeddings(TokenEmbeddings * tokenEmbeddings);
+EXPORT_SYMBOL void rerank(llama_embedder * embedder, const std::string & query, const std::vector<std::string> & documents, std::vector<float> & output) noexcept(false);
+EXPORT_SYMBOL FloatMatrix rerank_c(llama_embedder * embedder, const char * query, const char ** documents, size_t document_len) noexcept(false);
 EXPORT_SYMBOL void tokenize(llama_embedder * embedder, const std::vector<std::string>& texts, std::vector<llama_tokenizer_data> &output, bool add_special_tokens = true, bool parse_special = false, bool enable_padding = false) noexcept(false);
-}
\ No newline at end of file
+
+// The *_e functions never throw, failures are reported through error
+EXPORT_SYMBOL llama_embedder * init_embedder_e(const char * embedding_model, const llama_embedder_params * embedder_params, llama_embedder_error * error) noexcept;
+// Creates an embedder with a new context over the model of base, using the same context parameters. Each context can be
+// used by one thread while other threads use the others. The model is freed with the last embedder using it, so base
+// and its clones can be freed in any order.
+EXPORT_SYMBOL llama_embedder * clone_embedder_e(llama_embedder * base, llama_embedder_error * error) noexcept;
+EXPORT_SYMBOL FloatMatrix embed_e(llama_embedder * embedder, const char ** texts, size_t text_len, const llama_embed_options * options, llama_embedder_error * error) noexcept;
+EXPORT_SYMBOL TokenEmbeddings embed_tokens_e(llama_embedder * embedder, const char ** texts, size_t text_len, int32_t embd_norm, llama_embedder_error * error) noexcept;
+EXPORT_SYMBOL FloatMatrix rerank_e(llama_embedder * embedder, const char * query, const char ** documents, size_t document_len, llama_embedder_error * error) noexcept;
+// Like embed_e, but the call stops as soon as *cancel becomes non-zero: between batches, and within a batch through the
+// llama.cpp abort callback. A cancelled call fails with LLAMA_EMBEDDER_ERROR_CANCELLED.
+EXPORT_SYMBOL FloatMatrix embed_cancellable_e(llama_embedder * embedder, const char ** texts, size_t text_len, const llama_embed_options * options, const volatile int32_t * cancel, llama_embedder_error * error) noexcept;
+EXPORT_SYMBOL int32_t embed_into_e(llama_embedder * embedder, const char ** texts, size_t text_len, const llama_embed_options * options, float * out, size_t out_len, size_t * rows, size_t * cols, llama_embedder_error * error) noexcept;
+}
+
+// Exception thrown by the embedder functions, status tells the kind of failure
+class llama_embedder_exception : public std::runtime_error {
+public:
+    llama_embedder_exception(llama_embedder_status status, const std::string &message)
+        : std::runtime_error(message), status(status) {}
+
+    llama_embedder_status status;
+};
\ No newline at end of file
@@ -169,6 +169,129 @@ EXPECT_EQ(get_mask_size(tokenizer_data.tokens), 6); // this works for the given
 free_embedder(embedder);
 }
 
+TEST(EmbedderTest, InitEModelNotFound) {
+llama_embedder_params params = llama_embedder_default_params();
+llama_embedder_error error;
+llama_embedder* embedder = init_embedder_e("does-not-exist.gguf", &params, &error);
+EXPECT_EQ(embedder, nullptr);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_ERROR_MODEL_NOT_FOUND);
+EXPECT_NE(std::string(error.message).find("does-not-exist.gguf"), std::string::npos);
+}
+
+TEST(EmbedderTest, EmbedEErrors) {
+const char* valid_model_path = "snowflake-arctic-embed-s/snowflake-arctic-embed-s-f16.GGUF";
+llama_embedder_params params = llama_embedder_default_params();
+params.n_ctx = 32;
+params.n_batch = 32;
+llama_embedder_error error;
+llama_embedder* embedder = init_embedder_e(valid_model_path, &params, &error);
+ASSERT_NE(embedder, nullptr);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_OK);
+llama_embed_options options = llama_embed_default_options();
+
+FloatMatrix output = embed_e(embedder, nullptr, 0, &options, &error);
+EXPECT_EQ(output.data, nullptr);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_ERROR_EMPTY_INPUT);
+
+std::string long_text;
+for (int i = 0; i < 100; i++) {
+long_text += "hello world ";
+}
+const char * texts[] = {long_text.c_str()};
+output = embed_e(embedder, texts, 1, &options, &error);
+EXPECT_EQ(output.data, nullptr);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_ERROR_INPUT_TOO_LONG);
+
+options.truncate = true;
+output = embed_e(embedder, texts, 1, &options, &error);
+EXPECT_NE(output.data, nullptr);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_OK);
+EXPECT_EQ(output.cols, 384);
+
+free_float_matrix(&output);
+free_embedder(embedder);
+}
+
+TEST(EmbedderTest, EmbedIntoE) {
+const char* valid_model_path = "snowflake-arctic-embed-s/snowflake-arctic-embed-s-f16.GGUF";
+llama_embedder_params params = llama_embedder_default_params();
+llama_embedder_error error;
+llama_embedder* embedder = init_embedder_e(valid_model_path, &params, &error);
+ASSERT_NE(embedder, nullptr);
+llama_embed_options options = llama_embed_default_options();
+const char * texts[] = {"Hello world", "My name is Ishmael"};
+size_t rows = 0;
+size_t cols = 0;
+
+std::vector<float> small(10);
+EXPECT_NE(embed_into_e(embedder, texts, 2, &options, small.data(), small.size(), &rows, &cols, &error), LLAMA_EMBEDDER_OK);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT);
+EXPECT_EQ(rows, 2);
+EXPECT_EQ(cols, 384);
+
+std::vector<float> out(rows * cols);
+EXPECT_EQ(embed_into_e(embedder, texts, 2, &options, out.data(), out.size(), &rows, &cols, &error), LLAMA_EMBEDDER_OK);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_OK);
+EXPECT_NE(out[384], 0.0f);
+
+free_embedder(embedder);
+}
+
+TEST(EmbedderTest, EmbedCancellableE) {
+const char* valid_model_path = "snowflake-arctic-embed-s/snowflake-arctic-embed-s-f16.GGUF";
+llama_embedder_params params = llama_embedder_default_params();
+llama_embedder_error error;
+llama_embedder* embedder = init_embedder_e(valid_model_path, &params, &error);
+ASSERT_NE(embedder, nullptr);
+llama_embed_options options = llama_embed_default_options();
+const char * texts[] = {"Hello world"};
+
+volatile int32_t cancel = 1;
+FloatMatrix output = embed_cancellable_e(embedder, texts, 1, &options, &cancel, &error);
+EXPECT_EQ(output.data, nullptr);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_ERROR_CANCELLED);
+
+cancel = 0;
+output = embed_cancellable_e(embedder, texts, 1, &options, &cancel, &error);
+EXPECT_NE(output.data, nullptr);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_OK);
+
+free_float_matrix(&output);
+free_embedder(embedder);
+}
+
+TEST(EmbedderTest, CloneEmbedderE) {
+const char* valid_model_path = "snowflake-arctic-embed-s/snowflake-arctic-embed-s-f16.GGUF";
+llama_embedder_params params = llama_embedder_default_params();
+llama_embedder_error error;
+llama_embedder* embedder = init_embedder_e(valid_model_path, &params, &error);
+ASSERT_NE(embedder, nullptr);
+llama_embedder* clone = clone_embedder_e(embedder, &error);
+ASSERT_NE(clone, nullptr);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_OK);
+EXPECT_NE(clone->context, embedder->context);
+EXPECT_EQ(clone->model, embedder->model);
+
+// the clone keeps the model alive after the embedder it was created from is freed
+free_embedder(embedder);
+llama_embed_options options = llama_embed_default_options();
+const char * texts[] = {"Hello world"};
+FloatMatrix output = embed_e(clone, texts, 1, &options, &error);
+EXPECT_NE(output.data, nullptr);
+EXPECT_EQ(output.cols, 384);
+
+free_float_matrix(&output);
+free_embedder(clone);
+
+EXPECT_EQ(clone_embedder_e(nullptr, &error), nullptr);
+EXPECT_EQ(error.code, LLAMA_EMBEDDER_ERROR_INVALID_ARGUMENT);
+}
+
+TEST(EmbedderTest, Version) {
+EXPECT_EQ(llama_embedder_abi_version(), LLAMA_EMBEDDER_ABI_VERSION);
+EXPECT_GT(std::string(llama_embedder_version()).size(), 0);
+}
+
 int main(int argc, char **argv) {
     ::testing::InitGoogleTest(&argc, argv);
     return RUN_ALL_TESTS();